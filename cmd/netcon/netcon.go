@@ -8,9 +8,18 @@ import (
 )
 
 func main() {
-	command := command.NewNetconCommand()
+	// ssh から SSH_ASKPASS として呼び出された場合 (netcon instance ssh)
+	if command.RunAskpass() {
+		return
+	}
+
+	cmd := command.NewNetconCommand()
 
-	if err := command.Execute(); err != nil {
+	if err := cmd.Execute(); err != nil {
+		// 子プロセスの終了コードなどを引き継ぐ
+		if exitErr, ok := err.(*command.ExitError); ok {
+			os.Exit(exitErr.Code)
+		}
 		fmt.Println(err)
 		os.Exit(1)
 	}
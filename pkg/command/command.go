@@ -1,6 +1,10 @@
 package command
 
-import "github.com/spf13/cobra"
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
 
 const (
 	cliName        = "netcon"
@@ -13,12 +17,30 @@ func NewNetconCommand() *cobra.Command {
 		Short: cliDescription,
 	}
 
+	addOutputFlag(rootCmd)
+	addShowSecretsFlag(rootCmd)
+	addProfileFlags(rootCmd)
+	addLogFlags(rootCmd)
+
 	rootCmd.AddCommand(
 		NewSchedulerCommand(),
 		NewScoreserverCommand(),
 		NewVmmsCommand(),
 		NewContestCommand(),
+		NewConfigCommand(),
+		NewInstanceCommand(),
+		NewDevCommand(),
 	)
 
 	return rootCmd
 }
+
+// ExitError 指定した終了コードでnetconを終了するためのエラー
+// RunE の中で os.Exit すると defer が実行されないため、main で終了する
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
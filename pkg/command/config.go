@@ -0,0 +1,112 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/janog-netcon/netcon-cli/pkg/config"
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v2"
+)
+
+func NewConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "config",
+	}
+
+	cmd.AddCommand(
+		NewConfigMigrateCommand(),
+	)
+
+	return cmd
+}
+
+func NewConfigMigrateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "旧形式のschedulerの設定ファイルとマッピングファイルをコンテスト定義ファイルに変換する",
+		RunE:  configMigrateCommandFunc,
+	}
+
+	flags := cmd.Flags()
+	flags.StringP("scheduler-config", "", "", "schedulerの設定ファイル (旧形式)")
+	flags.StringP("mapping-file-path", "", "", "contest init のマッピングファイル (旧形式)")
+	flags.StringP("output-file", "", "", "出力先のファイル (指定しない場合は標準出力)")
+
+	return cmd
+}
+
+func configMigrateCommandFunc(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+
+	schedulerConfigPath, err := flags.GetString("scheduler-config")
+	if err != nil {
+		return err
+	}
+	mappingFilePath, err := flags.GetString("mapping-file-path")
+	if err != nil {
+		return err
+	}
+	outputFile, err := flags.GetString("output-file")
+	if err != nil {
+		return err
+	}
+
+	if schedulerConfigPath == "" && mappingFilePath == "" {
+		return xerrors.New("--scheduler-config か --mapping-file-path の少なくとも一方を指定してください")
+	}
+
+	var cfg *types.SchedulerConfig
+	if schedulerConfigPath != "" {
+		bytes, err := ioutil.ReadFile(schedulerConfigPath)
+		if err != nil {
+			return err
+		}
+		cfg = &types.SchedulerConfig{}
+		if err := yaml.Unmarshal(bytes, cfg); err != nil {
+			return err
+		}
+	}
+
+	ml := []types.Mapping{}
+	if mappingFilePath != "" {
+		bytes, err := ioutil.ReadFile(mappingFilePath)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(bytes, &ml); err != nil {
+			return err
+		}
+	}
+
+	def := config.Migrate(cfg, ml)
+
+	lg, err := newLogger(cmd, "")
+	if err != nil {
+		return err
+	}
+	for _, w := range config.Validate(def) {
+		lg.Warn(w)
+	}
+
+	b, err := yaml.Marshal(def)
+	if err != nil {
+		return err
+	}
+
+	// コンテスト定義ファイルそのものを出力するため、-o/--output によらず常にyamlで出力する
+	if outputFile == "" {
+		os.Stdout.Write(b)
+		return nil
+	}
+
+	if err := ioutil.WriteFile(outputFile, b, 0600); err != nil {
+		return err
+	}
+	lg.Info("wrote file", zap.String("file", outputFile))
+
+	return nil
+}
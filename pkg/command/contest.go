@@ -2,11 +2,20 @@ package command
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
 	"time"
 
+	"github.com/janog-netcon/netcon-cli/pkg/config"
+	"github.com/janog-netcon/netcon-cli/pkg/logging"
+	"github.com/janog-netcon/netcon-cli/pkg/types"
 	"github.com/janog-netcon/netcon-cli/pkg/vmms"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 	"golang.org/x/xerrors"
 	"gopkg.in/yaml.v2"
 )
@@ -18,12 +27,12 @@ func NewContestCommand() *cobra.Command {
 
 	cmd.AddCommand(
 		NewContestInitCommand(),
+		NewContestTeardownCommand(),
+		NewContestStatusCommand(),
+		NewContestScaffoldCommand(),
+		NewContestCostCommand(),
 	)
 
-	flags := cmd.PersistentFlags()
-	flags.StringP("vmms-endpoint", "", "http://127.0.0.1:8950", "vm-management-server Endpoint")
-	flags.StringP("vmms-credential", "", "", "Token")
-
 	return cmd
 }
 
@@ -35,13 +44,21 @@ func NewContestInitCommand() *cobra.Command {
 
 	flags := cmd.Flags()
 	flags.StringP("mapping-file-path", "", "", "problem-idとmachine-image-idのマッピング情報が書いてあるファイルを指定する")
-	flags.UintP("count", "c", 1, "何台ずつ作成するか")
-
-	cmd.MarkFlagRequired("mapping-file-path")
+	flags.StringP("config", "", "", "コンテスト定義ファイルを指定する (--mapping-file-path の代わりに使える)")
+	flags.UintP("count", "c", 1, "何台ずつ作成するか (マッピングに count が書かれている場合はそちらを優先する)")
+	flags.IntP("max-retries", "", 5, "1台の作成に失敗したときに何回までリトライするか (負の値の場合は成功するまでリトライする)")
+	flags.IntP("retry-interval", "", 5, "リトライするまでに待つ秒数")
+	flags.UintP("parallel", "p", 1, "同時に作成するインスタンス数")
+	flags.StringP("checkpoint-file-path", "", defaultCheckpointFilePath, "作成が完了したインスタンスを記録するファイル")
+	flags.BoolP("resume", "", false, "checkpointファイルに記録されている作成済みのインスタンスを除いて作成を再開する")
+	flags.BoolP("overwrite-checkpoint", "", false, "既にあるcheckpointファイルの記録を破棄して、最初から作成する")
 
 	return cmd
 }
 
+const defaultCheckpointFilePath = "./contest-init.checkpoint.yaml"
+
+// mapping checkpointで使用するマッピングのkey (台数は含まない)
 type mapping struct {
 	ProblemID        string `yaml:"problem_id"`
 	MachineImageName string `yaml:"machine_image_name"`
@@ -49,85 +66,351 @@ type mapping struct {
 	Zone             string `yaml:"zone"`
 }
 
+func newMapping(m types.Mapping) mapping {
+	return mapping{
+		ProblemID:        m.ProblemID,
+		MachineImageName: m.MachineImageName,
+		Project:          m.Project,
+		Zone:             m.Zone,
+	}
+}
+
+// checkpointEntry マッピング1件ごとの作成済みインスタンス
+type checkpointEntry struct {
+	mapping   `yaml:",inline"`
+	Instances []string `yaml:"instances"`
+}
+
+// checkpoint contest init で作成が完了したインスタンスの記録
+// 途中で処理が止まった場合に --resume で続きから作成するために使う
+type checkpoint struct {
+	Entries []*checkpointEntry `yaml:"entries"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// loadCheckpoint checkpointファイルを読み込む。ファイルが存在しない場合は空のcheckpointを返す
+func loadCheckpoint(path string) (*checkpoint, error) {
+	cp := &checkpoint{path: path}
+
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(bytes, cp); err != nil {
+		return nil, err
+	}
+
+	return cp, nil
+}
+
+// openCheckpoint contest init で使うcheckpointを返す
+// resume の場合は path の記録を読み込み、そうでない場合は空のcheckpointを返す (最初の記録でファイルを上書きする)
+// 既にファイルがある場合は、overwrite でなければ記録を失わないようにエラーにする
+func openCheckpoint(path string, resume, overwrite bool) (*checkpoint, error) {
+	if resume && overwrite {
+		return nil, xerrors.New("--resume と --overwrite-checkpoint は同時に指定できません")
+	}
+	if resume {
+		return loadCheckpoint(path)
+	}
+	if _, err := os.Stat(path); err == nil && !overwrite {
+		return nil, xerrors.New(fmt.Sprintf("checkpoint file %s already exists. use --resume to continue, or --overwrite-checkpoint to start over", path))
+	}
+	return &checkpoint{path: path}, nil
+}
+
+// entry マッピングに対応するentryを返す。存在しない場合は追加する
+func (cp *checkpoint) entry(m mapping) *checkpointEntry {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	for _, e := range cp.Entries {
+		if e.mapping == m {
+			return e
+		}
+	}
+
+	e := &checkpointEntry{mapping: m, Instances: []string{}}
+	cp.Entries = append(cp.Entries, e)
+	return e
+}
+
+// record 作成したインスタンスを記録し、ファイルに書き出す
+func (cp *checkpoint) record(m mapping, instanceName string) error {
+	e := cp.entry(m)
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	e.Instances = append(e.Instances, instanceName)
+
+	bytes, err := yaml.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	// 書き込み途中で落ちてもファイルが壊れないように、一時ファイルに書いてからrenameする
+	tmp := cp.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, bytes, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
+
+// contestInitTask 作成するマッピングと台数
+type contestInitTask struct {
+	mapping   mapping
+	requested int
+	skipped   int
+	created   int32
+	failed    int32
+}
+
 func contestInitCommandFunc(cmd *cobra.Command, args []string) error {
 	flags := cmd.Flags()
 
-	vmmsEndpoint, err := flags.GetString("vmms-endpoint")
+	mappingFilePath, err := flags.GetString("mapping-file-path")
 	if err != nil {
 		return err
 	}
-	vmmsCredential, err := flags.GetString("vmms-credential")
+	configPath, err := flags.GetString("config")
 	if err != nil {
 		return err
 	}
-	mappingFilePath, err := flags.GetString("mapping-file-path")
+	count, err := flags.GetUint("count")
 	if err != nil {
 		return err
 	}
-	count, err := flags.GetUint("count")
+	maxRetries, err := flags.GetInt("max-retries")
+	if err != nil {
+		return err
+	}
+	retryInterval, err := flags.GetInt("retry-interval")
+	if err != nil {
+		return err
+	}
+	parallel, err := flags.GetUint("parallel")
+	if err != nil {
+		return err
+	}
+	checkpointFilePath, err := flags.GetString("checkpoint-file-path")
+	if err != nil {
+		return err
+	}
+	resume, err := flags.GetBool("resume")
+	if err != nil {
+		return err
+	}
+	overwriteCheckpoint, err := flags.GetBool("overwrite-checkpoint")
 	if err != nil {
 		return err
 	}
 
-	// read mapping file
-	bytes, err := ioutil.ReadFile(mappingFilePath)
+	if parallel == 0 {
+		return xerrors.New("--parallel には1以上を指定してください")
+	}
+
+	lg, err := newLogger(cmd, "")
+	if err != nil {
+		return err
+	}
+
+	if (mappingFilePath == "") == (configPath == "") {
+		return xerrors.New("--mapping-file-path か --config のどちらか一方を指定してください")
+	}
+	if configPath != "" {
+		mappingFilePath = configPath
+	}
+
+	// read mapping file (コンテスト定義ファイル、旧形式のマッピングファイルのどちらも読み込める)
+	ml, def, err := config.LoadMappings(mappingFilePath)
 	if err != nil {
 		return err
 	}
+	if len(ml) == 0 {
+		return xerrors.New("作成するインスタンスがありません。コンテスト定義ファイルの場合は problems[].placements を設定してください")
+	}
 
-	ml := []mapping{}
-	if err := yaml.Unmarshal(bytes, &ml); err != nil {
+	// コンテスト定義ファイルにvmmsの設定があれば、フラグと環境変数で指定されていない場合はそちらを使う
+	base := config.ProfileFromSetting(&def.Setting)
+	profile, err := resolveProfile(cmd, endpointFlags{}, &base)
+	if err != nil {
 		return err
 	}
 
-	fmt.Printf("[INFO] read success: %#v\n", ml)
+	lg.Info("read mappings", zap.String("file", mappingFilePath), zap.Int("mappings", len(ml)))
+	lg.Debug("read mappings", zap.Any("mappings", ml))
 
 	// validate
 	for _, m := range ml {
 		if m.ProblemID == "" {
-			xerrors.New("problem_id が空になっている場所があります")
+			return xerrors.New("problem_id が空になっている場所があります")
 		}
 		if m.MachineImageName == "" {
-			xerrors.New("machine-image-name が空になっている場所があります")
+			return xerrors.New("machine-image-name が空になっている場所があります")
 		}
 		if m.Project == "" {
-			xerrors.New("project が空になっている場所があります")
+			return xerrors.New("project が空になっている場所があります")
 		}
 		if m.Zone == "" {
-			xerrors.New("zone が空になっている場所があります")
+			return xerrors.New("zone が空になっている場所があります")
 		}
 	}
 
-	// create instance
-	cli := vmms.NewClient(vmmsEndpoint, vmmsCredential)
+	// 前回の実行で作成済みのインスタンスを読み込む
+	cp, err := openCheckpoint(checkpointFilePath, resume, overwriteCheckpoint)
+	if err != nil {
+		return err
+	}
 
-	// 問題ごとに指定カウント分作成させた方が、途中でコケたときに扱いやすい
-	// 作成が完了した問題は設定ファイルから削除すればよくなる
+	// 同じマッピングが複数回書かれている場合はまとめて作成する
+	tasks := []*contestInitTask{}
 	for _, m := range ml {
-		c := count
+		c := int(count)
+		if m.Count > 0 {
+			c = m.Count
+		}
+
+		found := false
+		for _, t := range tasks {
+			if t.mapping == newMapping(m) {
+				t.requested += c
+				found = true
+			}
+		}
+		if !found {
+			tasks = append(tasks, &contestInitTask{mapping: newMapping(m), requested: c})
+		}
+	}
+
+	// 問題ごとに指定カウント分作成させた方が、途中でコケたときに扱いやすい
+	jobs := make(chan *contestInitTask)
+	go func() {
+		for _, t := range tasks {
+			t.skipped = len(cp.entry(t.mapping).Instances)
+			if t.skipped > t.requested {
+				t.skipped = t.requested
+			}
+			if t.skipped > 0 {
+				lg.Info("skip instances already created", zap.Int("skipped", t.skipped), zap.String("problem_id", t.mapping.ProblemID), logging.Problem(t.mapping.MachineImageName))
+			}
+			for c := t.skipped; c < t.requested; c++ {
+				jobs <- t
+			}
+		}
+		close(jobs)
+	}()
 
-		for c > 0 {
-			// TODO: リトライカウントの実装
-			// 今は作成に成功するまで無限ループ
-			for {
-				fmt.Printf("[INFO] creating... problemID: %s, machineImageName: %s, project: %s, zone: %s\n", m.ProblemID, m.MachineImageName, m.Project, m.Zone)
+	// create instance
+	cli := vmms.NewClient(profile.VmmsEndpoint, profile.VmmsCredential)
+	if def.Setting.Vmms.Timeout > 0 {
+		cli.Timeout = time.Duration(def.Setting.Vmms.Timeout) * time.Second
+	}
+	show := showSecrets(cmd)
 
-				i, err := cli.CreateInstance(m.ProblemID, m.MachineImageName, m.Project, m.Zone)
+	wg := sync.WaitGroup{}
+	for w := uint(0); w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range jobs {
+				m := t.mapping
+				name, err := createInstanceWithRetry(cli, m, maxRetries, time.Duration(retryInterval)*time.Second, show, lg)
 				if err != nil {
-					fmt.Println("[ERROR] failed to create instance.")
-					// VMの作成に失敗した場合は5秒sleepする
-					time.Sleep(time.Second * 5)
-				} else {
-					fmt.Printf("[INFO] created: %#v\n", i)
-					break
+					lg.Error("gave up creating instance", zap.String("problem_id", m.ProblemID), logging.Problem(m.MachineImageName), logging.Project(m.Project), logging.Zone(m.Zone), zap.Error(err))
+					atomic.AddInt32(&t.failed, 1)
+					continue
 				}
+				if err := cp.record(m, name); err != nil {
+					lg.Error("failed to write checkpoint file", zap.String("file", checkpointFilePath), logging.Instance(name), zap.Error(err))
+				}
+				atomic.AddInt32(&t.created, 1)
 			}
+		}()
+	}
+	wg.Wait()
+
+	// summary
+	type summary struct {
+		ProblemID        string `json:"problem_id"`
+		MachineImageName string `json:"machine_image_name"`
+		Project          string `json:"project"`
+		Zone             string `json:"zone"`
+		Requested        int    `json:"requested"`
+		Skipped          int    `json:"skipped"`
+		Created          int    `json:"created"`
+		Failed           int    `json:"failed"`
+	}
+
+	failed := 0
+	summaries := []summary{}
+	for _, t := range tasks {
+		summaries = append(summaries, summary{
+			ProblemID:        t.mapping.ProblemID,
+			MachineImageName: t.mapping.MachineImageName,
+			Project:          t.mapping.Project,
+			Zone:             t.mapping.Zone,
+			Requested:        t.requested,
+			Skipped:          t.skipped,
+			Created:          int(t.created),
+			Failed:           int(t.failed),
+		})
+		failed += int(t.failed)
+	}
 
-			c--
+	err = printOutput(cmd, summaries, func(out io.Writer, wide bool) error {
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PROBLEM_ID\tMACHINE_IMAGE_NAME\tPROJECT\tZONE\tREQUESTED\tSKIPPED\tCREATED\tFAILED")
+		for _, s := range summaries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\n", s.ProblemID, s.MachineImageName, s.Project, s.Zone, s.Requested, s.Skipped, s.Created, s.Failed)
 		}
+		return w.Flush()
+	})
+	if err != nil {
+		return err
 	}
 
-	fmt.Println("[INFO] success!!!!")
+	if failed > 0 {
+		return xerrors.New(fmt.Sprintf("failed to create %d instance(s). run again with --resume to retry", failed))
+	}
+
+	lg.Info("success!!!!")
 
 	return nil
 }
+
+// createInstanceWithRetry インスタンスを作成する。失敗した場合は maxRetries 回までリトライする
+// maxRetries が負の場合は成功するまでリトライする
+// リトライでは同じ冪等キーを送り、タイムアウトしたが実際には作成されていた場合に重複して作成されないようにする
+// show が false の場合、作成したインスタンスのパスワードは伏せて表示する
+func createInstanceWithRetry(cli *vmms.Client, m mapping, maxRetries int, interval time.Duration, show bool, lg *zap.Logger) (string, error) {
+	key := vmms.NewIdempotencyKey()
+	lg = lg.With(zap.String("problem_id", m.ProblemID), logging.Problem(m.MachineImageName), logging.Project(m.Project), logging.Zone(m.Zone), zap.String("idempotency_key", key))
+	for attempt := 0; ; attempt++ {
+		lg.Info("creating instance", zap.Int("attempt", attempt+1))
+
+		i, err := cli.CreateInstance(key, m.ProblemID, m.MachineImageName, m.Project, m.Zone)
+		if err == nil {
+			created := *i
+			if !show {
+				created = created.Redacted()
+			}
+			lg.Info("created instance", logging.Instance(created.InstanceName), zap.String("domain", created.Domain))
+			lg.Debug("created instance", zap.Any("instance", created))
+			return i.InstanceName, nil
+		}
+
+		if maxRetries >= 0 && attempt >= maxRetries {
+			return "", err
+		}
+
+		lg.Error("failed to create instance, retry", zap.Duration("interval", interval), zap.Int("attempt", attempt+1), zap.Int("max_retries", maxRetries), zap.Error(err))
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,91 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/config"
+	"github.com/janog-netcon/netcon-cli/pkg/scheduler"
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+)
+
+func NewContestCostCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cost",
+		Short: "scheduler start --record-dir の記録から、インスタンスの稼働時間と費用を見積もる",
+		RunE:  contestCostCommandFunc,
+	}
+
+	flags := cmd.Flags()
+	flags.StringP("config", "", "./netcon.conf", "Scheduler Configuration")
+	flags.StringP("record-dir", "", "", "scheduler start --record-dir で記録したディレクトリ")
+
+	cmd.MarkFlagRequired("config")
+	cmd.MarkFlagRequired("record-dir")
+
+	return cmd
+}
+
+func contestCostCommandFunc(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+
+	configPath, err := flags.GetString("config")
+	if err != nil {
+		return err
+	}
+	recordDir, err := flags.GetString("record-dir")
+	if err != nil {
+		return err
+	}
+
+	// read config file (コンテスト定義ファイル、旧形式の設定ファイルのどちらも読み込める)
+	cfg, err := config.LoadSchedulerConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	names, records, err := scheduler.LoadRecords(recordDir)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return xerrors.Errorf("no records in %s", recordDir)
+	}
+
+	report := scheduler.EstimateCost(cfg, names, records, time.Now())
+
+	return printOutput(cmd, report, func(out io.Writer, wide bool) error {
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PROBLEM\tINSTANCES\tRUNNING\tINSTANCE_HOURS\tHOURLY_COST\tCOST")
+		for _, p := range report.Problems {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%.1f\t%.2f\t%.2f\n", p.MachineImageName, p.Instances, p.Running, p.InstanceHours, p.HourlyCost, p.Cost)
+		}
+		w.Flush()
+
+		if wide {
+			fmt.Fprintln(out)
+
+			w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "PROJECT\tZONE\tINSTANCES\tRUNNING\tINSTANCE_HOURS\tCOST")
+			for _, z := range report.Zones {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.1f\t%.2f\n", z.ProjectName, z.ZoneName, z.Instances, z.Running, z.InstanceHours, z.Cost)
+			}
+			w.Flush()
+		}
+
+		fmt.Fprintf(out, "\n%s - %s: estimated cost %.2f\n", report.From.Local().Format(time.RFC3339), report.To.Local().Format(time.RFC3339), report.Cost)
+		fmt.Fprintf(out, "running instances: %d", report.Running)
+		if report.MaxInstance > 0 {
+			fmt.Fprintf(out, " / %d", report.MaxInstance)
+		}
+		fmt.Fprintf(out, ", hourly cost: %.2f", report.RunningHourlyCost)
+		if report.HourlyBudget > 0 {
+			fmt.Fprintf(out, " / %.2f", report.HourlyBudget)
+		}
+		fmt.Fprintln(out)
+		return nil
+	})
+}
@@ -0,0 +1,134 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/janog-netcon/netcon-cli/pkg/config"
+	"github.com/janog-netcon/netcon-cli/pkg/logging"
+	"github.com/janog-netcon/netcon-cli/pkg/scoreserver"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	scaffoldFormatDefinition = "definition"
+	scaffoldFormatScheduler  = "scheduler"
+	scaffoldFormatMapping    = "mapping"
+)
+
+func NewContestScaffoldCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "scaffold",
+		Short: "スコアサーバの問題一覧から設定ファイルの雛形を作成する",
+		RunE:  contestScaffoldCommandFunc,
+	}
+
+	flags := cmd.Flags()
+	flags.StringSliceP("images", "", []string{}, "存在するMachine Imageの名前 (複数指定可)。指定しない場合は命名規則で作った名前をそのまま使う")
+	flags.StringToStringP("image-map", "", map[string]string{}, "問題のIDまたはcodeとMachine Imageの名前の対応 (例: aki=image-aki)")
+	flags.StringP("image-name-format", "", config.DefaultImageNameFormat, "問題のcode(小文字)からMachine Imageの名前を作る書式")
+	flags.IntP("pool-count", "", 10, "問題ごとの pool_count")
+	flags.StringP("project", "", "", "インスタンスを作成するProject")
+	flags.StringP("zone", "", "", "インスタンスを作成するZone")
+	flags.IntP("max-instance", "", 30, "Zoneの max_instance")
+	flags.StringP("format", "", scaffoldFormatDefinition, "出力する形式 (definition, scheduler, mapping)")
+	flags.StringP("output-file", "", "", "出力先のファイル (指定しない場合は標準出力)")
+
+	return cmd
+}
+
+func contestScaffoldCommandFunc(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+
+	profile, err := resolveProfile(cmd, endpointFlags{}, nil)
+	if err != nil {
+		return err
+	}
+	opts := config.ScaffoldOptions{}
+	if opts.Images, err = flags.GetStringSlice("images"); err != nil {
+		return err
+	}
+	if opts.ImageMap, err = flags.GetStringToString("image-map"); err != nil {
+		return err
+	}
+	if opts.ImageNameFormat, err = flags.GetString("image-name-format"); err != nil {
+		return err
+	}
+	if opts.PoolCount, err = flags.GetInt("pool-count"); err != nil {
+		return err
+	}
+	if opts.Project, err = flags.GetString("project"); err != nil {
+		return err
+	}
+	if opts.Zone, err = flags.GetString("zone"); err != nil {
+		return err
+	}
+	if opts.MaxInstance, err = flags.GetInt("max-instance"); err != nil {
+		return err
+	}
+	format, err := flags.GetString("format")
+	if err != nil {
+		return err
+	}
+	outputFile, err := flags.GetString("output-file")
+	if err != nil {
+		return err
+	}
+
+	if format == scaffoldFormatMapping && (opts.Project == "" || opts.Zone == "") {
+		return xerrors.New("mapping 形式で出力する場合は --project と --zone を指定してください")
+	}
+
+	lg, err := newLogger(cmd, "")
+	if err != nil {
+		return err
+	}
+
+	cli := scoreserver.NewClient(profile.ScoreserverEndpoint)
+	problems, err := cli.ListProblem()
+	if err != nil {
+		return err
+	}
+
+	result := config.Scaffold(*problems, opts)
+
+	for _, p := range result.ProblemsWithoutImage {
+		lg.Warn("machine image not found for problem. skipped", zap.String("problem_id", p.ID), zap.String("code", p.Code), zap.String("title", p.Title))
+	}
+	for _, image := range result.ImagesWithoutProblem {
+		lg.Warn("problem not found for machine image", logging.Problem(image))
+	}
+
+	var v interface{}
+	switch format {
+	case scaffoldFormatDefinition:
+		v = result.Definition
+	case scaffoldFormatScheduler:
+		v = result.Definition.SchedulerConfig()
+	case scaffoldFormatMapping:
+		v = result.Definition.Mappings()
+	default:
+		return xerrors.New(fmt.Sprintf("unknown format: %s", format))
+	}
+
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if outputFile == "" {
+		os.Stdout.Write(b)
+		return nil
+	}
+
+	if err := ioutil.WriteFile(outputFile, b, 0600); err != nil {
+		return err
+	}
+	lg.Info("wrote file", zap.String("file", outputFile))
+
+	return nil
+}
@@ -0,0 +1,302 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/config"
+	"github.com/janog-netcon/netcon-cli/pkg/scheduler"
+	"github.com/janog-netcon/netcon-cli/pkg/scoreserver"
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+const (
+	colorRed    = "\033[31m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+	clearScreen = "\033[H\033[2J"
+)
+
+func NewContestStatusCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "問題ごと・Zoneごとのインスタンスの状況を表示する",
+		RunE:  contestStatusCommandFunc,
+	}
+
+	flags := cmd.Flags()
+	flags.StringP("config", "", "./netcon.conf", "Scheduler Configuration")
+	flags.BoolP("watch", "w", false, "一定間隔で表示を更新し続ける")
+	flags.IntP("interval", "", 5, "--watch のときに更新する間隔(秒)")
+	flags.BoolP("no-color", "", false, "色を付けずに表示する")
+
+	cmd.MarkFlagRequired("config")
+
+	return cmd
+}
+
+func contestStatusCommandFunc(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+
+	configPath, err := flags.GetString("config")
+	if err != nil {
+		return err
+	}
+	watch, err := flags.GetBool("watch")
+	if err != nil {
+		return err
+	}
+	interval, err := flags.GetInt("interval")
+	if err != nil {
+		return err
+	}
+	noColor, err := flags.GetBool("no-color")
+	if err != nil {
+		return err
+	}
+
+	// read config file (コンテスト定義ファイル、旧形式の設定ファイルのどちらも読み込める)
+	cfg, err := config.LoadSchedulerConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	// --scoreserver-endpoint, NETCON_SCORESERVER_ENDPOINT が指定されていればconfigファイルの値より優先する
+	base := config.ProfileFromSetting(&cfg.Setting)
+	profile, err := resolveProfile(cmd, endpointFlags{}, &base)
+	if err != nil {
+		return err
+	}
+	ssClient := scoreserver.NewClient(profile.ScoreserverEndpoint)
+
+	if !watch {
+		return printContestStatus(cmd, cfg, ssClient, !noColor)
+	}
+
+	// table, wide の場合は画面を消して表示し直し、それ以外の形式は更新ごとに出力を続ける
+	output, err := flags.GetString("output")
+	if err != nil {
+		return err
+	}
+	table := output == outputTable || output == outputWide
+	out := cmd.OutOrStdout()
+
+	for {
+		if table {
+			fmt.Fprint(out, clearScreen)
+			fmt.Fprintf(out, "Every %ds: netcon contest status    %s\n\n", interval, time.Now().Format(time.RFC3339))
+		} else if output == outputYAML {
+			fmt.Fprintln(out, "---")
+		}
+		if err := printContestStatus(cmd, cfg, ssClient, !noColor); err != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), "[ERROR] "+err.Error())
+		}
+
+		select {
+		case <-cmd.Context().Done():
+			return nil
+		case <-time.After(time.Duration(interval) * time.Second):
+		}
+	}
+}
+
+// problemStatus 問題ごとのインスタンス数
+type problemStatus struct {
+	Name             string     `json:"name"`
+	ProblemID        string     `json:"problem_id"`
+	PoolTarget       int        `json:"pool_target"`
+	Ready            int        `json:"ready"`
+	NotReady         int        `json:"not_ready"`
+	UnderChallenge   int        `json:"under_challenge"`
+	UnderScoring     int        `json:"under_scoring"`
+	Abandoned        int        `json:"abandoned"`
+	Unknown          int        `json:"unknown"`
+	OldestNotReadyAt *time.Time `json:"oldest_not_ready_at"`
+	// OK, LOW (READYが pool_count を下回っている), EMPTY (READYが0台)
+	Status string `json:"status"`
+}
+
+// zoneStatus Zoneごとのインスタンス数
+type zoneStatus struct {
+	ProjectName      string     `json:"project"`
+	ZoneName         string     `json:"zone"`
+	MaxInstance      int        `json:"max_instance"`
+	Current          int        `json:"current"`
+	Ready            int        `json:"ready"`
+	NotReady         int        `json:"not_ready"`
+	UnderChallenge   int        `json:"under_challenge"`
+	UnderScoring     int        `json:"under_scoring"`
+	Abandoned        int        `json:"abandoned"`
+	Unknown          int        `json:"unknown"`
+	OldestNotReadyAt *time.Time `json:"oldest_not_ready_at"`
+	// OK, FULL (max_instance に達している)
+	Status string `json:"status"`
+}
+
+// contestStatus contest status の出力
+type contestStatus struct {
+	Problems []*problemStatus `json:"problems"`
+	Zones    []*zoneStatus    `json:"zones"`
+}
+
+func collectContestStatus(cfg *types.SchedulerConfig, ssClient *scoreserver.Client) (*contestStatus, error) {
+	pes, err := ssClient.ListProblemEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	environments := types.NewEnvironments(*pes)
+
+	// 問題ごとの集計は scheduler と同じ方法で行う
+	problems, zonePriorities := scheduler.InitScheduler(cfg, zap.NewNop())
+	problems, zonePriorities, _ = scheduler.Aggregate(problems, zonePriorities, environments, zap.NewNop())
+
+	names := []string{}
+	for name := range problems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	status := &contestStatus{
+		Problems: []*problemStatus{},
+		Zones:    []*zoneStatus{},
+	}
+
+	for _, name := range names {
+		p := problems[name]
+		ps := &problemStatus{
+			Name:           name,
+			ProblemID:      p.ProblemID,
+			PoolTarget:     p.PoolCount,
+			Ready:          p.Ready,
+			NotReady:       p.NotReady,
+			UnderChallenge: p.UnderChallenge,
+			UnderScoring:   p.UnderScoring,
+			Abandoned:      p.Abandoned,
+			Unknown:        p.Unknown,
+			Status:         "OK",
+		}
+		for _, i := range p.NotReadyInstances {
+			ps.OldestNotReadyAt = older(ps.OldestNotReadyAt, i.CreatedAt)
+		}
+
+		// 参加者に割り当てられるのはREADYなインスタンスなので、READYが目標を下回っていたら強調する
+		if p.Ready < p.PoolCount {
+			ps.Status = "LOW"
+		}
+		if p.PoolCount > 0 && p.Ready == 0 {
+			ps.Status = "EMPTY"
+		}
+
+		status.Problems = append(status.Problems, ps)
+	}
+
+	// Zoneごとの集計
+	zoneIndex := map[string]*zoneStatus{}
+	for _, zp := range zonePriorities {
+		z := &zoneStatus{ProjectName: zp.ProjectName, ZoneName: zp.ZoneName, MaxInstance: zp.MaxInstance}
+		status.Zones = append(status.Zones, z)
+		zoneIndex[zp.ProjectName+"/"+zp.ZoneName] = z
+	}
+	for _, e := range environments {
+		z, ok := zoneIndex[e.ProjectName+"/"+e.ZoneName]
+		if !ok {
+			// configファイルに書かれていないZoneのインスタンスも表示する
+			z = &zoneStatus{ProjectName: e.ProjectName, ZoneName: e.ZoneName}
+			status.Zones = append(status.Zones, z)
+			zoneIndex[e.ProjectName+"/"+e.ZoneName] = z
+		}
+
+		z.Current++
+		switch types.NormalizeInnerStatus(e.InnerStatus) {
+		case types.InnerStatusReady:
+			z.Ready++
+		case types.InnerStatusNotReady:
+			z.NotReady++
+			z.OldestNotReadyAt = older(z.OldestNotReadyAt, e.CreatedAt)
+		case types.InnerStatusUnderChallenge:
+			z.UnderChallenge++
+		case types.InnerStatusUnderScoring:
+			z.UnderScoring++
+		case types.InnerStatusAbandoned:
+			z.Abandoned++
+		default:
+			z.Unknown++
+		}
+	}
+	for _, z := range status.Zones {
+		z.Status = "OK"
+		if z.MaxInstance > 0 && z.Current >= z.MaxInstance {
+			z.Status = "FULL"
+		}
+	}
+
+	return status, nil
+}
+
+func printContestStatus(cmd *cobra.Command, cfg *types.SchedulerConfig, ssClient *scoreserver.Client, color bool) error {
+	status, err := collectContestStatus(cfg, ssClient)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	statusColors := map[string]string{
+		"LOW":   colorYellow,
+		"EMPTY": colorRed,
+		"FULL":  colorRed,
+	}
+
+	return printOutput(cmd, status, func(out io.Writer, wide bool) error {
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		if wide {
+			fmt.Fprint(w, "PROBLEM_ID\t")
+		}
+		fmt.Fprintln(w, "PROBLEM\tPOOL_TARGET\tREADY\tNOT_READY\tIN_CHALLENGE\tSCORING\tABANDONED\tUNKNOWN\tOLDEST_NOT_READY\tSTATUS")
+		for _, p := range status.Problems {
+			if wide {
+				fmt.Fprintf(w, "%s\t", p.ProblemID)
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n",
+				p.Name, p.PoolTarget, p.Ready, p.NotReady, p.UnderChallenge, p.UnderScoring, p.Abandoned, p.Unknown, age(now, p.OldestNotReadyAt), colorize(p.Status, statusColors[p.Status], color))
+		}
+		w.Flush()
+
+		fmt.Fprintln(out)
+
+		w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PROJECT\tZONE\tINSTANCES\tMAX_INSTANCE\tREADY\tNOT_READY\tIN_CHALLENGE\tSCORING\tABANDONED\tUNKNOWN\tOLDEST_NOT_READY\tSTATUS")
+		for _, z := range status.Zones {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n",
+				z.ProjectName, z.ZoneName, z.Current, z.MaxInstance, z.Ready, z.NotReady, z.UnderChallenge, z.UnderScoring, z.Abandoned, z.Unknown, age(now, z.OldestNotReadyAt), colorize(z.Status, statusColors[z.Status], color))
+		}
+		return w.Flush()
+	})
+}
+
+// older oldest と t のうち古い方を返す
+func older(oldest *time.Time, t time.Time) *time.Time {
+	if oldest == nil || t.Before(*oldest) {
+		return &t
+	}
+	return oldest
+}
+
+func colorize(s, color string, enabled bool) string {
+	if !enabled || color == "" {
+		return s
+	}
+	return color + s + colorReset
+}
+
+// age t からの経過時間を秒単位で返す。t がnilの場合は "-" を返す
+func age(now time.Time, t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return now.Sub(*t).Truncate(time.Second).String()
+}
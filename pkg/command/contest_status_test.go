@@ -0,0 +1,112 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// snapshotWriter 書き込まれた内容に sep が n 回現れたら cancel を呼ぶ
+type snapshotWriter struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	sep    string
+	n      int
+	cancel func()
+}
+
+func (w *snapshotWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n, err := w.buf.Write(p)
+	if strings.Count(w.buf.String(), w.sep) >= w.n {
+		w.cancel()
+	}
+	return n, err
+}
+
+func (w *snapshotWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+func Test_contestStatusWatch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"name": "image-sc0-aaaaa", "inner_status": "READY", "project": "networkcontest", "zone": "asia-northeast1-b", "machine_image_name": "image-sc0", "problem_id": "problem-sc0"}]`))
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "netcon-contest-status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "netcon.conf")
+	cfg := `setting:
+  scoreserver:
+    endpoint: ` + ts.URL + `
+  projects:
+    - name: networkcontest
+      zones:
+        - name: asia-northeast1-b
+          max_instance: 10
+  problems:
+    - machine_image_name: image-sc0
+      pool_count: 1
+      problem_id: problem-sc0
+`
+	if err := ioutil.WriteFile(configPath, []byte(cfg), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		output      string
+		sep         string
+		wantClear   bool
+		wantContain string
+	}{
+		{name: "table", output: "table", sep: "Every 0s", wantClear: true, wantContain: "image-sc0"},
+		{name: "json", output: "json", sep: `"problems"`, wantContain: `"pool_target": 1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setNetconEnv(t, nil)
+
+			// 2回表示を更新したら止める
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			out := &snapshotWriter{sep: tt.sep, n: 2, cancel: cancel}
+
+			cmd := NewNetconCommand()
+			cmd.SetOut(out)
+			cmd.SetErr(ioutil.Discard)
+			cmd.SetArgs([]string{"contest", "status", "--config", configPath, "--watch", "--interval", "0", "--no-color", "-o", tt.output, "--log-level", "error"})
+			if err := cmd.ExecuteContext(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			got := out.String()
+			if strings.Count(got, tt.sep) < 2 {
+				t.Errorf("got %d snapshot(s), want 2: %q", strings.Count(got, tt.sep), got)
+			}
+			if strings.Contains(got, clearScreen) != tt.wantClear {
+				t.Errorf("clear screen: got %v, want %v", !tt.wantClear, tt.wantClear)
+			}
+			if !strings.Contains(got, tt.wantContain) {
+				t.Errorf("output does not contain %q: %q", tt.wantContain, got)
+			}
+		})
+	}
+}
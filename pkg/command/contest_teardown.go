@@ -0,0 +1,299 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/logging"
+	"github.com/janog-netcon/netcon-cli/pkg/scoreserver"
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"github.com/janog-netcon/netcon-cli/pkg/vmms"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"golang.org/x/xerrors"
+)
+
+func NewContestTeardownCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "teardown",
+		Short: "条件に一致する問題VMをまとめて削除する",
+		RunE:  contestTeardownCommandFunc,
+	}
+
+	flags := cmd.Flags()
+	flags.StringSliceP("problem-id", "", []string{}, "削除対象のProblem ID (複数指定可)")
+	flags.StringSliceP("machine-image-name", "", []string{}, "削除対象のMachine Image Name (複数指定可)")
+	flags.StringSliceP("project", "", []string{}, "削除対象のProject (複数指定可)")
+	flags.StringSliceP("zone", "", []string{}, "削除対象のZone (複数指定可)")
+	flags.StringSliceP("inner-status", "", []string{}, "削除対象のInnerStatus (複数指定可)")
+	flags.BoolP("force", "", false, "UNDER_CHALLENGE, UNDER_SCORING や状態が分からないVMも削除する")
+	flags.BoolP("dry-run", "", false, "削除対象を表示するだけで削除は行わない")
+	flags.BoolP("yes", "y", false, "確認せずに削除する")
+	flags.UintP("parallel", "p", 1, "同時に削除するインスタンス数")
+
+	return cmd
+}
+
+// teardownSelector 削除対象を絞り込む条件
+// 空の条件は全てに一致する
+type teardownSelector struct {
+	problemIDs        []string
+	machineImageNames []string
+	projects          []string
+	zones             []string
+	innerStatuses     []types.InnerStatus
+	force             bool
+}
+
+func (s *teardownSelector) match(e *types.Environment) (bool, string) {
+	machineImageName := ""
+	if e.MachineImageName != nil {
+		machineImageName = *e.MachineImageName
+	}
+	innerStatus := types.NormalizeInnerStatus(e.InnerStatus)
+
+	if !containsOrEmpty(s.problemIDs, e.ProblemID) ||
+		!containsOrEmpty(s.machineImageNames, machineImageName) ||
+		!containsOrEmpty(s.projects, e.ProjectName) ||
+		!containsOrEmpty(s.zones, e.ZoneName) {
+		return false, ""
+	}
+
+	if len(s.innerStatuses) > 0 {
+		found := false
+		for _, status := range s.innerStatuses {
+			if status == innerStatus {
+				found = true
+			}
+		}
+		if !found {
+			return false, ""
+		}
+	}
+
+	// 参加者が使用中のVMは --force がない限り削除しない
+	if !s.force && (innerStatus == types.InnerStatusUnderChallenge || innerStatus == types.InnerStatusUnderScoring) {
+		return false, "in use (" + string(innerStatus) + ")"
+	}
+	// 知らない状態のVMは使用中かもしれないため、使用中と同じく --force がない限り削除しない
+	if !s.force && innerStatus == types.InnerStatusUnknown {
+		return false, "unknown inner status (" + *e.InnerStatus + ")"
+	}
+
+	return true, ""
+}
+
+func containsOrEmpty(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, l := range list {
+		if l == v {
+			return true
+		}
+	}
+	return false
+}
+
+func contestTeardownCommandFunc(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+
+	profile, err := resolveProfile(cmd, endpointFlags{}, nil)
+	if err != nil {
+		return err
+	}
+
+	selector := &teardownSelector{}
+	if selector.problemIDs, err = flags.GetStringSlice("problem-id"); err != nil {
+		return err
+	}
+	if selector.machineImageNames, err = flags.GetStringSlice("machine-image-name"); err != nil {
+		return err
+	}
+	if selector.projects, err = flags.GetStringSlice("project"); err != nil {
+		return err
+	}
+	if selector.zones, err = flags.GetStringSlice("zone"); err != nil {
+		return err
+	}
+	innerStatuses, err := flags.GetStringSlice("inner-status")
+	if err != nil {
+		return err
+	}
+	for _, s := range innerStatuses {
+		status, err := types.ParseInnerStatus(strings.ToUpper(s))
+		if err != nil {
+			return err
+		}
+		selector.innerStatuses = append(selector.innerStatuses, status)
+	}
+	if selector.force, err = flags.GetBool("force"); err != nil {
+		return err
+	}
+	dryRun, err := flags.GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+	yes, err := flags.GetBool("yes")
+	if err != nil {
+		return err
+	}
+	parallel, err := flags.GetUint("parallel")
+	if err != nil {
+		return err
+	}
+	if parallel == 0 {
+		return xerrors.New("--parallel には1以上を指定してください")
+	}
+
+	lg, err := newLogger(cmd, "")
+	if err != nil {
+		return err
+	}
+
+	ssClient := scoreserver.NewClient(profile.ScoreserverEndpoint)
+	pes, err := ssClient.ListProblemEnvironment()
+	if err != nil {
+		return err
+	}
+
+	// 削除対象を列挙する
+	targets := []types.Environment{}
+	skipped := 0
+	for _, e := range types.NewEnvironments(*pes) {
+		ok, reason := selector.match(&e)
+		if reason != "" {
+			lg.Info("skip instance", logging.Instance(e.Name), zap.String("reason", reason))
+			skipped++
+		}
+		if ok {
+			targets = append(targets, e)
+		}
+	}
+
+	preview := targets
+	if !showSecrets(cmd) {
+		preview = []types.Environment{}
+		for _, e := range targets {
+			preview = append(preview, e.Redacted())
+		}
+	}
+	if err := printOutput(cmd, preview, func(out io.Writer, wide bool) error {
+		return printTeardownPreview(out, targets, wide)
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "\n%d instance(s) will be deleted. %d instance(s) in use or with unknown status are skipped (use --force to delete them).\n", len(targets), skipped)
+
+	if len(targets) == 0 || dryRun {
+		return nil
+	}
+
+	if !yes {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Delete %d instance(s)? Type 'yes' to continue: ", len(targets))
+		answer, _ := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+		if strings.TrimSpace(answer) != "yes" {
+			lg.Info("canceled")
+			return nil
+		}
+	}
+
+	vmmsClient := vmms.NewClient(profile.VmmsEndpoint, profile.VmmsCredential)
+
+	jobs := make(chan types.Environment)
+	go func() {
+		for _, e := range targets {
+			jobs <- e
+		}
+		close(jobs)
+	}()
+
+	var done, failed int32
+	wg := sync.WaitGroup{}
+	for w := uint(0); w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				err := vmmsClient.DeleteInstance(e.Name, e.ProjectName, e.ZoneName)
+				n := atomic.AddInt32(&done, 1)
+				if err != nil {
+					atomic.AddInt32(&failed, 1)
+					lg.Error("failed to delete instance", logging.Instance(e.Name), logging.Project(e.ProjectName), logging.Zone(e.ZoneName), zap.Int32("done", n), zap.Int("total", len(targets)), zap.Error(err))
+					continue
+				}
+				lg.Info("deleted instance", logging.Instance(e.Name), logging.Project(e.ProjectName), logging.Zone(e.ZoneName), zap.Int32("done", n), zap.Int("total", len(targets)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed > 0 {
+		return xerrors.New(fmt.Sprintf("failed to delete %d of %d instance(s)", failed, len(targets)))
+	}
+
+	lg.Info("deleted instances successfully", zap.Int("deleted", len(targets)))
+
+	return nil
+}
+
+// printTeardownPreview 削除対象をMachine Image, Project, Zone, InnerStatus ごとに数えて表示する
+// wide の場合は削除対象のインスタンスを1台ずつ表示する
+func printTeardownPreview(out io.Writer, targets []types.Environment, wide bool) error {
+	if wide {
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tMACHINE_IMAGE_NAME\tPROJECT\tZONE\tINNER_STATUS\tCREATED_AT")
+		for _, e := range targets {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Name, stringOrEmpty(e.MachineImageName), e.ProjectName, e.ZoneName, types.NormalizeInnerStatus(e.InnerStatus), e.CreatedAt.Format(time.RFC3339))
+		}
+		return w.Flush()
+	}
+
+	type key struct {
+		machineImageName string
+		project          string
+		zone             string
+		innerStatus      types.InnerStatus
+	}
+
+	counts := map[key]int{}
+	for _, e := range targets {
+		k := key{project: e.ProjectName, zone: e.ZoneName, innerStatus: types.NormalizeInnerStatus(e.InnerStatus)}
+		if e.MachineImageName != nil {
+			k.machineImageName = *e.MachineImageName
+		}
+		counts[k]++
+	}
+
+	keys := []key{}
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.machineImageName != b.machineImageName {
+			return a.machineImageName < b.machineImageName
+		}
+		if a.project != b.project {
+			return a.project < b.project
+		}
+		if a.zone != b.zone {
+			return a.zone < b.zone
+		}
+		return a.innerStatus < b.innerStatus
+	})
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MACHINE_IMAGE_NAME\tPROJECT\tZONE\tINNER_STATUS\tCOUNT")
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", k.machineImageName, k.project, k.zone, k.innerStatus, counts[k])
+	}
+	return w.Flush()
+}
@@ -0,0 +1,194 @@
+package command
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+)
+
+func Test_teardownSelector_match(t *testing.T) {
+	str := func(s string) *string { return &s }
+	env := func(innerStatus *string) *types.Environment {
+		return &types.Environment{
+			Name:             "image-sc0-aaaaa",
+			InnerStatus:      innerStatus,
+			ProblemID:        "problem-sc0",
+			ProjectName:      "networkcontest",
+			ZoneName:         "asia-northeast1-b",
+			MachineImageName: str("image-sc0"),
+		}
+	}
+
+	tests := []struct {
+		name       string
+		selector   teardownSelector
+		env        *types.Environment
+		want       bool
+		wantReason string
+	}{
+		{
+			name:     "empty selector matches all",
+			selector: teardownSelector{},
+			env:      env(str("READY")),
+			want:     true,
+		},
+		{
+			name:     "all conditions match",
+			selector: teardownSelector{problemIDs: []string{"problem-sc1", "problem-sc0"}, machineImageNames: []string{"image-sc0"}, projects: []string{"networkcontest"}, zones: []string{"asia-northeast1-b"}},
+			env:      env(str("READY")),
+			want:     true,
+		},
+		{
+			name:     "problem_id does not match",
+			selector: teardownSelector{problemIDs: []string{"problem-sc1"}},
+			env:      env(str("READY")),
+		},
+		{
+			name:     "machine_image_name does not match",
+			selector: teardownSelector{machineImageNames: []string{"image-sc1"}},
+			env:      env(str("READY")),
+		},
+		{
+			name:     "machine_image_name is null",
+			selector: teardownSelector{machineImageNames: []string{"image-sc0"}},
+			env:      &types.Environment{Name: "unknown-aaaaa", InnerStatus: str("READY")},
+		},
+		{
+			name:     "project does not match",
+			selector: teardownSelector{projects: []string{"other"}},
+			env:      env(str("READY")),
+		},
+		{
+			name:     "zone does not match",
+			selector: teardownSelector{zones: []string{"asia-northeast1-a"}},
+			env:      env(str("READY")),
+		},
+		{
+			name:     "inner_status matches",
+			selector: teardownSelector{innerStatuses: []types.InnerStatus{types.InnerStatusAbandoned, types.InnerStatusNotReady}},
+			env:      env(str("NOT_READY")),
+			want:     true,
+		},
+		{
+			name:     "inner_status does not match",
+			selector: teardownSelector{innerStatuses: []types.InnerStatus{types.InnerStatusAbandoned}},
+			env:      env(str("READY")),
+		},
+		{
+			name:     "null inner_status is READY",
+			selector: teardownSelector{innerStatuses: []types.InnerStatus{types.InnerStatusReady}},
+			env:      env(nil),
+			want:     true,
+		},
+		{
+			name:       "UNDER_CHALLENGE is refused without --force",
+			selector:   teardownSelector{},
+			env:        env(str("UNDER_CHALLENGE")),
+			wantReason: "in use (UNDER_CHALLENGE)",
+		},
+		{
+			name:       "UNDER_SCORING is refused without --force",
+			selector:   teardownSelector{problemIDs: []string{"problem-sc0"}},
+			env:        env(str("UNDER_SCORING")),
+			wantReason: "in use (UNDER_SCORING)",
+		},
+		{
+			name:       "UNDER_CHALLENGE selected by inner_status is still refused without --force",
+			selector:   teardownSelector{innerStatuses: []types.InnerStatus{types.InnerStatusUnderChallenge}},
+			env:        env(str("UNDER_CHALLENGE")),
+			wantReason: "in use (UNDER_CHALLENGE)",
+		},
+		{
+			name:     "UNDER_CHALLENGE with --force",
+			selector: teardownSelector{force: true},
+			env:      env(str("UNDER_CHALLENGE")),
+			want:     true,
+		},
+		{
+			name:       "unknown inner_status is refused without --force",
+			selector:   teardownSelector{},
+			env:        env(str("SUSPENDED")),
+			wantReason: "unknown inner status (SUSPENDED)",
+		},
+		{
+			name:     "unknown inner_status with --force",
+			selector: teardownSelector{force: true},
+			env:      env(str("SUSPENDED")),
+			want:     true,
+		},
+		{
+			name:     "in use but not selected has no reason",
+			selector: teardownSelector{zones: []string{"asia-northeast1-a"}},
+			env:      env(str("UNDER_CHALLENGE")),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := tt.selector.match(tt.env)
+			if got != tt.want || reason != tt.wantReason {
+				t.Errorf("match() = (%v, %q), want (%v, %q)", got, reason, tt.want, tt.wantReason)
+			}
+		})
+	}
+}
+
+func Test_contestTeardownConfirm(t *testing.T) {
+	ss := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"name": "image-sc0-aaaaa", "inner_status": "READY", "project": "networkcontest", "zone": "asia-northeast1-b", "machine_image_name": "image-sc0"}]`))
+	}))
+	defer ss.Close()
+
+	var mu sync.Mutex
+	deleted := []string{}
+	vm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		deleted = append(deleted, r.URL.Path)
+		mu.Unlock()
+		w.Write([]byte(`{}`))
+	}))
+	defer vm.Close()
+
+	tests := []struct {
+		answer      string
+		wantDeleted int
+	}{
+		{answer: "no\n", wantDeleted: 0},
+		{answer: "yes\n", wantDeleted: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.TrimSpace(tt.answer), func(t *testing.T) {
+			setNetconEnv(t, nil)
+			mu.Lock()
+			deleted = []string{}
+			mu.Unlock()
+
+			// 確認のプロンプトは cmd のstderrに出し、回答は cmd のstdinから読む
+			stderr := &bytes.Buffer{}
+			cmd := NewNetconCommand()
+			cmd.SetIn(strings.NewReader(tt.answer))
+			cmd.SetOut(ioutil.Discard)
+			cmd.SetErr(stderr)
+			cmd.SetArgs([]string{"contest", "teardown", "--scoreserver-endpoint", ss.URL, "--vmms-endpoint", vm.URL, "--log-level", "error"})
+			if err := cmd.Execute(); err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(stderr.String(), "Type 'yes' to continue") {
+				t.Errorf("prompt was not written to stderr: %q", stderr.String())
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(deleted) != tt.wantDeleted {
+				t.Errorf("deleted %v, want %d instance(s)", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
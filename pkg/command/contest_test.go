@@ -0,0 +1,161 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/janog-netcon/netcon-cli/pkg/config"
+	"github.com/janog-netcon/netcon-cli/pkg/devserver"
+)
+
+// setNetconEnv NETCON_* 環境変数を env の値だけにする。テストの終了時に元に戻す
+// NETCON_CONFIG が指定されていない場合は存在しないファイルにして、~/.config/netcon/config.yaml の影響を受けないようにする
+func setNetconEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	for _, key := range []string{config.EnvConfig, config.EnvProfile, config.EnvScoreserverEndpoint, config.EnvVmmsEndpoint, config.EnvVmmsCredential, config.EnvVmmsCredentialFile} {
+		key := key
+		if v, ok := os.LookupEnv(key); ok {
+			t.Cleanup(func() { os.Setenv(key, v) })
+		} else {
+			t.Cleanup(func() { os.Unsetenv(key) })
+		}
+		os.Unsetenv(key)
+	}
+	os.Setenv(config.EnvConfig, filepath.Join(os.TempDir(), "netcon-test-no-such-config.yaml"))
+	for key, v := range env {
+		os.Setenv(key, v)
+	}
+}
+
+// runNetcon netcon コマンドを args で実行し、標準出力を返す
+// ~/.config/netcon/config.yaml と NETCON_* 環境変数の影響を受けないようにする
+func runNetcon(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+
+	setNetconEnv(t, nil)
+
+	out := &bytes.Buffer{}
+	cmd := NewNetconCommand()
+	cmd.SetOut(out)
+	cmd.SetErr(ioutil.Discard)
+	cmd.SetArgs(append(args, "--log-level", "error"))
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func Test_contestInitCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netcon-contest-init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := devserver.NewServer(&devserver.Config{})
+	ts := httptest.NewServer(srv.VmmsHandler())
+	defer ts.Close()
+
+	mapping := `- problem_id: 8b1f0a3c-1f4b-4c55-9f59-2f6a4c3b0d11
+  machine_image_name: image-sc0
+  project: networkcontest
+  zone: asia-northeast1-b
+`
+	if err := ioutil.WriteFile("mapping.yaml", []byte(mapping), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	type summary struct {
+		Requested int `json:"requested"`
+		Skipped   int `json:"skipped"`
+		Created   int `json:"created"`
+	}
+	initContest := func(args ...string) (summary, error) {
+		out, err := runNetcon(t, append([]string{"contest", "init", "--mapping-file-path", "mapping.yaml", "--vmms-endpoint", ts.URL, "--vmms-credential", "token", "--retry-interval", "0", "-o", "json"}, args...)...)
+		if err != nil {
+			return summary{}, err
+		}
+		var s []summary
+		if err := json.Unmarshal([]byte(out), &s); err != nil {
+			t.Fatalf("%v: %s", err, out)
+		}
+		if len(s) != 1 {
+			t.Fatalf("got %d summaries, want 1", len(s))
+		}
+		return s[0], nil
+	}
+	checkpointInstances := func(path string) int {
+		cp, err := loadCheckpoint(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, e := range cp.Entries {
+			n += len(e.Instances)
+		}
+		return n
+	}
+
+	// 作成したインスタンスをデフォルトのcheckpointファイルに記録する
+	s, err := initContest("--count", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Created != 2 || checkpointInstances(defaultCheckpointFilePath) != 2 {
+		t.Fatalf("first run: created %d, checkpoint %d", s.Created, checkpointInstances(defaultCheckpointFilePath))
+	}
+
+	// --resume の場合は記録されている分を除いて作成する
+	s, err = initContest("--count", "3", "--resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Skipped != 2 || s.Created != 1 || checkpointInstances(defaultCheckpointFilePath) != 3 {
+		t.Fatalf("resume: %+v, checkpoint %d", s, checkpointInstances(defaultCheckpointFilePath))
+	}
+
+	// --resume を付けずに再実行した場合は、デフォルトのcheckpointファイルでも上書きせずにエラーにする
+	if _, err := initContest("--count", "1"); err == nil {
+		t.Error("existing checkpoint file without --resume should return error")
+	}
+	if checkpointInstances(defaultCheckpointFilePath) != 3 {
+		t.Errorf("checkpoint should not be modified: %d", checkpointInstances(defaultCheckpointFilePath))
+	}
+	if _, err := initContest("--resume", "--overwrite-checkpoint"); err == nil {
+		t.Error("--resume with --overwrite-checkpoint should return error")
+	}
+
+	// --overwrite-checkpoint の場合は記録を破棄して最初から作成する
+	s, err = initContest("--count", "1", "--overwrite-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Skipped != 0 || s.Created != 1 || checkpointInstances(defaultCheckpointFilePath) != 1 {
+		t.Fatalf("overwrite: %+v, checkpoint %d", s, checkpointInstances(defaultCheckpointFilePath))
+	}
+
+	// 明示的に指定したcheckpointファイルも同じように扱う
+	explicit := filepath.Join(dir, "explicit.yaml")
+	if _, err := initContest("--checkpoint-file-path", explicit); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := initContest("--checkpoint-file-path", explicit); err == nil {
+		t.Error("existing --checkpoint-file-path without --resume should return error")
+	}
+	if s, err := initContest("--checkpoint-file-path", explicit, "--resume"); err != nil || s.Skipped != 1 || s.Created != 0 {
+		t.Errorf("resume explicit checkpoint: %+v, %v", s, err)
+	}
+}
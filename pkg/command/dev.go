@@ -0,0 +1,162 @@
+package command
+
+import (
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/config"
+	"github.com/janog-netcon/netcon-cli/pkg/devserver"
+	"github.com/janog-netcon/netcon-cli/pkg/logging"
+	"github.com/janog-netcon/netcon-cli/pkg/tracing"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func NewDevCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dev",
+		Short: "開発用のコマンド",
+	}
+
+	cmd.AddCommand(
+		NewDevServeCommand(),
+	)
+
+	return cmd
+}
+
+func NewDevServeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "vm-management-serverとスコアサーバ(vmdb-api)の代わりになるHTTPサーバをローカルで起動する",
+		Long: `vm-management-serverとスコアサーバ(vmdb-api)の代わりになるHTTPサーバをローカルで起動する。
+VMは実際には作成せず、メモリ上で NOT_READY -> READY -> UNDER_CHALLENGE -> UNDER_SCORING -> ABANDONED と状態だけを遷移させる。
+--config にコンテスト定義ファイルを指定すると、Zoneごとの max_instance を上限にし、GET /problems で problems を返す。`,
+		RunE: devServeCommandFunc,
+	}
+
+	flags := cmd.Flags()
+	flags.StringP("config", "", "", "コンテスト定義ファイル (Zoneごとの上限と問題一覧に使う)")
+	flags.StringP("scoreserver-listen", "", "127.0.0.1:8905", "vmdb-apiのlistenアドレス")
+	flags.StringP("vmms-listen", "", "127.0.0.1:8950", "vm-management-serverのlistenアドレス")
+	flags.StringP("credential", "", "", "vm-management-serverへのリクエストに必要なcredential (空の場合は確認しない)")
+	flags.DurationP("boot-delay", "", 30*time.Second, "VMの作成を受け付けてからREADYになるまでの時間")
+	flags.DurationP("boot-jitter", "", 0, "boot-delay に加える揺らぎの最大値")
+	flags.Float64P("create-failure-rate", "", 0, "VMの作成を失敗させる確率 (0 - 1)")
+	flags.Float64P("delete-failure-rate", "", 0, "VMの削除を失敗させる確率 (0 - 1)")
+	flags.Float64P("create-timeout-rate", "", 0, "VMを作成したうえで、タイムアウトしたように504を返す確率 (0 - 1)")
+	flags.IntP("quota", "", 0, "--config に含まれないZoneのVM数の上限 (0の場合は無制限)")
+	flags.DurationP("assign-interval", "", 0, "参加者がREADYなVMを割り当てられる間隔 (0の場合は割り当てない)")
+	flags.DurationP("solve-time", "", 10*time.Minute, "割り当てられてから採点を依頼するまでの時間")
+	flags.DurationP("scoring-time", "", time.Minute, "採点を依頼してからABANDONEDになるまでの時間")
+	flags.Int64P("seed", "", 0, "乱数のseed (0の場合は起動時刻を使う)")
+
+	return cmd
+}
+
+func devServeCommandFunc(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+
+	configPath, err := flags.GetString("config")
+	if err != nil {
+		return err
+	}
+	scoreserverListen, err := flags.GetString("scoreserver-listen")
+	if err != nil {
+		return err
+	}
+	vmmsListen, err := flags.GetString("vmms-listen")
+	if err != nil {
+		return err
+	}
+
+	cfg := &devserver.Config{ZoneQuotas: map[string]int{}}
+	if configPath != "" {
+		c, err := config.LoadSchedulerConfig(configPath)
+		if err != nil {
+			return err
+		}
+		cfg = devserver.NewConfig(c)
+	}
+
+	if cfg.Credential, err = flags.GetString("credential"); err != nil {
+		return err
+	}
+	if cfg.BootDelay, err = flags.GetDuration("boot-delay"); err != nil {
+		return err
+	}
+	if cfg.BootJitter, err = flags.GetDuration("boot-jitter"); err != nil {
+		return err
+	}
+	if cfg.CreateFailureRate, err = flags.GetFloat64("create-failure-rate"); err != nil {
+		return err
+	}
+	if cfg.DeleteFailureRate, err = flags.GetFloat64("delete-failure-rate"); err != nil {
+		return err
+	}
+	if cfg.CreateTimeoutRate, err = flags.GetFloat64("create-timeout-rate"); err != nil {
+		return err
+	}
+	if cfg.Quota, err = flags.GetInt("quota"); err != nil {
+		return err
+	}
+	if cfg.AssignInterval, err = flags.GetDuration("assign-interval"); err != nil {
+		return err
+	}
+	if cfg.SolveTime, err = flags.GetDuration("solve-time"); err != nil {
+		return err
+	}
+	if cfg.ScoringTime, err = flags.GetDuration("scoring-time"); err != nil {
+		return err
+	}
+	if cfg.Seed, err = flags.GetInt64("seed"); err != nil {
+		return err
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+
+	lg, err := newLogger(cmd, "")
+	if err != nil {
+		return err
+	}
+
+	srv := devserver.NewServer(cfg)
+
+	errCh := make(chan error, 2)
+	go func() {
+		lg.Info("listening", zap.String("server", "vmdb-api"), zap.String("address", scoreserverListen))
+		errCh <- http.ListenAndServe(scoreserverListen, logRequests(lg.With(zap.String("server", "vmdb-api")), srv.ScoreserverHandler()))
+	}()
+	go func() {
+		lg.Info("listening", zap.String("server", "vm-management-server"), zap.String("address", vmmsListen))
+		errCh <- http.ListenAndServe(vmmsListen, logRequests(lg.With(zap.String("server", "vm-management-server")), srv.VmmsHandler()))
+	}()
+
+	return <-errCh
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests リクエストごとに method, path, status code をログに出力する
+func logRequests(lg *zap.Logger, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, req)
+		fields := []zap.Field{zap.String("method", req.Method), zap.String("path", req.URL.RequestURI()), zap.Int("status", rec.status)}
+		// schedulerの tracing が有効な場合は、送られてきた traceparent からspanとの対応が分かるようにする
+		if traceID, spanID, err := tracing.ParseTraceparent(req.Header.Get(tracing.TraceparentHeader)); err == nil {
+			fields = append(fields, logging.TraceID(hex.EncodeToString(traceID[:])), zap.String("parent_span_id", hex.EncodeToString(spanID[:])))
+		}
+		lg.Info("request", fields...)
+	})
+}
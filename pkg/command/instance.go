@@ -0,0 +1,323 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/janog-netcon/netcon-cli/pkg/scoreserver"
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// envAskpass ssh から SSH_ASKPASS として呼び出されたことを示す環境変数
+	envAskpass = "NETCON_ASKPASS"
+	// envAskpassPasswordFile SSH_ASKPASS として呼び出されたときに返すパスワードが書かれたファイル
+	envAskpassPasswordFile = "NETCON_ASKPASS_PASSWORD_FILE"
+
+	serviceSSH   = "SSH"
+	serviceHTTPS = "HTTPS"
+)
+
+func NewInstanceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "instance",
+		Short: "問題VMに接続する",
+	}
+
+	cmd.AddCommand(
+		NewInstanceSSHCommand(),
+	)
+
+	return cmd
+}
+
+func NewInstanceSSHCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "ssh [-- ssh options]",
+		Aliases: []string{"connect"},
+		Short:   "スコアサーバに登録されている接続先に ssh で接続する",
+		Long: `スコアサーバに登録されている接続先に ssh で接続する。
+パスワードは表示せず、SSH_ASKPASS で ssh に渡す (--copy-password でクリップボードにもコピーできる)。
+SSH_ASKPASS を使う場合はホスト鍵の確認に端末で答えられないため、known_hosts にない問題VMには接続できない。
+初めて接続する問題VMでは、--accept-new-host-key でホスト鍵を確認せずに登録するか、--askpass=false で接続すること。
+-- 以降の引数はそのまま ssh に渡す。`,
+		RunE: instanceSSHCommandFunc,
+	}
+
+	flags := cmd.Flags()
+	flags.StringP("name", "", "", "instance name")
+	flags.BoolP("https", "", false, "ssh で接続せずに HTTPS のURLを表示する")
+	flags.BoolP("copy-password", "", false, "パスワードをクリップボードにコピーする")
+	flags.BoolP("askpass", "", true, "SSH_ASKPASS でパスワードを ssh に渡す")
+	flags.BoolP("accept-new-host-key", "", false, "known_hosts にないホスト鍵を確認せずに登録する (StrictHostKeyChecking=accept-new)")
+	flags.StringP("ssh-command", "", "ssh", "ssh コマンドのパス")
+
+	cmd.MarkFlagRequired("name")
+
+	return cmd
+}
+
+func instanceSSHCommandFunc(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+
+	name, err := flags.GetString("name")
+	if err != nil {
+		return err
+	}
+	https, err := flags.GetBool("https")
+	if err != nil {
+		return err
+	}
+	copyPassword, err := flags.GetBool("copy-password")
+	if err != nil {
+		return err
+	}
+	askpass, err := flags.GetBool("askpass")
+	if err != nil {
+		return err
+	}
+	acceptNewHostKey, err := flags.GetBool("accept-new-host-key")
+	if err != nil {
+		return err
+	}
+	sshCommand, err := flags.GetString("ssh-command")
+	if err != nil {
+		return err
+	}
+
+	profile, err := resolveProfile(cmd, endpointFlags{}, nil)
+	if err != nil {
+		return err
+	}
+
+	cli := scoreserver.NewClient(profile.ScoreserverEndpoint)
+	env, err := cli.GetEnvironment(name)
+	if err != nil {
+		return err
+	}
+
+	lg, err := newLogger(cmd, "")
+	if err != nil {
+		return err
+	}
+
+	if copyPassword {
+		if err := copyToClipboard(env.Password); err != nil {
+			return err
+		}
+		lg.Info("copied password to clipboard")
+	}
+
+	if https {
+		endpoint, err := findService(env, serviceHTTPS)
+		if err != nil {
+			return err
+		}
+		fmt.Println(httpsURL(endpoint))
+		return nil
+	}
+
+	endpoint, err := findService(env, serviceSSH)
+	if err != nil {
+		return err
+	}
+
+	useAskpass := askpass && env.Password != ""
+
+	// ssh は最初に指定された値を使うため、-- 以降で指定された値があればそちらを優先する
+	sshArgs := append([]string{"-p", strconv.Itoa(endpoint.Port)}, args...)
+	if acceptNewHostKey {
+		sshArgs = append(sshArgs, "-o", "StrictHostKeyChecking=accept-new")
+	}
+	if useAskpass {
+		// パスワードが違う場合は繰り返し問い合わせない
+		sshArgs = append(sshArgs, "-o", "NumberOfPasswordPrompts=1")
+	}
+	if env.User != "" {
+		sshArgs = append(sshArgs, fmt.Sprintf("%s@%s", env.User, endpoint.Host))
+	} else {
+		sshArgs = append(sshArgs, endpoint.Host)
+	}
+	lg.Info("connecting", zap.String("command", sshCommand+" "+strings.Join(sshArgs, " ")))
+
+	c := exec.Command(sshCommand, sshArgs...)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	c.Env = os.Environ()
+
+	passwordFile := ""
+	if useAskpass {
+		// ssh から自分自身を SSH_ASKPASS として呼び出させ、ファイルに書き出したパスワードを返す
+		// (パスワードを ssh の引数や環境変数に含めないため。ファイルは自分だけが読めるように作り、ssh の終了後に削除する)
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		if passwordFile, err = writeAskpassPassword(env.Password); err != nil {
+			return err
+		}
+
+		c.Env = append(c.Env,
+			"SSH_ASKPASS="+executable,
+			"SSH_ASKPASS_REQUIRE=force",
+			envAskpass+"=1",
+			envAskpassPasswordFile+"="+passwordFile,
+		)
+		// OpenSSH 8.3以前は SSH_ASKPASS_REQUIRE に対応しておらず、DISPLAY が設定されている場合のみ SSH_ASKPASS を使う
+		if os.Getenv("DISPLAY") == "" {
+			c.Env = append(c.Env, "DISPLAY=:0")
+		}
+	}
+
+	// Ctrl-C などで自分だけが終了してパスワードのファイルが残らないように、signalは ssh に任せて終了を待つ
+	// (signal.Ignore は ssh にも引き継がれるため使わない。Ctrl-C は端末から ssh にも届くため SIGTERM だけを転送する)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	if err := c.Start(); err != nil {
+		if passwordFile != "" {
+			os.Remove(passwordFile)
+		}
+		return err
+	}
+	go func() {
+		for s := range sig {
+			if s == syscall.SIGTERM {
+				c.Process.Signal(s)
+			}
+		}
+	}()
+
+	err = c.Wait()
+	if passwordFile != "" {
+		os.Remove(passwordFile)
+	}
+	if err != nil {
+		// ssh のエラーは ssh が表示しているため、終了コードだけを引き継ぐ
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			cmd.SilenceErrors = true
+			cmd.SilenceUsage = true
+			return &ExitError{Code: exitErr.ExitCode()}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// writeAskpassPassword パスワードを自分だけが読める一時ファイルに書き出し、そのパスを返す
+func writeAskpassPassword(password string) (string, error) {
+	f, err := ioutil.TempFile("", "netcon-askpass-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if err := f.Chmod(0600); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if _, err := f.WriteString(password); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// RunAskpass ssh から SSH_ASKPASS として呼び出された場合はパスワードを出力してtrueを返す
+// パスワード以外の問い合わせには答えず、終了コード1で終了する
+// main でコマンドの解析より前に呼び出す
+func RunAskpass() bool {
+	if os.Getenv(envAskpass) != "1" {
+		return false
+	}
+
+	prompt := ""
+	if len(os.Args) > 1 {
+		prompt = os.Args[1]
+	}
+	password, ok := askpassPassword(prompt, os.Getenv("SSH_ASKPASS_PROMPT"), os.Getenv(envAskpassPasswordFile))
+	if !ok {
+		os.Exit(1)
+	}
+	fmt.Println(password)
+	return true
+}
+
+// askpassPassword ssh の問い合わせ prompt がパスワードの入力であれば、passwordFile に書かれたパスワードを返す
+// ホスト鍵の確認(yes/no)、鍵のパスフレーズ、ssh-agent の確認(SSH_ASKPASS_PROMPT=confirm)などには答えない
+func askpassPassword(prompt, promptType, passwordFile string) (string, bool) {
+	if promptType != "" {
+		return "", false
+	}
+	lower := strings.ToLower(prompt)
+	if !strings.Contains(lower, "password") || strings.Contains(lower, "yes/no") {
+		return "", false
+	}
+
+	bytes, err := ioutil.ReadFile(passwordFile)
+	if err != nil {
+		return "", false
+	}
+
+	return string(bytes), true
+}
+
+// findService service の接続先を返す。大文字小文字は区別しない
+func findService(env *types.Environment, service string) (types.Endpoint, error) {
+	for _, s := range env.Services {
+		if strings.EqualFold(s.Service, service) {
+			return s, nil
+		}
+	}
+
+	services := []string{}
+	for _, s := range env.Services {
+		services = append(services, s.Service)
+	}
+	return types.Endpoint{}, xerrors.New(fmt.Sprintf("%s has no %s service (services: %s)", env.Name, service, strings.Join(services, ", ")))
+}
+
+// httpsURL HTTPS の接続先のURLを返す。443番の場合はportを省略する
+func httpsURL(endpoint types.Endpoint) string {
+	if endpoint.Port == 443 || endpoint.Port == 0 {
+		return fmt.Sprintf("https://%s/", endpoint.Host)
+	}
+	return fmt.Sprintf("https://%s:%d/", endpoint.Host, endpoint.Port)
+}
+
+// copyToClipboard s をクリップボードにコピーする
+// pbcopy, wl-copy, xclip, xsel, clip.exe のうち最初に見つかったものを使う
+func copyToClipboard(s string) error {
+	candidates := [][]string{
+		{"pbcopy"},
+		{"wl-copy"},
+		{"xclip", "-selection", "clipboard"},
+		{"xsel", "--clipboard", "--input"},
+		{"clip.exe"},
+	}
+
+	for _, c := range candidates {
+		path, err := exec.LookPath(c[0])
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, c[1:]...)
+		cmd.Stdin = strings.NewReader(s)
+		return cmd.Run()
+	}
+
+	return xerrors.New("clipboard command is not found: install one of pbcopy, wl-copy, xclip, xsel")
+}
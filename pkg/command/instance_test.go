@@ -0,0 +1,119 @@
+package command
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_askpassPassword(t *testing.T) {
+	passwordFile, err := writeAskpassPassword("p@ssw0rd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(passwordFile)
+
+	info, err := os.Stat(passwordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("password file mode: got %o, want 600", info.Mode().Perm())
+	}
+
+	tests := []struct {
+		name       string
+		prompt     string
+		promptType string
+		file       string
+		want       string
+		wantOK     bool
+	}{
+		{name: "password", prompt: "user@192.0.2.1's password: ", file: passwordFile, want: "p@ssw0rd", wantOK: true},
+		{name: "keyboard-interactive", prompt: "(user@192.0.2.1) Password: ", file: passwordFile, want: "p@ssw0rd", wantOK: true},
+		{name: "host key", prompt: "The authenticity of host '[192.0.2.1]:50080' can't be established.\nAre you sure you want to continue connecting (yes/no/[fingerprint])? ", file: passwordFile},
+		{name: "passphrase", prompt: "Enter passphrase for key '/home/user/.ssh/id_ed25519': ", file: passwordFile},
+		{name: "confirm", prompt: "Allow use of key? Password", promptType: "confirm", file: passwordFile},
+		{name: "no password file", prompt: "user@192.0.2.1's password: ", file: passwordFile + ".missing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := askpassPassword(tt.prompt, tt.promptType, tt.file)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("askpassPassword() = (%q, %v), want (%q, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+
+	if b, _ := ioutil.ReadFile(passwordFile); string(b) != "p@ssw0rd" {
+		t.Errorf("password file: got %q", b)
+	}
+}
+
+func Test_instanceSSHExitCode(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"name": "image-sc0-aaaaa", "service": "SSH", "host": "192.0.2.1", "port": 50080, "user": "user", "password": "p@ssw0rd"}]`))
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "netcon-instance-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 引数を記録して終了コード3で終了する ssh
+	argsFile := filepath.Join(dir, "args")
+	ssh := filepath.Join(dir, "ssh")
+	if err := ioutil.WriteFile(ssh, []byte("#!/bin/sh\necho \"$@\" > "+argsFile+"\nexit 3\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	// パスワードのファイルが残っていないか確認するため、一時ファイルを dir/tmp に作らせる
+	tmpDir := filepath.Join(dir, "tmp")
+	if err := os.Mkdir(tmpDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tmpDir)
+
+	tests := []struct {
+		name          string
+		args          []string
+		wantAcceptNew bool
+	}{
+		{name: "default", wantAcceptNew: false},
+		{name: "--accept-new-host-key", args: []string{"--accept-new-host-key"}, wantAcceptNew: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runNetcon(t, append([]string{"instance", "ssh", "--name", "image-sc0-aaaaa", "--scoreserver-endpoint", ts.URL, "--ssh-command", ssh}, tt.args...)...)
+			exitErr, ok := err.(*ExitError)
+			if !ok || exitErr.Code != 3 {
+				t.Fatalf("got %#v, want ExitError with code 3", err)
+			}
+
+			// 終了コードを返す前にパスワードのファイルを削除している
+			files, err := ioutil.ReadDir(tmpDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 0 {
+				t.Errorf("password file is left: %v", files)
+			}
+
+			b, err := ioutil.ReadFile(argsFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(string(b), "StrictHostKeyChecking=accept-new"); got != tt.wantAcceptNew {
+				t.Errorf("ssh args %q: accept-new got %v, want %v", b, got, tt.wantAcceptNew)
+			}
+		})
+	}
+}
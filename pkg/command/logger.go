@@ -0,0 +1,39 @@
+package command
+
+import (
+	"github.com/janog-netcon/netcon-cli/pkg/logging"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// addLogFlags 全てのsubcommandで共通のログに関するフラグを追加する
+func addLogFlags(cmd *cobra.Command) {
+	flags := cmd.PersistentFlags()
+	flags.StringP("log-level", "", "info", "ログのレベル (debug, info, warn, error)")
+	flags.StringP("log-format", "", logging.FormatConsole, "標準エラー出力に出すログの形式 (console, json)")
+	flags.IntP("log-max-size", "", 100, "ログファイルがこのサイズ(MB)を超えたらローテーションする (0の場合はローテーションしない)")
+	flags.IntP("log-max-backups", "", 5, "ローテーションしたログファイルを残す数")
+}
+
+// newLogger --log-* フラグに従ってロガーを返す
+// logFilePath が空の場合は標準エラー出力にのみ出力する
+func newLogger(cmd *cobra.Command, logFilePath string) (*zap.Logger, error) {
+	flags := cmd.Flags()
+
+	opts := logging.Options{FilePath: logFilePath}
+	var err error
+	if opts.Level, err = flags.GetString("log-level"); err != nil {
+		return nil, err
+	}
+	if opts.Format, err = flags.GetString("log-format"); err != nil {
+		return nil, err
+	}
+	if opts.MaxSizeMB, err = flags.GetInt("log-max-size"); err != nil {
+		return nil, err
+	}
+	if opts.MaxBackups, err = flags.GetInt("log-max-backups"); err != nil {
+		return nil, err
+	}
+
+	return logging.New(opts)
+}
@@ -0,0 +1,289 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	outputTable      = "table"
+	outputWide       = "wide"
+	outputJSON       = "json"
+	outputYAML       = "yaml"
+	outputJSONPath   = "jsonpath"
+	outputGoTemplate = "go-template"
+)
+
+// outputFlagUsage -o/--output の説明
+const outputFlagUsage = "出力形式 (table, wide, json, yaml, jsonpath=<template>, go-template=<template>)"
+
+// addOutputFlag 全てのsubcommandで共通の -o/--output フラグを追加する
+func addOutputFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringP("output", "o", outputTable, outputFlagUsage)
+}
+
+// addShowSecretsFlag 全てのsubcommandで共通の --show-secrets フラグを追加する
+func addShowSecretsFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolP("show-secrets", "", false, "パスワードなどの秘密情報を伏せずに表示する")
+}
+
+// showSecrets --show-secrets が指定されているか
+func showSecrets(cmd *cobra.Command) bool {
+	show, _ := cmd.Flags().GetBool("show-secrets")
+	return show
+}
+
+// tableFunc table, wide 形式で出力するときに呼ばれる関数
+type tableFunc func(w io.Writer, wide bool) error
+
+// printOutput -o/--output フラグで指定された形式で v を標準出力に出力する
+// table, wide の場合は table を呼び出す。table が nil の場合は yaml で出力する
+func printOutput(cmd *cobra.Command, v interface{}, table tableFunc) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+	return writeOutput(cmd.OutOrStdout(), output, v, table)
+}
+
+// writeOutput output で指定された形式で v を out に書き込む
+// json以外の形式も、一度jsonに変換してから出力するため、フィールド名はjsonのタグに従う
+func writeOutput(out io.Writer, output string, v interface{}, table tableFunc) error {
+	format, arg := output, ""
+	if i := strings.Index(output, "="); i >= 0 {
+		format, arg = output[:i], output[i+1:]
+	}
+
+	switch format {
+	case outputTable, outputWide:
+		if table == nil {
+			return writeOutput(out, outputYAML, v, nil)
+		}
+		return table(out, format == outputWide)
+	case outputJSON:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(b))
+		return err
+	}
+
+	generic, err := toGeneric(v)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case outputYAML:
+		b, err := yaml.Marshal(generic)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(b)
+		return err
+	case outputJSONPath:
+		if arg == "" {
+			return xerrors.New("jsonpath template is empty: use -o jsonpath='{.[*].name}'")
+		}
+		s, err := evalJSONPathTemplate(arg, generic)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, s)
+		return err
+	case outputGoTemplate:
+		if arg == "" {
+			return xerrors.New("go-template is empty: use -o go-template='{{range .}}{{.name}}{{\"\\n\"}}{{end}}'")
+		}
+		t, err := template.New("output").Parse(arg)
+		if err != nil {
+			return err
+		}
+		return t.Execute(out, generic)
+	}
+
+	return xerrors.New(fmt.Sprintf("unknown output format: %s", output))
+}
+
+// toGeneric v をjsonに変換し、map[string]interface{} などの汎用的な値に戻す
+func toGeneric(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	return generic, nil
+}
+
+// evalJSONPathTemplate kubectl の -o jsonpath と同じように、{} で囲まれた部分をJSONPathとして評価する
+// {} の外側の文字列はそのまま出力する(\n, \t は改行とタブに変換する)
+// {} がない場合は全体をJSONPathとして扱う
+//
+// 対応しているJSONPath: $, .field, ['field'], [n], [*], .*
+func evalJSONPathTemplate(tmpl string, data interface{}) (string, error) {
+	if !strings.Contains(tmpl, "{") {
+		tmpl = "{" + tmpl + "}"
+	}
+
+	sb := strings.Builder{}
+	for len(tmpl) > 0 {
+		start := strings.Index(tmpl, "{")
+		if start < 0 {
+			sb.WriteString(unescapeLiteral(tmpl))
+			break
+		}
+		sb.WriteString(unescapeLiteral(tmpl[:start]))
+
+		end := strings.Index(tmpl[start:], "}")
+		if end < 0 {
+			return "", xerrors.New(fmt.Sprintf("unclosed jsonpath expression: %s", tmpl[start:]))
+		}
+		expr := tmpl[start+1 : start+end]
+		tmpl = tmpl[start+end+1:]
+
+		results, err := evalJSONPath(expr, data)
+		if err != nil {
+			return "", err
+		}
+		values := []string{}
+		for _, r := range results {
+			values = append(values, formatJSONPathValue(r))
+		}
+		sb.WriteString(strings.Join(values, " "))
+	}
+
+	return sb.String(), nil
+}
+
+func unescapeLiteral(s string) string {
+	return strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(s)
+}
+
+func formatJSONPathValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// evalJSONPath JSONPath式を評価し、一致した値の一覧を返す
+func evalJSONPath(expr string, data interface{}) ([]interface{}, error) {
+	segments, err := parseJSONPath(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	current := []interface{}{data}
+	for _, seg := range segments {
+		next := []interface{}{}
+		for _, v := range current {
+			switch {
+			case seg == "*":
+				switch v := v.(type) {
+				case []interface{}:
+					next = append(next, v...)
+				case map[string]interface{}:
+					keys := []string{}
+					for k := range v {
+						keys = append(keys, k)
+					}
+					sort.Strings(keys)
+					for _, k := range keys {
+						next = append(next, v[k])
+					}
+				}
+			case strings.HasPrefix(seg, "#"):
+				// 配列のindex
+				arr, ok := v.([]interface{})
+				if !ok {
+					continue
+				}
+				i, _ := strconv.Atoi(seg[1:])
+				if i < 0 {
+					i += len(arr)
+				}
+				if i >= 0 && i < len(arr) {
+					next = append(next, arr[i])
+				}
+			default:
+				if m, ok := v.(map[string]interface{}); ok {
+					if field, ok := m[seg]; ok {
+						next = append(next, field)
+					}
+				}
+			}
+		}
+		current = next
+	}
+
+	return current, nil
+}
+
+// parseJSONPath JSONPath式を要素に分解する
+// field名はそのまま、[*] と .* は "*"、[n] は "#n" になる
+func parseJSONPath(expr string) ([]string, error) {
+	expr = strings.TrimSpace(expr)
+	expr = strings.TrimPrefix(expr, "$")
+
+	segments := []string{}
+	for i := 0; i < len(expr); {
+		switch expr[i] {
+		case '.':
+			i++
+			j := i
+			for j < len(expr) && expr[j] != '.' && expr[j] != '[' {
+				j++
+			}
+			if j > i {
+				segments = append(segments, expr[i:j])
+			}
+			i = j
+		case '[':
+			j := strings.Index(expr[i:], "]")
+			if j < 0 {
+				return nil, xerrors.New(fmt.Sprintf("invalid jsonpath: unclosed [: %s", expr))
+			}
+			inner := strings.TrimSpace(expr[i+1 : i+j])
+			i += j + 1
+
+			switch {
+			case inner == "*":
+				segments = append(segments, "*")
+			case strings.HasPrefix(inner, "'") || strings.HasPrefix(inner, `"`):
+				segments = append(segments, strings.Trim(inner, `'"`))
+			default:
+				if _, err := strconv.Atoi(inner); err != nil {
+					return nil, xerrors.New(fmt.Sprintf("invalid jsonpath: unsupported subscript [%s]", inner))
+				}
+				segments = append(segments, "#"+inner)
+			}
+		default:
+			return nil, xerrors.New(fmt.Sprintf("invalid jsonpath: unexpected character %q in %s", expr[i], expr))
+		}
+	}
+
+	return segments, nil
+}
@@ -0,0 +1,72 @@
+package command
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_writeOutput(t *testing.T) {
+	v := []map[string]interface{}{
+		{"name": "image-sc0-aaaaa", "port": 22},
+		{"name": "image-sc0-bbbbb", "port": 2222},
+	}
+
+	tests := []struct {
+		name    string
+		output  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "jsonpath",
+			output: `jsonpath={[*].name}`,
+			want:   "image-sc0-aaaaa image-sc0-bbbbb\n",
+		},
+		{
+			name:   "jsonpath with index and literal",
+			output: `jsonpath=name={[1].name}\tport={$[1]['port']}`,
+			want:   "name=image-sc0-bbbbb\tport=2222\n",
+		},
+		{
+			name:   "jsonpath without braces",
+			output: `jsonpath=[-1].name`,
+			want:   "image-sc0-bbbbb\n",
+		},
+		{
+			name:   "go-template",
+			output: `go-template={{range .}}{{.name}}:{{.port}}{{"\n"}}{{end}}`,
+			want:   "image-sc0-aaaaa:22\nimage-sc0-bbbbb:2222\n",
+		},
+		{
+			name:   "yaml without table",
+			output: "table",
+			want:   "- name: image-sc0-aaaaa\n  port: 22\n- name: image-sc0-bbbbb\n  port: 2222\n",
+		},
+		{
+			name:    "invalid jsonpath",
+			output:  `jsonpath={[a].name}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown format",
+			output:  "csv",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			err := writeOutput(out, tt.output, v, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeOutput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if out.String() != tt.want {
+				t.Errorf("writeOutput() = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
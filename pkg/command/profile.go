@@ -0,0 +1,117 @@
+package command
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/janog-netcon/netcon-cli/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultScoreserverEndpoint = "http://127.0.0.1:8905"
+	defaultVmmsEndpoint        = "http://127.0.0.1:8950"
+)
+
+// addProfileFlags 全てのsubcommandで共通の --profile と接続先・認証情報のフラグを追加する
+func addProfileFlags(cmd *cobra.Command) {
+	flags := cmd.PersistentFlags()
+	flags.StringP("profile", "", "", "使用するプロファイル (~/.config/netcon/config.yaml, 環境変数 NETCON_PROFILE でも指定できる)")
+	flags.StringP("scoreserver-endpoint", "", defaultScoreserverEndpoint, "Score Server API Endpoint")
+	flags.StringP("vmms-endpoint", "", defaultVmmsEndpoint, "vm-management-server Endpoint")
+	flags.StringP("vmms-credential", "", "", "Token (shell historyに残らないよう --vmms-credential-file, NETCON_VMMS_CREDENTIAL の使用を推奨)")
+	flags.StringP("vmms-credential-file", "", "", "Tokenが書かれたファイル")
+}
+
+// endpointFlags 接続先と認証情報を指定する、以前からあるsubcommandごとのフラグの名前
+// 空の場合はそのフラグを持たない
+type endpointFlags struct {
+	scoreserverEndpoint string
+	vmmsEndpoint        string
+	vmmsCredential      string
+}
+
+var (
+	// scoreserver, vmms のsubcommandの --endpoint, --credential
+	scoreserverEndpointFlags = endpointFlags{scoreserverEndpoint: "endpoint"}
+	vmmsEndpointFlags        = endpointFlags{vmmsEndpoint: "endpoint", vmmsCredential: "credential"}
+)
+
+// addDeprecatedEndpointFlag 以前からある --endpoint などのフラグを、互換性のために残す
+func addDeprecatedEndpointFlag(cmd *cobra.Command, name, replacement string) {
+	flags := cmd.PersistentFlags()
+	flags.StringP(name, "", "", fmt.Sprintf("--%s を使うこと", replacement))
+	flags.MarkDeprecated(name, fmt.Sprintf("use --%s instead", replacement))
+}
+
+// resolveProfile 接続先と認証情報を決める
+// 優先順位は フラグ > 環境変数(NETCON_*) > --profile, NETCON_PROFILE で指定したプロファイル > base(コンテスト定義ファイルなど)
+// > current_profile のプロファイル > フラグのデフォルト値
+// deprecated には以前からあるsubcommandごとのフラグを指定し、共通のフラグが指定されていない場合に使う
+// 返り値の VmmsCredential にはcredentialのファイルを読み込んだ値が入る
+func resolveProfile(cmd *cobra.Command, deprecated endpointFlags, base *config.Profile) (*config.Profile, error) {
+	flags := cmd.Flags()
+
+	profileName, err := flags.GetString("profile")
+	if err != nil {
+		return nil, err
+	}
+	if !flags.Changed("profile") && os.Getenv(config.EnvProfile) != "" {
+		profileName = os.Getenv(config.EnvProfile)
+	}
+
+	pc, err := config.LoadProfileConfig(config.DefaultProfileConfigPath())
+	if err != nil {
+		return nil, err
+	}
+	selected, err := pc.Profile(profileName)
+	if err != nil {
+		return nil, err
+	}
+
+	// 明示的に選んだプロファイルは base より優先し、current_profile は base に値がない場合にのみ使う
+	p := config.Profile{}
+	if profileName == "" {
+		p = selected
+	}
+	if base != nil {
+		p = p.Merge(*base)
+	}
+	if profileName != "" {
+		p = p.Merge(selected)
+	}
+	p = p.Merge(config.ProfileFromEnv())
+
+	// フラグで指定された値
+	changed := func(names ...string) string {
+		for _, name := range names {
+			if name == "" || !flags.Changed(name) {
+				continue
+			}
+			v, _ := flags.GetString(name)
+			return v
+		}
+		return ""
+	}
+	p = p.Merge(config.Profile{
+		ScoreserverEndpoint: changed("scoreserver-endpoint", deprecated.scoreserverEndpoint),
+		VmmsEndpoint:        changed("vmms-endpoint", deprecated.vmmsEndpoint),
+		VmmsCredential:      changed("vmms-credential", deprecated.vmmsCredential),
+		VmmsCredentialFile:  changed("vmms-credential-file"),
+	})
+
+	// どこにも設定されていなければデフォルト値を使う
+	if p.ScoreserverEndpoint == "" {
+		p.ScoreserverEndpoint = defaultScoreserverEndpoint
+	}
+	if p.VmmsEndpoint == "" {
+		p.VmmsEndpoint = defaultVmmsEndpoint
+	}
+
+	if p.VmmsCredential, err = p.Credential(); err != nil {
+		return nil, err
+	}
+	p.VmmsCredentialFile = ""
+
+	return &p, nil
+}
@@ -0,0 +1,126 @@
+package command
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/janog-netcon/netcon-cli/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+func Test_resolveProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netcon-profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "config.yaml")
+	profiles := `current_profile: staging
+profiles:
+  staging:
+    scoreserver_endpoint: http://staging-score
+    vmms_endpoint: http://staging-vmms
+    vmms_credential: staging-credential
+  contest:
+    scoreserver_endpoint: http://contest-score
+    vmms_endpoint: http://contest-vmms
+`
+	if err := ioutil.WriteFile(configPath, []byte(profiles), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// コンテスト定義ファイルの接続先
+	base := &config.Profile{
+		ScoreserverEndpoint: "http://config-score",
+		VmmsCredential:      "config-credential",
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		base *config.Profile
+		want config.Profile
+	}{
+		{
+			name: "current_profile",
+			want: config.Profile{ScoreserverEndpoint: "http://staging-score", VmmsEndpoint: "http://staging-vmms", VmmsCredential: "staging-credential"},
+		},
+		{
+			name: "config file overrides current_profile",
+			base: base,
+			want: config.Profile{ScoreserverEndpoint: "http://config-score", VmmsEndpoint: "http://staging-vmms", VmmsCredential: "config-credential"},
+		},
+		{
+			name: "--profile overrides config file",
+			args: []string{"--profile", "contest"},
+			base: base,
+			want: config.Profile{ScoreserverEndpoint: "http://contest-score", VmmsEndpoint: "http://contest-vmms", VmmsCredential: "config-credential"},
+		},
+		{
+			name: "NETCON_PROFILE overrides config file",
+			env:  map[string]string{config.EnvProfile: "contest"},
+			base: base,
+			want: config.Profile{ScoreserverEndpoint: "http://contest-score", VmmsEndpoint: "http://contest-vmms", VmmsCredential: "config-credential"},
+		},
+		{
+			name: "--profile overrides NETCON_PROFILE",
+			args: []string{"--profile", "staging"},
+			env:  map[string]string{config.EnvProfile: "contest"},
+			want: config.Profile{ScoreserverEndpoint: "http://staging-score", VmmsEndpoint: "http://staging-vmms", VmmsCredential: "staging-credential"},
+		},
+		{
+			name: "environment variable overrides profile",
+			args: []string{"--profile", "contest"},
+			env:  map[string]string{config.EnvVmmsEndpoint: "http://env-vmms"},
+			base: base,
+			want: config.Profile{ScoreserverEndpoint: "http://contest-score", VmmsEndpoint: "http://env-vmms", VmmsCredential: "config-credential"},
+		},
+		{
+			name: "flag overrides everything",
+			args: []string{"--profile", "contest", "--scoreserver-endpoint", "http://flag-score", "--vmms-credential", "flag-credential"},
+			env:  map[string]string{config.EnvScoreserverEndpoint: "http://env-score"},
+			base: base,
+			want: config.Profile{ScoreserverEndpoint: "http://flag-score", VmmsEndpoint: "http://contest-vmms", VmmsCredential: "flag-credential"},
+		},
+		{
+			name: "deprecated flag",
+			args: []string{"--profile", "contest", "--endpoint", "http://old-vmms", "--credential", "old-credential"},
+			want: config.Profile{ScoreserverEndpoint: "http://contest-score", VmmsEndpoint: "http://old-vmms", VmmsCredential: "old-credential"},
+		},
+		{
+			name: "flag overrides deprecated flag",
+			args: []string{"--profile", "contest", "--endpoint", "http://old-vmms", "--vmms-endpoint", "http://flag-vmms"},
+			want: config.Profile{ScoreserverEndpoint: "http://contest-score", VmmsEndpoint: "http://flag-vmms"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{config.EnvConfig: configPath}
+			for k, v := range tt.env {
+				env[k] = v
+			}
+			setNetconEnv(t, env)
+
+			cmd := &cobra.Command{}
+			addProfileFlags(cmd)
+			addDeprecatedEndpointFlag(cmd, "endpoint", "vmms-endpoint")
+			addDeprecatedEndpointFlag(cmd, "credential", "vmms-credential")
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := resolveProfile(cmd, vmmsEndpointFlags, tt.base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("got %#v, want %#v", *got, tt.want)
+			}
+		})
+	}
+}
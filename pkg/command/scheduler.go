@@ -1,22 +1,30 @@
 package command
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
 	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
+	"github.com/janog-netcon/netcon-cli/pkg/config"
+	"github.com/janog-netcon/netcon-cli/pkg/leader"
+	"github.com/janog-netcon/netcon-cli/pkg/notifier"
+	"github.com/janog-netcon/netcon-cli/pkg/prober"
+	"github.com/janog-netcon/netcon-cli/pkg/receiver"
 	"github.com/janog-netcon/netcon-cli/pkg/scheduler"
 	"github.com/janog-netcon/netcon-cli/pkg/scoreserver"
+	"github.com/janog-netcon/netcon-cli/pkg/tracing"
 	"github.com/janog-netcon/netcon-cli/pkg/types"
 	"github.com/janog-netcon/netcon-cli/pkg/vmms"
 	"github.com/robfig/cron/v3"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
-	"gopkg.in/yaml.v2"
 )
 
 func NewSchedulerCommand() *cobra.Command {
@@ -27,6 +35,8 @@ func NewSchedulerCommand() *cobra.Command {
 	cmd.AddCommand(
 		NewSchedulerStartCommand(),
 		NewSchedulerDumpCommand(),
+		NewSchedulerSimulateCommand(),
+		NewSchedulerReplayCommand(),
 	)
 
 	flags := cmd.PersistentFlags()
@@ -46,6 +56,7 @@ func NewSchedulerStartCommand() *cobra.Command {
 	flags := cmd.Flags()
 	flags.BoolP("oneshot", "", false, "cronでの繰り返し実行を行わずに1度のみ実行する")
 	flags.StringP("log-file-path", "", "./scheduler.log", "Scheduler logfile")
+	flags.StringP("record-dir", "", "", "実行ごとに /problem-environments のレスポンスと計画した作成・削除を保存するディレクトリ (scheduler replay で使う)")
 
 	return cmd
 }
@@ -65,60 +76,154 @@ func schedulerStartCommandFunc(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	recordDir, err := flags.GetString("record-dir")
+	if err != nil {
+		return err
+	}
 
 	// logger
-	/*
-		lg, err := zap.NewDevelopment()
-		if err != nil {
-			return err
-		}
-	*/
-	lg := newLogger(logFilePath)
-
-	// read mapping file
-	bytes, err := ioutil.ReadFile(configPath)
+	lg, err := newLogger(cmd, logFilePath)
 	if err != nil {
 		return err
 	}
 
-	cfg := types.SchedulerConfig{}
-	if err := yaml.Unmarshal(bytes, &cfg); err != nil {
+	// read config file (コンテスト定義ファイル、旧形式の設定ファイルのどちらも読み込める)
+	cfg, err := config.LoadSchedulerConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if err := applyProfile(cmd, cfg); err != nil {
+		return err
+	}
+	if err := scheduler.ValidateDeletionPolicies(cfg); err != nil {
 		return err
 	}
-
-	// lg.Info(fmt.Sprintf("[INFO] config: %#v\n", cfg))
 
 	// schedulerの起動
 	scoreserverClient := scoreserver.NewClient(cfg.Setting.Scoreserver.Endpoint)
+	scoreserverClient.UseUpdatedSince = cfg.Setting.Scoreserver.UseUpdatedSince
+	scoreserverClient.FullListInterval = time.Duration(cfg.Setting.Scoreserver.FullListInterval) * time.Second
 	vmmsClient := vmms.NewClient(cfg.Setting.Vmms.Endpoint, cfg.Setting.Vmms.Credential)
+	if cfg.Setting.Vmms.Timeout > 0 {
+		vmmsClient.Timeout = time.Duration(cfg.Setting.Vmms.Timeout) * time.Second
+	}
+	nt := notifier.NewNotifier(cfg, lg)
+	defer nt.Close()
+	st := scheduler.NewState()
+	st.SetProber(prober.NewProber(cfg, lg))
+	// tracing.exporter が空の場合はnilになり、spanを記録しない
+	// spanは1回の実行ごとにバックグラウンドで送る
+	tracer, err := tracing.NewTracer(cfg, lg)
+	if err != nil {
+		return err
+	}
+	defer tracer.Shutdown()
+	st.SetTracer(tracer)
+	scoreserverClient.Tracer = tracer
+	vmmsClient.Tracer = tracer
+	recorder, err := scheduler.NewRecorder(recordDir)
+	if err != nil {
+		return err
+	}
+	st.SetRecorder(recorder)
+	// leader_election が無効な場合はnilになり、常にリーダーとして扱われる
+	elector, err := leader.NewElector(cfg, lg)
+	if err != nil {
+		return err
+	}
+	if elector != nil {
+		// 実行中にリースを失った場合は、インスタンスの作成・削除を途中で中止する
+		st.SetLeaderCheck(elector.IsLeader)
+	}
 
 	// oneshotオプション
 	if oneshot {
-		scheduler.SchedulerReady(&cfg, scoreserverClient, vmmsClient, lg)
+		if elector != nil {
+			if !elector.Tick() {
+				lg.Info("Leader: another replica is leader. skip scheduling.")
+				return nil
+			}
+			defer elector.Release()
+		}
+		err := scheduler.SchedulerReady(cfg, scoreserverClient, vmmsClient, nt, st, lg)
 		if err != nil {
 			return err
 		}
 		return nil
 	}
 
-	c := cron.New()
 	// lock
 	mutex := &sync.Mutex{}
-	c.AddFunc(cfg.Setting.Cron, func() {
-		// lg.Info("cron start!!")
+
+	// leader_election が無効な場合は stop, done を使わない
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	if elector != nil {
+		go func() {
+			elector.Run(stop)
+			close(done)
+		}()
+	}
+
+	run := func() {
 		mutex.Lock()
 		defer mutex.Unlock()
-		if err := scheduler.SchedulerReady(&cfg, scoreserverClient, vmmsClient, lg); err != nil {
-			fmt.Println(err)
+		// リーダーでないレプリカは待機する
+		if !elector.IsLeader() {
+			lg.Debug("Leader: standby. skip scheduling.")
+			return
+		}
+		if err := scheduler.SchedulerReady(cfg, scoreserverClient, vmmsClient, nt, st, lg); err != nil {
+			lg.Error("Scheduler: SchedulerReady failed", zap.Error(err))
 		}
+	}
+
+	c := cron.New()
+	c.AddFunc(cfg.Setting.Cron, func() {
+		// lg.Info("cron start!!")
+		run()
 		// lg.Info("cron finish!!")
 	})
 	c.Start()
 
-	for {
-		time.Sleep(time.Second * 10)
+	// スコアサーバからのcallbackで即時実行する (cronは取りこぼし対策として残す)
+	if rcv := receiver.NewReceiver(cfg, lg); rcv != nil {
+		rcv.SetStatus(func() interface{} {
+			return elector.Status()
+		})
+		go rcv.Run(run)
+		go func() {
+			lg.Info("Receiver: listening", zap.String("address", rcv.ListenAddress))
+			if err := rcv.ListenAndServe(); err != nil {
+				lg.Error("Receiver: stopped", zap.Error(err))
+			}
+		}()
 	}
 
+	// SIGINT/SIGTERM を受け取るまで待つ
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	received := <-sig
+	lg.Info("Scheduler: shutting down", zap.String("signal", received.String()))
+
+	// 実行中の作成・削除が終わるのを待ち、以降は実行しない
+	// (リースを解放した後に作成・削除すると、他のレプリカと重複するため)
+	c.Stop()
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// リースを解放し、待機しているレプリカがすぐに引き継げるようにする
+	if elector != nil {
+		close(stop)
+		<-done
+	}
+	// 送信待ちの通知と残っているspanを送り、ログを書き出す
+	nt.Close()
+	if err := tracer.Shutdown(); err != nil {
+		lg.Warn("Tracing: Failed to shutdown", zap.Error(err))
+	}
+	lg.Sync()
+
 	return nil
 }
 
@@ -146,16 +251,17 @@ func schedulerDumpCommandFunc(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	lg := newLogger(logFilePath)
-
-	// read mapping file
-	bytes, err := ioutil.ReadFile(configPath)
+	lg, err := newLogger(cmd, logFilePath)
 	if err != nil {
 		return err
 	}
 
-	cfg := types.SchedulerConfig{}
-	if err := yaml.Unmarshal(bytes, &cfg); err != nil {
+	// read config file (コンテスト定義ファイル、旧形式の設定ファイルのどちらも読み込める)
+	cfg, err := config.LoadSchedulerConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if err := applyProfile(cmd, cfg); err != nil {
 		return err
 	}
 
@@ -163,61 +269,109 @@ func schedulerDumpCommandFunc(cmd *cobra.Command, args []string) error {
 	scoreserverClient := scoreserver.NewClient(cfg.Setting.Scoreserver.Endpoint)
 	vmmsClient := vmms.NewClient(cfg.Setting.Vmms.Endpoint, cfg.Setting.Vmms.Credential)
 
-	problems, zonePriorities, err := scheduler.Dump(&cfg, scoreserverClient, vmmsClient, lg)
+	problems, zonePriorities, err := scheduler.Dump(cfg, scoreserverClient, vmmsClient, lg)
 	if err != nil {
 		return err
 	}
 
+	// proberが有効な場合は1回だけ接続確認を行い、結果を表示する
+	// (1回の確認なので failure_threshold によらず、接続できなかったインスタンスは healthy: false になる)
+	pr := prober.NewProber(cfg, lg)
+	if pr != nil {
+		pr.FailureThreshold = 1
+		scheduler.ProbeInstances(problems, pr, lg)
+	}
+	scheduler.ExpireInstances(problems, time.Now(), lg)
+	probes := pr.Snapshot()
+
 	j := struct {
 		Problems       map[string]*scheduler.Problem `json:"problems"`
 		ZonePriorities []*scheduler.ZonePriority     `json:"zone_priorities"`
+		Probes         map[string]prober.Health      `json:"probes,omitempty"`
 	}{
 		Problems:       problems,
 		ZonePriorities: zonePriorities,
+		Probes:         probes,
 	}
 
-	b, err := json.MarshalIndent(&j, "", "  ")
-	if err != nil {
-		fmt.Println("error:", err)
-	}
-	os.Stdout.Write(b)
+	return printOutput(cmd, &j, func(out io.Writer, wide bool) error {
+		names := []string{}
+		for name := range problems {
+			names = append(names, name)
+		}
+		sort.Strings(names)
 
-	return nil
-}
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		if wide {
+			fmt.Fprintln(w, "PROBLEM\tPROBLEM_ID\tPOOL_COUNT\tREADY\tNOT_READY\tUNDER_CHALLENGE\tUNDER_SCORING\tABANDONED\tUNKNOWN\tUNHEALTHY\tEXPIRED\tCURRENT_INSTANCE\tKEPT_INSTANCES")
+		} else {
+			fmt.Fprintln(w, "PROBLEM\tPOOL_COUNT\tREADY\tNOT_READY\tUNDER_CHALLENGE\tUNDER_SCORING\tABANDONED\tUNKNOWN\tUNHEALTHY\tEXPIRED\tCURRENT_INSTANCE")
+		}
+		for _, name := range names {
+			p := problems[name]
+			if wide {
+				kept := []string{}
+				for _, i := range p.KeptInstances {
+					kept = append(kept, i.InstanceName)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n",
+					name, p.ProblemID, p.PoolCount, p.Ready, p.NotReady, p.UnderChallenge, p.UnderScoring, p.Abandoned, p.Unknown, p.Unhealthy, p.Expired, p.CurrentInstance, strings.Join(kept, ","))
+			} else {
+				fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n",
+					name, p.PoolCount, p.Ready, p.NotReady, p.UnderChallenge, p.UnderScoring, p.Abandoned, p.Unknown, p.Unhealthy, p.Expired, p.CurrentInstance)
+			}
+		}
+		w.Flush()
 
-// https://k1low.hatenablog.com/entry/2018/08/15/100000
-func newLogger(logFilePath string) *zap.Logger {
-	encoderConfig := zapcore.EncoderConfig{
-		TimeKey:        "time",
-		LevelKey:       "level",
-		NameKey:        "name",
-		CallerKey:      "caller",
-		MessageKey:     "msg",
-		StacktraceKey:  "stacktrace",
-		EncodeLevel:    zapcore.LowercaseLevelEncoder,
-		EncodeTime:     zapcore.ISO8601TimeEncoder,
-		EncodeDuration: zapcore.StringDurationEncoder,
-		EncodeCaller:   zapcore.ShortCallerEncoder,
-	}
-
-	file, _ := os.OpenFile(logFilePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-
-	consoleCore := zapcore.NewCore(
-		zapcore.NewConsoleEncoder(encoderConfig),
-		zapcore.AddSync(os.Stdout),
-		zapcore.DebugLevel,
-	)
+		fmt.Fprintln(out)
 
-	logCore := zapcore.NewCore(
-		zapcore.NewJSONEncoder(encoderConfig),
-		zapcore.AddSync(file),
-		zapcore.InfoLevel,
-	)
+		w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PROJECT\tZONE\tPRIORITY\tMAX_INSTANCE\tCURRENT_INSTANCE")
+		for _, zp := range zonePriorities {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", zp.ProjectName, zp.ZoneName, zp.Priority, zp.MaxInstance, zp.CurrentInstance)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if len(probes) == 0 {
+			return nil
+		}
+
+		instanceNames := []string{}
+		for name := range probes {
+			instanceNames = append(instanceNames, name)
+		}
+		sort.Strings(instanceNames)
+
+		fmt.Fprintln(out)
 
-	logger := zap.New(zapcore.NewTee(
-		consoleCore,
-		logCore,
-	))
+		w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "INSTANCE\tHEALTHY\tSERVICE\tADDRESS\tLATENCY\tERROR")
+		for _, name := range instanceNames {
+			h := probes[name]
+			for _, r := range h.Results {
+				fmt.Fprintf(w, "%s\t%t\t%s\t%s\t%dms\t%s\n", name, h.Healthy, r.Service, r.Address, r.LatencyMs, r.Error)
+			}
+		}
+		return w.Flush()
+	})
+}
 
-	return logger
+// applyProfile 環境変数(NETCON_*)とプロファイルの接続先を設定ファイルに反映する
+// 環境変数と --profile, NETCON_PROFILE で指定したプロファイルは設定ファイルより優先し、
+// current_profile のプロファイルは設定ファイルに値がない場合にのみ使う
+func applyProfile(cmd *cobra.Command, cfg *types.SchedulerConfig) error {
+	base := config.ProfileFromSetting(&cfg.Setting)
+	profile, err := resolveProfile(cmd, endpointFlags{}, &base)
+	if err != nil {
+		return err
+	}
+
+	cfg.Setting.Scoreserver.Endpoint = profile.ScoreserverEndpoint
+	cfg.Setting.Vmms.Endpoint = profile.VmmsEndpoint
+	cfg.Setting.Vmms.Credential = profile.VmmsCredential
+	cfg.Setting.Vmms.CredentialFile = ""
+
+	return nil
 }
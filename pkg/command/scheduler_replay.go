@@ -0,0 +1,126 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/config"
+	"github.com/janog-netcon/netcon-cli/pkg/scheduler"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"golang.org/x/xerrors"
+)
+
+// replayResult scheduler replay の1記録ごとの結果
+type replayResult struct {
+	File     string            `json:"file"`
+	Time     time.Time         `json:"time"`
+	Recorded scheduler.Actions `json:"recorded"`
+	Replayed scheduler.Actions `json:"replayed"`
+	Diff     []string          `json:"diff"`
+}
+
+func NewSchedulerReplayCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "scheduler start --record-dir で記録したスコアサーバの状態を現在の設定で再計算し、計画される作成・削除の差分を表示する",
+		RunE:  schedulerReplayCommandFunc,
+	}
+
+	flags := cmd.Flags()
+	flags.StringP("record-dir", "", "", "scheduler start --record-dir で記録したディレクトリ")
+
+	cmd.MarkFlagRequired("record-dir")
+
+	return cmd
+}
+
+func schedulerReplayCommandFunc(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+
+	configPath, err := flags.GetString("config")
+	if err != nil {
+		return err
+	}
+	recordDir, err := flags.GetString("record-dir")
+	if err != nil {
+		return err
+	}
+
+	// read config file (コンテスト定義ファイル、旧形式の設定ファイルのどちらも読み込める)
+	cfg, err := config.LoadSchedulerConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if err := scheduler.ValidateDeletionPolicies(cfg); err != nil {
+		return err
+	}
+
+	names, records, err := scheduler.LoadRecords(recordDir)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return xerrors.Errorf("no records in %s", recordDir)
+	}
+
+	results := []replayResult{}
+	differ := 0
+	for _, name := range names {
+		record := records[name]
+		replayed := scheduler.Replay(cfg, record, zap.NewNop())
+		diff := scheduler.DiffActions(record.Actions, replayed)
+		if len(diff) > 0 {
+			differ++
+		}
+		results = append(results, replayResult{
+			File:     name,
+			Time:     record.Time,
+			Recorded: record.Actions,
+			Replayed: replayed,
+			Diff:     diff,
+		})
+	}
+
+	err = printOutput(cmd, results, func(out io.Writer, wide bool) error {
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "FILE\tTIME\tRECORDED(D/C)\tREPLAYED(D/C)\tDIFF")
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%s\t%d/%d\t%d/%d\t%d\n", r.File, r.Time.Local().Format(time.RFC3339),
+				countDeletions(r.Recorded), len(r.Recorded.Creations), countDeletions(r.Replayed), len(r.Replayed.Creations), len(r.Diff))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if !wide {
+			return nil
+		}
+
+		// 差分の内容
+		for _, r := range results {
+			if len(r.Diff) == 0 {
+				continue
+			}
+			fmt.Fprintf(out, "\n%s:\n", r.File)
+			for _, d := range r.Diff {
+				fmt.Fprintf(out, "  %s\n", d)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if differ > 0 {
+		return xerrors.Errorf("%d record(s) differ", differ)
+	}
+	return nil
+}
+
+func countDeletions(a scheduler.Actions) int {
+	return len(a.Abandoned) + len(a.Unhealthy) + len(a.Deletions)
+}
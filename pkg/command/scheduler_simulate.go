@@ -0,0 +1,108 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/janog-netcon/netcon-cli/pkg/config"
+	"github.com/janog-netcon/netcon-cli/pkg/scheduler"
+	"github.com/janog-netcon/netcon-cli/pkg/simulator"
+	"github.com/spf13/cobra"
+)
+
+func NewSchedulerSimulateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "simulate",
+		Short: "シナリオに従ってschedulerをメモリ上で動かし、pool_count と max_instance が足りるかを確認する",
+		RunE:  schedulerSimulateCommandFunc,
+	}
+
+	flags := cmd.Flags()
+	flags.StringP("scenario", "", "", "シナリオファイル")
+
+	cmd.MarkFlagRequired("scenario")
+
+	return cmd
+}
+
+func schedulerSimulateCommandFunc(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+
+	configPath, err := flags.GetString("config")
+	if err != nil {
+		return err
+	}
+	scenarioPath, err := flags.GetString("scenario")
+	if err != nil {
+		return err
+	}
+
+	// read config file (コンテスト定義ファイル、旧形式の設定ファイルのどちらも読み込める)
+	cfg, err := config.LoadSchedulerConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if err := scheduler.ValidateDeletionPolicies(cfg); err != nil {
+		return err
+	}
+	scenario, err := simulator.LoadScenario(scenarioPath)
+	if err != nil {
+		return err
+	}
+
+	sim, err := simulator.NewSimulator(cfg, scenario)
+	if err != nil {
+		return err
+	}
+	result := sim.Run()
+
+	return printOutput(cmd, result, func(out io.Writer, wide bool) error {
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PROBLEM\tPOOL_COUNT\tASSIGNMENTS\tWAIT_AVG\tWAIT_P95\tWAIT_MAX\tDEPLETED_MIN\tFIRST_DEPLETION_MIN\tSTILL_WAITING")
+		for _, p := range result.Problems {
+			firstDepletion := "-"
+			if p.FirstDepletionMinute >= 0 {
+				firstDepletion = fmt.Sprintf("%.1f", p.FirstDepletionMinute)
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%.0fs\t%.0fs\t%.0fs\t%.1f\t%s\t%d\n",
+				p.MachineImageName, p.PoolCount, p.Assignments, p.WaitAvgSeconds, p.WaitP95Seconds, p.WaitMaxSeconds, p.DepletedMinutes, firstDepletion, p.StillWaiting)
+		}
+		w.Flush()
+
+		fmt.Fprintln(out)
+
+		w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PROJECT\tZONE\tMAX_INSTANCE\tPEAK_INSTANCE\tFULL_MIN")
+		for _, z := range result.Zones {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.1f\n", z.ProjectName, z.ZoneName, z.MaxInstance, z.PeakInstance, z.FullMinutes)
+		}
+		w.Flush()
+
+		fmt.Fprintf(out, "\npeak instances: %d, created: %d, deleted: %d, solved problems: %d\n",
+			result.PeakInstance, result.CreatedCount, result.DeletedCount, result.SolvedProblems)
+
+		if !wide {
+			return nil
+		}
+
+		// 時系列
+		fmt.Fprintln(out)
+
+		w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "MINUTE\tPROBLEM\tREADY\tNOT_READY\tIN_USE\tWAITING")
+		for _, s := range result.TimeSeries {
+			names := []string{}
+			for name := range s.Problems {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				p := s.Problems[name]
+				fmt.Fprintf(w, "%.0f\t%s\t%d\t%d\t%d\t%d\n", s.ElapsedMinutes, name, p.Ready, p.NotReady, p.InUse, p.Waiting)
+			}
+		}
+		return w.Flush()
+	})
+}
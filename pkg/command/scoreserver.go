@@ -1,10 +1,13 @@
 package command
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
 
 	"github.com/janog-netcon/netcon-cli/pkg/scoreserver"
+	"github.com/janog-netcon/netcon-cli/pkg/types"
 	"github.com/spf13/cobra"
 )
 
@@ -17,8 +20,7 @@ func NewScoreserverCommand() *cobra.Command {
 		NewScoreserverInstanceCommand(),
 	)
 
-	flags := cmd.PersistentFlags()
-	flags.StringP("endpoint", "", "http://127.0.0.1:8905", "Score Server API Endpoint")
+	addDeprecatedEndpointFlag(cmd, "endpoint", "scoreserver-endpoint")
 
 	return cmd
 }
@@ -46,23 +48,41 @@ func NewScoreserverInstanceListCommand() *cobra.Command {
 }
 
 func scoreserverInstanceListCommandFunc(cmd *cobra.Command, args []string) error {
-	flags := cmd.Flags()
-
-	endpoint, err := flags.GetString("endpoint")
+	profile, err := resolveProfile(cmd, scoreserverEndpointFlags, nil)
 	if err != nil {
 		return err
 	}
 
-	cli := scoreserver.NewClient(endpoint)
+	cli := scoreserver.NewClient(profile.ScoreserverEndpoint)
 	pes, err := cli.ListProblemEnvironment()
 	if err != nil {
 		return err
 	}
+	if !showSecrets(cmd) {
+		for i := range *pes {
+			(*pes)[i] = (*pes)[i].Redacted()
+		}
+	}
 
-	b, err := json.Marshal(pes)
-	fmt.Println(string(b))
-
-	return nil
+	return printOutput(cmd, pes, func(out io.Writer, wide bool) error {
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		if wide {
+			fmt.Fprintln(w, "NAME\tMACHINE_IMAGE_NAME\tINNER_STATUS\tSTATUS\tSERVICE\tHOST\tPORT\tPROJECT\tZONE\tPROBLEM_ID\tCREATED_AT")
+		} else {
+			fmt.Fprintln(w, "NAME\tMACHINE_IMAGE_NAME\tINNER_STATUS\tSERVICE\tHOST\tPORT")
+		}
+		for _, pe := range *pes {
+			innerStatus := types.NormalizeInnerStatus(pe.InnerStatus)
+			if wide {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+					pe.Name, stringOrEmpty(pe.MachineImageName), innerStatus, stringOrEmpty(pe.Status), pe.Service, pe.Host, pe.Port, pe.ProjectName, pe.ZoneName, pe.ProblemID, pe.CreatedAt.Format(time.RFC3339))
+			} else {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n",
+					pe.Name, stringOrEmpty(pe.MachineImageName), innerStatus, pe.Service, pe.Host, pe.Port)
+			}
+		}
+		return w.Flush()
+	})
 }
 
 func NewScoreserverInstanceGetCommand() *cobra.Command {
@@ -82,7 +102,7 @@ func NewScoreserverInstanceGetCommand() *cobra.Command {
 func scoreserverInstanceGetCommandFunc(cmd *cobra.Command, args []string) error {
 	flags := cmd.Flags()
 
-	endpoint, err := flags.GetString("endpoint")
+	profile, err := resolveProfile(cmd, scoreserverEndpointFlags, nil)
 	if err != nil {
 		return err
 	}
@@ -91,14 +111,40 @@ func scoreserverInstanceGetCommandFunc(cmd *cobra.Command, args []string) error
 		return err
 	}
 
-	cli := scoreserver.NewClient(endpoint)
-	pes, err := cli.GetProblemEnvironment(name)
+	cli := scoreserver.NewClient(profile.ScoreserverEndpoint)
+	env, err := cli.GetEnvironment(name)
 	if err != nil {
 		return err
 	}
+	if !showSecrets(cmd) {
+		*env = env.Redacted()
+	}
 
-	b, err := json.Marshal(pes)
-	fmt.Println(string(b))
+	return printOutput(cmd, env, func(out io.Writer, wide bool) error {
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "NAME:\t%s\n", env.Name)
+		fmt.Fprintf(w, "MACHINE_IMAGE_NAME:\t%s\n", stringOrEmpty(env.MachineImageName))
+		fmt.Fprintf(w, "INNER_STATUS:\t%s\n", types.NormalizeInnerStatus(env.InnerStatus))
+		fmt.Fprintf(w, "STATUS:\t%s\n", stringOrEmpty(env.Status))
+		fmt.Fprintf(w, "PROJECT:\t%s\n", env.ProjectName)
+		fmt.Fprintf(w, "ZONE:\t%s\n", env.ZoneName)
+		fmt.Fprintf(w, "USER:\t%s\n", env.User)
+		fmt.Fprintf(w, "PASSWORD:\t%s\n", env.Password)
+		if wide {
+			fmt.Fprintf(w, "PROBLEM_ID:\t%s\n", env.ProblemID)
+			fmt.Fprintf(w, "CREATED_AT:\t%s\n", env.CreatedAt.Format(time.RFC3339))
+			fmt.Fprintf(w, "UPDATED_AT:\t%s\n", env.UpdatedAt.Format(time.RFC3339))
+		}
+		for _, s := range env.Services {
+			fmt.Fprintf(w, "%s:\t%s:%d\n", s.Service, s.Host, s.Port)
+		}
+		return w.Flush()
+	})
+}
 
-	return nil
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
 }
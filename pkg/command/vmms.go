@@ -1,8 +1,9 @@
 package command
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
+	"text/tabwriter"
 
 	"github.com/janog-netcon/netcon-cli/pkg/vmms"
 	"github.com/spf13/cobra"
@@ -17,9 +18,8 @@ func NewVmmsCommand() *cobra.Command {
 		NewVmmsInstanceCommand(),
 	)
 
-	flags := cmd.PersistentFlags()
-	flags.StringP("endpoint", "", "http://127.0.0.1:8950", "vm-management-server Endpoint")
-	flags.StringP("credential", "", "", "Token")
+	addDeprecatedEndpointFlag(cmd, "endpoint", "vmms-endpoint")
+	addDeprecatedEndpointFlag(cmd, "credential", "vmms-credential")
 
 	return cmd
 }
@@ -48,6 +48,7 @@ func NewVmmsInstanceCreateCommand() *cobra.Command {
 	flags.StringP("machine-image-name", "", "", "Machine Image Name")
 	flags.StringP("project", "", "", "Project")
 	flags.StringP("zone", "", "", "Zone")
+	flags.StringP("idempotency-key", "", "", "同じキーで作成済みの場合は新しく作成しない (空の場合は生成する)")
 
 	cmd.MarkFlagRequired("problem-id")
 	cmd.MarkFlagRequired("machine-image-name")
@@ -60,11 +61,7 @@ func NewVmmsInstanceCreateCommand() *cobra.Command {
 func vmmsInstanceCreateCommandFunc(cmd *cobra.Command, args []string) error {
 	flags := cmd.Flags()
 
-	endpoint, err := flags.GetString("endpoint")
-	if err != nil {
-		return err
-	}
-	credential, err := flags.GetString("credential")
+	profile, err := resolveProfile(cmd, vmmsEndpointFlags, nil)
 	if err != nil {
 		return err
 	}
@@ -84,17 +81,34 @@ func vmmsInstanceCreateCommandFunc(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-
-	cli := vmms.NewClient(endpoint, credential)
-	pes, err := cli.CreateInstance(problemID, machineImageName, project, zone)
+	idempotencyKey, err := flags.GetString("idempotency-key")
 	if err != nil {
 		return err
 	}
+	if idempotencyKey == "" {
+		idempotencyKey = vmms.NewIdempotencyKey()
+	}
 
-	b, err := json.Marshal(pes)
-	fmt.Println(string(b))
-
-	return nil
+	cli := vmms.NewClient(profile.VmmsEndpoint, profile.VmmsCredential)
+	instance, err := cli.CreateInstance(idempotencyKey, problemID, machineImageName, project, zone)
+	if err != nil {
+		return err
+	}
+	if !showSecrets(cmd) {
+		*instance = instance.Redacted()
+	}
+
+	return printOutput(cmd, instance, func(out io.Writer, wide bool) error {
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		if wide {
+			fmt.Fprintln(w, "INSTANCE_NAME\tMACHINE_IMAGE_NAME\tSTATUS\tDOMAIN\tUSER_ID\tPROBLEM_ID")
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", instance.InstanceName, instance.MachineImageName, instance.Status, instance.Domain, instance.UserID, instance.ProblemID)
+		} else {
+			fmt.Fprintln(w, "INSTANCE_NAME\tMACHINE_IMAGE_NAME\tSTATUS\tDOMAIN")
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", instance.InstanceName, instance.MachineImageName, instance.Status, instance.Domain)
+		}
+		return w.Flush()
+	})
 }
 
 func NewVmmsInstanceDeleteCommand() *cobra.Command {
@@ -118,11 +132,7 @@ func NewVmmsInstanceDeleteCommand() *cobra.Command {
 func vmmsInstanceDeleteCommandFunc(cmd *cobra.Command, args []string) error {
 	flags := cmd.Flags()
 
-	endpoint, err := flags.GetString("endpoint")
-	if err != nil {
-		return err
-	}
-	credential, err := flags.GetString("credential")
+	profile, err := resolveProfile(cmd, vmmsEndpointFlags, nil)
 	if err != nil {
 		return err
 	}
@@ -139,12 +149,21 @@ func vmmsInstanceDeleteCommandFunc(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	cli := vmms.NewClient(endpoint, credential)
+	cli := vmms.NewClient(profile.VmmsEndpoint, profile.VmmsCredential)
 	if err := cli.DeleteInstance(instanceName, project, zone); err != nil {
 		return err
 	}
 
-	fmt.Printf("[INFO] Deleted successfully: %s\n", instanceName)
+	result := struct {
+		InstanceName string `json:"instance_name"`
+		Deleted      bool   `json:"deleted"`
+	}{
+		InstanceName: instanceName,
+		Deleted:      true,
+	}
 
-	return nil
+	return printOutput(cmd, &result, func(out io.Writer, wide bool) error {
+		_, err := fmt.Fprintf(out, "[INFO] Deleted successfully: %s\n", instanceName)
+		return err
+	})
 }
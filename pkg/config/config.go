@@ -0,0 +1,173 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// FormatContestDefinition コンテスト定義ファイル
+	FormatContestDefinition = "contest-definition"
+	// FormatSchedulerConfig (旧形式) schedulerの設定ファイル
+	FormatSchedulerConfig = "scheduler-config"
+	// FormatMapping (旧形式) contest init のマッピングファイル
+	FormatMapping = "mapping"
+)
+
+// DetectFormat 設定ファイルの形式を判定する
+// - トップレベルがlistの場合はマッピングファイル
+// - トップレベルに setting がある場合はschedulerの設定ファイル
+// - それ以外はコンテスト定義ファイル
+func DetectFormat(bytes []byte) (string, error) {
+	var list []interface{}
+	if err := yaml.Unmarshal(bytes, &list); err == nil && len(list) > 0 {
+		return FormatMapping, nil
+	}
+
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(bytes, &m); err != nil {
+		return "", xerrors.Errorf("yaml unmarshal error: %w", err)
+	}
+	if _, ok := m["setting"]; ok {
+		return FormatSchedulerConfig, nil
+	}
+
+	return FormatContestDefinition, nil
+}
+
+// Load 設定ファイルを読み込み ContestDefinition として返す
+// 旧形式のschedulerの設定ファイル、マッピングファイルも読み込める
+func Load(path string) (*types.ContestDefinition, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := DetectFormat(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatSchedulerConfig:
+		cfg := types.SchedulerConfig{}
+		if err := yaml.Unmarshal(bytes, &cfg); err != nil {
+			return nil, err
+		}
+		return Migrate(&cfg, nil), nil
+	case FormatMapping:
+		ml := []types.Mapping{}
+		if err := yaml.Unmarshal(bytes, &ml); err != nil {
+			return nil, err
+		}
+		return Migrate(nil, ml), nil
+	}
+
+	def := types.ContestDefinition{}
+	if err := yaml.UnmarshalStrict(bytes, &def); err != nil {
+		return nil, err
+	}
+	if def.Version > types.ContestDefinitionVersion {
+		return nil, xerrors.New(fmt.Sprintf("unsupported contest definition version: %d", def.Version))
+	}
+
+	return &def, nil
+}
+
+// LoadSchedulerConfig schedulerの設定を読み込む
+// コンテスト定義ファイルと旧形式のschedulerの設定ファイルのどちらも読み込める
+func LoadSchedulerConfig(path string) (*types.SchedulerConfig, error) {
+	def, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return def.SchedulerConfig(), nil
+}
+
+// LoadMappings contest init で使用するマッピング情報を読み込む
+// コンテスト定義ファイルと旧形式のマッピングファイルのどちらも読み込める
+func LoadMappings(path string) ([]types.Mapping, *types.ContestDefinition, error) {
+	def, err := Load(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return def.Mappings(), def, nil
+}
+
+// Migrate 旧形式のschedulerの設定ファイルとマッピングファイルから ContestDefinition を生成する
+// マッピングの problem_id(なければ machine_image_name) が一致する問題に placements として追加する
+// 一致する問題がない場合は問題として追加し、pool_count は contest init で作成する台数の合計にする
+// (pool_count を0にすると、contest init で作成したインスタンスをschedulerが削除してしまうため)
+func Migrate(cfg *types.SchedulerConfig, ml []types.Mapping) *types.ContestDefinition {
+	def := &types.ContestDefinition{
+		Version: types.ContestDefinitionVersion,
+	}
+	if cfg != nil {
+		def.Setting = cfg.Setting
+	}
+
+	// マッピングにだけ存在する問題 (def.Problems のindex)
+	mappingOnly := map[int]bool{}
+
+	for _, m := range ml {
+		placement := types.Placement{
+			Project: m.Project,
+			Zone:    m.Zone,
+			Count:   m.Count,
+		}
+		// count が書かれていない場合は contest init の --count のデフォルト値(1台)として数える
+		count := m.Count
+		if count <= 0 {
+			count = 1
+		}
+
+		found := false
+		for i := range def.Problems {
+			p := &def.Problems[i]
+			if p.ProblemID == m.ProblemID || (m.ProblemID == "" && p.MachineImageName == m.MachineImageName) {
+				p.Placements = append(p.Placements, placement)
+				if mappingOnly[i] {
+					p.PoolCount += count
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			mappingOnly[len(def.Problems)] = true
+			def.Problems = append(def.Problems, types.ProblemSetting{
+				MachineImageName: m.MachineImageName,
+				ProblemID:        m.ProblemID,
+				PoolCount:        count,
+				Placements:       []types.Placement{placement},
+			})
+		}
+	}
+
+	return def
+}
+
+// Validate ContestDefinition の内容に矛盾がないかを確認し、警告の一覧を返す
+func Validate(def *types.ContestDefinition) []string {
+	warnings := []string{}
+
+	seen := map[string]string{}
+	for _, p := range def.Problems {
+		if p.ProblemID == "" {
+			warnings = append(warnings, fmt.Sprintf("problem %s: problem_id is empty", p.MachineImageName))
+		}
+		if p.MachineImageName == "" {
+			warnings = append(warnings, fmt.Sprintf("problem %s: machine_image_name is empty", p.ProblemID))
+		}
+		if id, ok := seen[p.MachineImageName]; ok {
+			warnings = append(warnings, fmt.Sprintf("problem %s: machine_image_name is duplicated (problem_id: %s, %s)", p.MachineImageName, id, p.ProblemID))
+		}
+		seen[p.MachineImageName] = p.ProblemID
+	}
+
+	return warnings
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+)
+
+func Test_DetectFormat(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"- problem_id: a\n  machine_image_name: image-a\n", FormatMapping},
+		{"setting:\n  cron: '@every 2s'\n", FormatSchedulerConfig},
+		{"version: 1\ncron: '@every 2s'\n", FormatContestDefinition},
+	}
+
+	for _, tt := range tests {
+		got, err := DetectFormat([]byte(tt.in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("DetectFormat(%q): got %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func Test_Migrate(t *testing.T) {
+	cfg := &types.SchedulerConfig{}
+	cfg.Setting.Cron = "@every 2s"
+	cfg.Setting.Problems = []types.ProblemSetting{
+		{MachineImageName: "image-aki", ProblemID: "aki", PoolCount: 10},
+	}
+	ml := []types.Mapping{
+		{ProblemID: "aki", MachineImageName: "image-aki", Project: "networkcontest", Zone: "asia-northeast1-b"},
+		{ProblemID: "kit", MachineImageName: "image-kit", Project: "networkcontest", Zone: "asia-northeast1-b"},
+		{ProblemID: "kit", MachineImageName: "image-kit", Project: "networkcontest", Zone: "asia-northeast1-c", Count: 3},
+	}
+
+	def := Migrate(cfg, ml)
+
+	if def.Cron != "@every 2s" {
+		t.Errorf("cron: got %s", def.Cron)
+	}
+	if len(def.Problems) != 2 {
+		t.Fatalf("got %d problems, want 2", len(def.Problems))
+	}
+	if def.Problems[0].PoolCount != 10 || len(def.Problems[0].Placements) != 1 {
+		t.Errorf("image-aki: %#v", def.Problems[0])
+	}
+	// マッピングにだけある問題は contest init で作成する台数(count なしは1台)を pool_count にする
+	if def.Problems[1].PoolCount != 4 || len(def.Problems[1].Placements) != 2 || def.Problems[1].MachineImageName != "image-kit" {
+		t.Errorf("image-kit: %#v", def.Problems[1])
+	}
+
+	// マッピングに戻すと元のマッピングと同じになる
+	got := def.Mappings()
+	if len(got) != len(ml) {
+		t.Fatalf("got %d mappings, want %d", len(got), len(ml))
+	}
+	for i := range ml {
+		if got[i] != ml[i] {
+			t.Errorf("mapping %d: got %#v, want %#v", i, got[i], ml[i])
+		}
+	}
+}
+
+func Test_Scaffold(t *testing.T) {
+	problems := []types.Problem{
+		{ID: "id-aki", Code: "AKI"},
+		{ID: "id-kit", Code: "kit"},
+		{ID: "id-xyz", Code: "xyz"},
+	}
+
+	result := Scaffold(problems, ScaffoldOptions{
+		Images:    []string{"image-aki", "image-kit-v2", "image-old"},
+		ImageMap:  map[string]string{"kit": "image-kit-v2"},
+		PoolCount: 5,
+	})
+
+	// Machine Imageが見つからなかった問題は出力しない
+	want := []string{"image-aki", "image-kit-v2"}
+	if len(result.Definition.Problems) != len(want) {
+		t.Fatalf("got %d problems, want %d", len(result.Definition.Problems), len(want))
+	}
+	for i, p := range result.Definition.Problems {
+		if p.MachineImageName != want[i] || p.PoolCount != 5 {
+			t.Errorf("problem %d: got %#v", i, p)
+		}
+	}
+	if len(result.ProblemsWithoutImage) != 1 || result.ProblemsWithoutImage[0].ID != "id-xyz" {
+		t.Errorf("problems without image: %#v", result.ProblemsWithoutImage)
+	}
+	if len(result.ImagesWithoutProblem) != 1 || result.ImagesWithoutProblem[0] != "image-old" {
+		t.Errorf("images without problem: %#v", result.ImagesWithoutProblem)
+	}
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// EnvConfig プロファイルの設定ファイルのパス
+	EnvConfig = "NETCON_CONFIG"
+	// EnvProfile 使用するプロファイル名
+	EnvProfile = "NETCON_PROFILE"
+	// EnvScoreserverEndpoint スコアサーバのEndpoint
+	EnvScoreserverEndpoint = "NETCON_SCORESERVER_ENDPOINT"
+	// EnvVmmsEndpoint vm-management-serverのEndpoint
+	EnvVmmsEndpoint = "NETCON_VMMS_ENDPOINT"
+	// EnvVmmsCredential vm-management-serverのcredential
+	EnvVmmsCredential = "NETCON_VMMS_CREDENTIAL"
+	// EnvVmmsCredentialFile vm-management-serverのcredentialが書かれたファイル
+	EnvVmmsCredentialFile = "NETCON_VMMS_CREDENTIAL_FILE"
+)
+
+// Profile 接続先と認証情報の組
+// 空のフィールドは設定されていないものとして扱う
+type Profile struct {
+	ScoreserverEndpoint string `yaml:"scoreserver_endpoint"`
+	VmmsEndpoint        string `yaml:"vmms_endpoint"`
+	VmmsCredential      string `yaml:"vmms_credential"`
+	// vmms_credential が空の場合はこのファイルの内容をcredentialとして使う
+	VmmsCredentialFile string `yaml:"vmms_credential_file"`
+}
+
+// ProfileConfig ~/.config/netcon/config.yaml の内容
+type ProfileConfig struct {
+	// --profile, NETCON_PROFILE が指定されていない場合に使用するプロファイル
+	CurrentProfile string             `yaml:"current_profile"`
+	Profiles       map[string]Profile `yaml:"profiles"`
+}
+
+// DefaultProfileConfigPath プロファイルの設定ファイルのパスを返す
+// NETCON_CONFIG が設定されていればその値、なければ $XDG_CONFIG_HOME/netcon/config.yaml (未設定の場合は ~/.config/netcon/config.yaml)
+func DefaultProfileConfigPath() string {
+	if path := os.Getenv(EnvConfig); path != "" {
+		return path
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "netcon", "config.yaml")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "netcon", "config.yaml")
+}
+
+// LoadProfileConfig プロファイルの設定ファイルを読み込む
+// ファイルが存在しない場合は空の ProfileConfig を返す
+func LoadProfileConfig(path string) (*ProfileConfig, error) {
+	pc := &ProfileConfig{}
+	if path == "" {
+		return pc, nil
+	}
+
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return pc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.UnmarshalStrict(bytes, pc); err != nil {
+		return nil, xerrors.Errorf("%s: yaml unmarshal error: %w", path, err)
+	}
+
+	return pc, nil
+}
+
+// Profile name のプロファイルを返す
+// name が空の場合は current_profile を使い、それも空の場合は空の Profile を返す
+func (pc *ProfileConfig) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = pc.CurrentProfile
+	}
+	if name == "" {
+		return Profile{}, nil
+	}
+
+	p, ok := pc.Profiles[name]
+	if !ok {
+		names := []string{}
+		for n := range pc.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Profile{}, xerrors.New(fmt.Sprintf("profile %q is not found (available: %s)", name, strings.Join(names, ", ")))
+	}
+
+	return p, nil
+}
+
+// ProfileFromEnv NETCON_* 環境変数から Profile を生成する
+func ProfileFromEnv() Profile {
+	return Profile{
+		ScoreserverEndpoint: os.Getenv(EnvScoreserverEndpoint),
+		VmmsEndpoint:        os.Getenv(EnvVmmsEndpoint),
+		VmmsCredential:      os.Getenv(EnvVmmsCredential),
+		VmmsCredentialFile:  os.Getenv(EnvVmmsCredentialFile),
+	}
+}
+
+// ProfileFromSetting コンテスト定義ファイル、schedulerの設定ファイルの接続先から Profile を生成する
+func ProfileFromSetting(s *types.Setting) Profile {
+	return Profile{
+		ScoreserverEndpoint: s.Scoreserver.Endpoint,
+		VmmsEndpoint:        s.Vmms.Endpoint,
+		VmmsCredential:      s.Vmms.Credential,
+		VmmsCredentialFile:  s.Vmms.CredentialFile,
+	}
+}
+
+// Merge p に o の空でないフィールドを上書きした Profile を返す
+// credential とcredentialのファイルは組として扱い、o にどちらかがあれば両方を o の値にする
+func (p Profile) Merge(o Profile) Profile {
+	if o.ScoreserverEndpoint != "" {
+		p.ScoreserverEndpoint = o.ScoreserverEndpoint
+	}
+	if o.VmmsEndpoint != "" {
+		p.VmmsEndpoint = o.VmmsEndpoint
+	}
+	if o.VmmsCredential != "" || o.VmmsCredentialFile != "" {
+		p.VmmsCredential = o.VmmsCredential
+		p.VmmsCredentialFile = o.VmmsCredentialFile
+	}
+	return p
+}
+
+// Credential vm-management-serverのcredentialを返す
+// vmms_credential が空の場合は vmms_credential_file を読み込み、前後の空白と改行を取り除いた値を返す
+func (p Profile) Credential() (string, error) {
+	if p.VmmsCredential != "" || p.VmmsCredentialFile == "" {
+		return p.VmmsCredential, nil
+	}
+
+	path := p.VmmsCredentialFile
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, path[2:])
+	}
+
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", xerrors.Errorf("failed to read credential file: %w", err)
+	}
+
+	return strings.TrimSpace(string(bytes)), nil
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Profile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netcon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	credentialFile := filepath.Join(dir, "credential")
+	if err := ioutil.WriteFile(credentialFile, []byte("secret-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	configFile := filepath.Join(dir, "config.yaml")
+	content := `current_profile: dev
+profiles:
+  dev:
+    scoreserver_endpoint: http://127.0.0.1:8905
+    vmms_endpoint: http://127.0.0.1:8950
+    vmms_credential: dev-token
+  contest:
+    scoreserver_endpoint: https://vmdb.example.com
+    vmms_endpoint: https://vmms.example.com
+    vmms_credential_file: ` + credentialFile + "\n"
+	if err := ioutil.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := LoadProfileConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 名前を指定しない場合は current_profile
+	p, err := pc.Profile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.VmmsCredential != "dev-token" {
+		t.Errorf("Profile(\"\").VmmsCredential = %q, want dev-token", p.VmmsCredential)
+	}
+
+	p, err = pc.Profile("contest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := p.Credential()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential != "secret-token" {
+		t.Errorf("Credential() = %q, want secret-token", credential)
+	}
+
+	// 上書きする側にcredentialがあればcredentialのファイルは使わない
+	merged := p.Merge(Profile{VmmsEndpoint: "http://localhost:18950", VmmsCredential: "flag-token"})
+	if merged.ScoreserverEndpoint != "https://vmdb.example.com" || merged.VmmsEndpoint != "http://localhost:18950" {
+		t.Errorf("Merge() = %#v", merged)
+	}
+	if credential, _ := merged.Credential(); credential != "flag-token" {
+		t.Errorf("Merge().Credential() = %q, want flag-token", credential)
+	}
+
+	if _, err := pc.Profile("staging"); err == nil {
+		t.Errorf("Profile(\"staging\") should return an error")
+	}
+
+	// ファイルがない場合は空の設定
+	pc, err = LoadProfileConfig(filepath.Join(dir, "not-exist.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := pc.Profile(""); err != nil || p != (Profile{}) {
+		t.Errorf("Profile(\"\") = %#v, %v, want empty profile", p, err)
+	}
+}
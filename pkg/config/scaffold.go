@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+)
+
+// DefaultImageNameFormat 問題のcodeからMachine Imageの名前を決めるときのデフォルトの命名規則
+const DefaultImageNameFormat = "image-%s"
+
+// ScaffoldOptions Scaffold の設定
+type ScaffoldOptions struct {
+	// 問題のcode(小文字)からMachine Imageの名前を作る書式
+	ImageNameFormat string
+	// 問題のIDまたはcodeとMachine Imageの名前の対応 (命名規則より優先する)
+	ImageMap map[string]string
+	// 存在するMachine Imageの名前 (空の場合は命名規則で作った名前をそのまま使う)
+	Images []string
+	// 問題ごとの pool_count
+	PoolCount int
+	// Zoneごとの max_instance
+	MaxInstance int
+	// contest init でインスタンスを作成する場所 (空の場合は placements を設定しない)
+	Project string
+	Zone    string
+}
+
+// ScaffoldResult Scaffold の結果
+type ScaffoldResult struct {
+	Definition *types.ContestDefinition
+	// Machine Imageが見つからなかった問題
+	ProblemsWithoutImage []types.Problem
+	// どの問題にも対応しなかったMachine Image
+	ImagesWithoutProblem []string
+}
+
+// Scaffold スコアサーバの問題一覧とMachine Imageの名前を突き合わせて ContestDefinition の雛形を作る
+// Machine Imageが見つからなかった問題は出力せず、ProblemsWithoutImage に入れる
+func Scaffold(problems []types.Problem, opts ScaffoldOptions) *ScaffoldResult {
+	format := opts.ImageNameFormat
+	if format == "" {
+		format = DefaultImageNameFormat
+	}
+
+	images := map[string]bool{}
+	for _, image := range opts.Images {
+		images[image] = false
+	}
+
+	result := &ScaffoldResult{
+		Definition: &types.ContestDefinition{
+			Version: types.ContestDefinitionVersion,
+		},
+		ProblemsWithoutImage: []types.Problem{},
+		ImagesWithoutProblem: []string{},
+	}
+
+	if opts.Project != "" && opts.Zone != "" {
+		result.Definition.Projects = []types.ProjectSetting{
+			{Name: opts.Project, Zones: []types.ZoneSetting{{Name: opts.Zone, MaxInstance: opts.MaxInstance}}},
+		}
+	}
+
+	for _, p := range problems {
+		image, ok := opts.ImageMap[p.ID]
+		if !ok {
+			image, ok = opts.ImageMap[p.Code]
+		}
+		if !ok && p.Code != "" {
+			image = fmt.Sprintf(format, strings.ToLower(p.Code))
+			ok = len(opts.Images) == 0
+			if _, exists := images[image]; exists {
+				ok = true
+			}
+		}
+
+		if !ok {
+			// machine_image_name が空のまま pool_count を設定すると、schedulerが作成に失敗し続けるため出力しない
+			result.ProblemsWithoutImage = append(result.ProblemsWithoutImage, p)
+			continue
+		}
+		images[image] = true
+
+		problem := types.ProblemSetting{
+			MachineImageName: image,
+			PoolCount:        opts.PoolCount,
+			ProblemID:        p.ID,
+		}
+		if opts.Project != "" && opts.Zone != "" {
+			problem.Placements = []types.Placement{{Project: opts.Project, Zone: opts.Zone}}
+		}
+		result.Definition.Problems = append(result.Definition.Problems, problem)
+	}
+
+	for image, used := range images {
+		if !used {
+			result.ImagesWithoutProblem = append(result.ImagesWithoutProblem, image)
+		}
+	}
+	sort.Strings(result.ImagesWithoutProblem)
+
+	return result
+}
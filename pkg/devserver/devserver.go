@@ -0,0 +1,351 @@
+package devserver
+
+/*
+開発用のvm-management-serverとスコアサーバ(vmdb-api)の代わり
+
+vm-management-server:
+	POST   /instance
+	DELETE /instance/:name
+
+vmdb-api:
+	GET /problem-environments
+	GET /problem-environments/:name
+	GET /problems
+
+VMは実際には作成せず、メモリ上で状態だけを管理する
+*/
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+)
+
+// Config 開発用サーバの振る舞い
+type Config struct {
+	// VMの作成を受け付けてからREADYになるまでの時間
+	BootDelay time.Duration
+	// BootDelay に加える揺らぎの最大値
+	BootJitter time.Duration
+	// VMの作成・削除を失敗させる確率 (0 - 1)
+	CreateFailureRate float64
+	DeleteFailureRate float64
+	// VMを作成したうえで、タイムアウトしたように504を返す確率 (0 - 1)
+	CreateTimeoutRate float64
+	// "project/zone" ごとのVM数の上限 (含まれないZoneは Quota を使う)
+	ZoneQuotas map[string]int
+	// ZoneQuotas に含まれないZoneのVM数の上限 (0の場合は無制限)
+	Quota int
+	// 参加者がREADYなVMを割り当てられる間隔 (0の場合は割り当てない)
+	AssignInterval time.Duration
+	// 割り当てられてから採点を依頼するまでの時間
+	SolveTime time.Duration
+	// 採点を依頼してからABANDONEDになるまでの時間
+	ScoringTime time.Duration
+	// vm-management-serverへのリクエストに必要なcredential (空の場合は確認しない)
+	Credential string
+	// GET /problems で返す問題
+	Problems []types.Problem
+	Seed     int64
+}
+
+// NewConfig 設定ファイルの projects と problems から Config を作る
+// 上限は max_instance、問題は problem_id と machine_image_name を使う
+func NewConfig(cfg *types.SchedulerConfig) *Config {
+	c := &Config{
+		ZoneQuotas: map[string]int{},
+		Problems:   []types.Problem{},
+	}
+	for _, p := range cfg.Setting.Projects {
+		for _, z := range p.Zones {
+			c.ZoneQuotas[p.Name+"/"+z.Name] = z.MaxInstance
+		}
+	}
+	for _, p := range cfg.Setting.Problems {
+		c.Problems = append(c.Problems, types.Problem{ID: p.ProblemID, Code: p.MachineImageName, Title: p.MachineImageName})
+	}
+	return c
+}
+
+type instance struct {
+	ids              map[string]uuid.UUID
+	name             string
+	machineImageName string
+	problemID        string
+	project          string
+	zone             string
+	password         string
+	innerStatus      string
+	createdAt        time.Time
+	updatedAt        time.Time
+	// 次の状態に遷移する時刻
+	nextAt time.Time
+}
+
+// Server 開発用のvm-management-serverとvmdb-api
+type Server struct {
+	cfg *Config
+	// 現在時刻 (testで差し替える)
+	now func() time.Time
+
+	mu        sync.Mutex
+	rand      *rand.Rand
+	instances map[string]*instance
+	// 冪等キーごとに作成したVM名
+	keys       map[string]string
+	nextAssign time.Time
+}
+
+// NewServer Serverを返す
+func NewServer(cfg *Config) *Server {
+	return &Server{
+		cfg:       cfg,
+		now:       time.Now,
+		rand:      rand.New(rand.NewSource(cfg.Seed)),
+		instances: map[string]*instance{},
+		keys:      map[string]string{},
+	}
+}
+
+// services VMごとに返すサービス (スコアサーバはサービスごとに ProblemEnvironment を返す)
+var services = []struct {
+	service string
+	port    int
+}{
+	{service: "SSH", port: 50080},
+	{service: "HTTPS", port: 443},
+}
+
+// CreateInstance VMを作成し、NOT_READYの状態で登録する
+// 同じ idempotencyKey で作成済みのVMが残っている場合は、新しく作成せずにそのVMを返す
+func (s *Server) CreateInstance(idempotencyKey, problemID, machineImageName, project, zone string) (*types.Instance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.advance(now)
+
+	if name, ok := s.keys[idempotencyKey]; ok {
+		if i, ok := s.instances[name]; ok {
+			return i.toInstance(), nil
+		}
+	}
+
+	if s.rand.Float64() < s.cfg.CreateFailureRate {
+		return nil, &Error{Code: 500, Name: "INJECTED_FAILURE", Description: "failure injected by netcon dev serve"}
+	}
+	if quota := s.quota(project, zone); quota > 0 && s.countZone(project, zone) >= quota {
+		return nil, &Error{Code: 500, Name: "QUOTA_EXCEEDED", Description: fmt.Sprintf("quota exceeded: %s/%s (%d)", project, zone, quota)}
+	}
+
+	i := &instance{
+		ids:              map[string]uuid.UUID{},
+		name:             s.instanceName(machineImageName),
+		machineImageName: machineImageName,
+		problemID:        problemID,
+		project:          project,
+		zone:             zone,
+		password:         s.randomString(8),
+		innerStatus:      types.ProblemEnvironmentInnerStatusNotReady,
+		createdAt:        now,
+		updatedAt:        now,
+		nextAt:           now.Add(s.bootDelay()),
+	}
+	for _, svc := range services {
+		i.ids[svc.service] = uuid.Must(uuid.NewV4())
+	}
+	s.instances[i.name] = i
+	if idempotencyKey != "" {
+		s.keys[idempotencyKey] = i.name
+	}
+
+	if s.rand.Float64() < s.cfg.CreateTimeoutRate {
+		return nil, &Error{Code: 504, Name: "GATEWAY_TIMEOUT", Description: "timeout injected by netcon dev serve (instance was created)"}
+	}
+
+	return i.toInstance(), nil
+}
+
+func (i *instance) toInstance() *types.Instance {
+	return &types.Instance{
+		InstanceName:     i.name,
+		MachineImageName: i.machineImageName,
+		Domain:           i.name + ".dev.local",
+		Status:           "RUNNING",
+		ProblemID:        i.problemID,
+		UserID:           "netcon",
+		Password:         i.password,
+	}
+}
+
+// DeleteInstance VMを削除する
+func (s *Server) DeleteInstance(name, project, zone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.advance(s.now())
+
+	i, ok := s.instances[name]
+	if !ok || i.project != project || i.zone != zone {
+		return &Error{Code: 404, Name: "NOT_FOUND", Description: "instance not found: " + name}
+	}
+	if s.rand.Float64() < s.cfg.DeleteFailureRate {
+		return &Error{Code: 500, Name: "INJECTED_FAILURE", Description: "failure injected by netcon dev serve"}
+	}
+
+	delete(s.instances, name)
+	return nil
+}
+
+// ProblemEnvironments 登録されているVMを ProblemEnvironment の一覧で返す
+// name が空でない場合はそのVMのみを返す
+// since がゼロ値でない場合は since より後に更新されたVMのみを返す
+func (s *Server) ProblemEnvironments(name string, since time.Time) []types.ProblemEnvironment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.advance(s.now())
+
+	names := []string{}
+	for n := range s.instances {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	problemEnvironments := []types.ProblemEnvironment{}
+	for _, n := range names {
+		i := s.instances[n]
+		if name != "" && n != name {
+			continue
+		}
+		if !since.IsZero() && !i.updatedAt.After(since) {
+			continue
+		}
+		for _, svc := range services {
+			innerStatus := i.innerStatus
+			machineImageName := i.machineImageName
+			problemEnvironments = append(problemEnvironments, types.ProblemEnvironment{
+				ID:               i.ids[svc.service],
+				InnerStatus:      &innerStatus,
+				Host:             "127.0.0.1",
+				User:             "netcon",
+				Password:         i.password,
+				ProblemID:        i.problemID,
+				CreatedAt:        i.createdAt,
+				UpdatedAt:        i.updatedAt,
+				ProjectName:      i.project,
+				ZoneName:         i.zone,
+				Name:             i.name,
+				Service:          svc.service,
+				Port:             svc.port,
+				MachineImageName: &machineImageName,
+			})
+		}
+	}
+	return problemEnvironments
+}
+
+// advance now までに起きる状態の遷移と参加者への割り当てを行う
+func (s *Server) advance(now time.Time) {
+	for _, i := range s.instances {
+		// 次の状態に遷移する時刻が過ぎていれば、順番に遷移させる
+		for !i.nextAt.IsZero() && !i.nextAt.After(now) {
+			at := i.nextAt
+			switch i.innerStatus {
+			case types.ProblemEnvironmentInnerStatusNotReady:
+				transition(i, types.ProblemEnvironmentInnerStatusReady, at, time.Time{})
+			case types.ProblemEnvironmentInnerStatusUnderChallenge:
+				transition(i, types.ProblemEnvironmentInnerStatusUnderScoring, at, at.Add(s.cfg.ScoringTime))
+			case types.ProblemEnvironmentInnerStatusUnderScoring:
+				transition(i, types.ProblemEnvironmentInnerStatusAbandoned, at, time.Time{})
+			default:
+				i.nextAt = time.Time{}
+			}
+		}
+	}
+
+	if s.cfg.AssignInterval <= 0 {
+		return
+	}
+	if s.nextAssign.IsZero() {
+		s.nextAssign = now.Add(s.cfg.AssignInterval)
+		return
+	}
+	for ; !s.nextAssign.After(now); s.nextAssign = s.nextAssign.Add(s.cfg.AssignInterval) {
+		s.assign(s.nextAssign)
+	}
+}
+
+// assign at の時点でREADYなVMを1つ選んで参加者に割り当てる
+func (s *Server) assign(at time.Time) {
+	ready := []*instance{}
+	for _, i := range s.instances {
+		if i.innerStatus == types.ProblemEnvironmentInnerStatusReady && !i.updatedAt.After(at) {
+			ready = append(ready, i)
+		}
+	}
+	if len(ready) == 0 {
+		return
+	}
+	// mapの順番に依存しないように並べてから選ぶ
+	sort.Slice(ready, func(a, b int) bool { return ready[a].name < ready[b].name })
+	i := ready[s.rand.Intn(len(ready))]
+	// 遷移先の時刻が既に過ぎている場合は次の advance で遷移する
+	transition(i, types.ProblemEnvironmentInnerStatusUnderChallenge, at, at.Add(s.cfg.SolveTime))
+}
+
+// transition 状態を innerStatus にし、nextAt に次の状態に遷移させる (ゼロ値の場合は遷移しない)
+func transition(i *instance, innerStatus string, at, nextAt time.Time) {
+	i.innerStatus = innerStatus
+	i.updatedAt = at
+	i.nextAt = nextAt
+}
+
+func (s *Server) quota(project, zone string) int {
+	if q, ok := s.cfg.ZoneQuotas[project+"/"+zone]; ok {
+		return q
+	}
+	return s.cfg.Quota
+}
+
+func (s *Server) countZone(project, zone string) int {
+	count := 0
+	for _, i := range s.instances {
+		if i.project == project && i.zone == zone {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *Server) bootDelay() time.Duration {
+	d := s.cfg.BootDelay
+	if s.cfg.BootJitter > 0 {
+		d += time.Duration(s.rand.Int63n(int64(s.cfg.BootJitter)))
+	}
+	return d
+}
+
+// instanceName vm-management-serverと同じく <machine_image_name>-<5文字> の名前を付ける
+func (s *Server) instanceName(machineImageName string) string {
+	for {
+		name := machineImageName + "-" + s.randomString(5)
+		if _, ok := s.instances[name]; !ok {
+			return name
+		}
+	}
+}
+
+func (s *Server) randomString(n int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letters[s.rand.Intn(len(letters))]
+	}
+	return string(b)
+}
@@ -0,0 +1,151 @@
+package devserver
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/scheduler"
+	"github.com/janog-netcon/netcon-cli/pkg/scoreserver"
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"github.com/janog-netcon/netcon-cli/pkg/vmms"
+	"go.uber.org/zap"
+)
+
+const testProblemID = "227803fb-2fe1-4b89-a805-79e7679bf030"
+
+// newTestServer 時刻を進められるServerと、それに接続するクライアントを返す
+func newTestServer(t *testing.T, cfg *Config) (*Server, *time.Time, *scoreserver.Client, *vmms.Client) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	srv := NewServer(cfg)
+	srv.now = func() time.Time { return now }
+
+	ss := httptest.NewServer(srv.ScoreserverHandler())
+	t.Cleanup(ss.Close)
+	vm := httptest.NewServer(srv.VmmsHandler())
+	t.Cleanup(vm.Close)
+
+	return srv, &now, scoreserver.NewClient(ss.URL), vmms.NewClient(vm.URL, cfg.Credential)
+}
+
+func innerStatus(t *testing.T, ss *scoreserver.Client, name string) string {
+	pes, err := ss.GetProblemEnvironment(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*pes) != 2 {
+		t.Fatalf("GetProblemEnvironment(%s) returned %d entries, want 2 (SSH, HTTPS)", name, len(*pes))
+	}
+	return *(*pes)[0].InnerStatus
+}
+
+func Test_Lifecycle(t *testing.T) {
+	cfg := &Config{
+		BootDelay:      25 * time.Second,
+		AssignInterval: 10 * time.Second,
+		SolveTime:      time.Minute,
+		ScoringTime:    time.Minute,
+		Credential:     "secret",
+	}
+	_, now, ss, vm := newTestServer(t, cfg)
+
+	if _, err := vmms.NewClient(vm.Endpoint, "wrong").CreateInstance("", testProblemID, "image-sc0", "networkcontest", "asia-northeast1-b"); err == nil {
+		t.Errorf("CreateInstance() with wrong credential should fail")
+	}
+
+	instance, err := vm.CreateInstance("key-1", testProblemID, "image-sc0", "networkcontest", "asia-northeast1-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 同じ冪等キーでは新しく作成しない
+	retried, err := vm.CreateInstance("key-1", testProblemID, "image-sc0", "networkcontest", "asia-northeast1-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried.InstanceName != instance.InstanceName {
+		t.Errorf("CreateInstance() with same key = %s, want %s", retried.InstanceName, instance.InstanceName)
+	}
+
+	steps := []struct {
+		after time.Duration
+		want  string
+	}{
+		{0, types.ProblemEnvironmentInnerStatusNotReady},
+		// 10秒後、20秒後の割り当ての時点ではまだREADYではない
+		{25 * time.Second, types.ProblemEnvironmentInnerStatusReady},
+		// 30秒後に割り当てられる
+		{10 * time.Second, types.ProblemEnvironmentInnerStatusUnderChallenge},
+		{time.Minute, types.ProblemEnvironmentInnerStatusUnderScoring},
+		{time.Minute, types.ProblemEnvironmentInnerStatusAbandoned},
+	}
+	for _, step := range steps {
+		*now = now.Add(step.after)
+		if got := innerStatus(t, ss, instance.InstanceName); got != step.want {
+			t.Errorf("after %s: inner_status = %s, want %s", step.after, got, step.want)
+		}
+	}
+
+	if err := vm.DeleteInstance(instance.InstanceName, "networkcontest", "asia-northeast1-b"); err != nil {
+		t.Fatal(err)
+	}
+	pes, err := ss.ListProblemEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*pes) != 0 {
+		t.Errorf("ListProblemEnvironment() = %d entries after delete, want 0", len(*pes))
+	}
+}
+
+func Test_QuotaAndFailure(t *testing.T) {
+	cfg := &Config{ZoneQuotas: map[string]int{"networkcontest/asia-northeast1-b": 1}}
+	srv, _, _, vm := newTestServer(t, cfg)
+
+	if _, err := vm.CreateInstance("", testProblemID, "image-sc0", "networkcontest", "asia-northeast1-b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.CreateInstance("", testProblemID, "image-sc0", "networkcontest", "asia-northeast1-b"); err == nil {
+		t.Errorf("CreateInstance() over quota should fail")
+	}
+
+	srv.cfg.CreateFailureRate = 1
+	if _, err := vm.CreateInstance("", testProblemID, "image-sc0", "networkcontest", "asia-northeast2-b"); err == nil {
+		t.Errorf("CreateInstance() should fail when create_failure_rate is 1")
+	}
+}
+
+// Test_Scheduler schedulerを実行し、pool_count 分のVMが作成されてREADYになることを確認する
+func Test_Scheduler(t *testing.T) {
+	_, now, ss, vm := newTestServer(t, &Config{BootDelay: 30 * time.Second})
+
+	cfg := &types.SchedulerConfig{}
+	cfg.Setting.Projects = []types.ProjectSetting{
+		{Name: "networkcontest", Zones: []types.ZoneSetting{{Name: "asia-northeast1-b", MaxInstance: 10, Priority: 1}}},
+	}
+	cfg.Setting.Problems = []types.ProblemSetting{
+		{MachineImageName: "image-sc0", ProblemID: testProblemID, PoolCount: 3},
+	}
+
+	st := scheduler.NewState()
+	for i := 0; i < 2; i++ {
+		if err := scheduler.SchedulerReady(cfg, ss, vm, nil, st, zap.NewNop()); err != nil {
+			t.Fatal(err)
+		}
+		*now = now.Add(time.Minute)
+	}
+
+	environments, err := ss.ListProblemEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready := 0
+	for _, e := range types.NewEnvironments(*environments) {
+		if *e.InnerStatus == types.ProblemEnvironmentInnerStatusReady {
+			ready++
+		}
+	}
+	if ready != 3 {
+		t.Errorf("READY instances = %d, want 3", ready)
+	}
+}
@@ -0,0 +1,175 @@
+package devserver
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/vmms"
+)
+
+// Error vm-management-serverのエラーレスポンス
+type Error struct {
+	Code        int    `json:"code"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Name, e.Description)
+}
+
+type createInstanceRequestBody struct {
+	ProblemID        string `json:"problem_id"`
+	MachineImageName string `json:"machine_image_name"`
+	Project          string `json:"project"`
+	Zone             string `json:"zone"`
+}
+
+type deleteInstanceRequestBody struct {
+	Project string `json:"project"`
+	Zone    string `json:"zone"`
+}
+
+// VmmsHandler vm-management-serverのhttp.Handlerを返す
+func (s *Server) VmmsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/instance", s.handleCreateInstance)
+	mux.HandleFunc("/instance/", s.handleDeleteInstance)
+	return mux
+}
+
+// ScoreserverHandler vmdb-apiのhttp.Handlerを返す
+func (s *Server) ScoreserverHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/problem-environments", s.handleListProblemEnvironment)
+	mux.HandleFunc("/problem-environments/", s.handleGetProblemEnvironment)
+	mux.HandleFunc("/problems", s.handleListProblem)
+	return mux
+}
+
+func (s *Server) handleCreateInstance(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeError(w, &Error{Code: http.StatusMethodNotAllowed, Name: "METHOD_NOT_ALLOWED", Description: req.Method})
+		return
+	}
+	if !s.authorized(w, req) {
+		return
+	}
+
+	body := createInstanceRequestBody{}
+	if err := readJSON(req, &body); err != nil || body.ProblemID == "" || body.MachineImageName == "" || body.Project == "" || body.Zone == "" {
+		writeError(w, &Error{Code: http.StatusBadRequest, Name: "BAD_REQUEST", Description: "problem_id, machine_image_name, project and zone are required"})
+		return
+	}
+
+	instance, err := s.CreateInstance(req.Header.Get(vmms.IdempotencyKeyHeader), body.ProblemID, body.MachineImageName, body.Project, body.Zone)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{"response": instance})
+}
+
+func (s *Server) handleDeleteInstance(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodDelete {
+		writeError(w, &Error{Code: http.StatusMethodNotAllowed, Name: "METHOD_NOT_ALLOWED", Description: req.Method})
+		return
+	}
+	if !s.authorized(w, req) {
+		return
+	}
+
+	body := deleteInstanceRequestBody{}
+	if err := readJSON(req, &body); err != nil || body.Project == "" || body.Zone == "" {
+		writeError(w, &Error{Code: http.StatusBadRequest, Name: "BAD_REQUEST", Description: "project and zone are required"})
+		return
+	}
+
+	name := strings.TrimPrefix(req.URL.Path, "/instance/")
+	if err := s.DeleteInstance(name, body.Project, body.Zone); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{"response": map[string]bool{"is_deleted": true}})
+}
+
+func (s *Server) handleListProblemEnvironment(w http.ResponseWriter, req *http.Request) {
+	since := time.Time{}
+	if v := req.URL.Query().Get("updated_since"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+
+	b, err := json.Marshal(s.ProblemEnvironments("", since))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// scoreserver.Client の条件付きリクエストを試せるようにETagを返す
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(b))
+	w.Header().Set("ETag", etag)
+	if req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func (s *Server) handleGetProblemEnvironment(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, "/problem-environments/")
+	problemEnvironments := s.ProblemEnvironments(name, time.Time{})
+	if len(problemEnvironments) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeJSON(w, problemEnvironments)
+}
+
+func (s *Server) handleListProblem(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, s.cfg.Problems)
+}
+
+func (s *Server) authorized(w http.ResponseWriter, req *http.Request) bool {
+	if s.cfg.Credential == "" || req.Header.Get("Authorization") == "Bearer "+s.cfg.Credential {
+		return true
+	}
+	writeError(w, &Error{Code: http.StatusUnauthorized, Name: "UNAUTHORIZED", Description: "invalid credential"})
+	return false
+}
+
+func readJSON(req *http.Request, v interface{}) error {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		e = &Error{Code: http.StatusInternalServerError, Name: "INTERNAL_SERVER_ERROR", Description: err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": e})
+}
@@ -0,0 +1,128 @@
+package leader
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// guardファイルを取得するまで待つ時間
+	guardTimeout = 2 * time.Second
+	// guardファイルを取得できなかったときに、再度試すまで待つ時間
+	guardRetryInterval = 50 * time.Millisecond
+)
+
+// FileLock 1台のホストで複数のschedulerを動かすための、ファイルにリースを保存する Lock
+// リースの読み書きは <path>.lock を排他的に取得できたプロセスだけが行う
+// (Windows以外では flock で、Windowsでは O_EXCL でファイルを作成できるかで排他制御する)
+type FileLock struct {
+	Path string
+}
+
+// NewFileLock path にリースを保存する FileLock を返す
+func NewFileLock(path string) *FileLock {
+	return &FileLock{Path: path}
+}
+
+// TryAcquire holder としてリースを取得・更新する
+func (l *FileLock) TryAcquire(holder string, ttl time.Duration) (bool, *Lease, error) {
+	acquired := false
+	var current *Lease
+
+	err := l.withGuard(func() error {
+		lease, err := l.read()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if lease != nil && lease.Holder != holder && now.Before(lease.ExpiresAt) {
+			current = lease
+			return nil
+		}
+
+		next := &Lease{
+			Holder:     holder,
+			AcquiredAt: now,
+			RenewedAt:  now,
+			ExpiresAt:  now.Add(ttl),
+		}
+		// 自分のリースを更新する場合は取得した時刻を引き継ぐ
+		if lease != nil && lease.Holder == holder && now.Before(lease.ExpiresAt) {
+			next.AcquiredAt = lease.AcquiredAt
+		}
+		if err := l.write(next); err != nil {
+			return err
+		}
+		acquired = true
+		current = next
+		return nil
+	})
+
+	return acquired, current, err
+}
+
+// Release holder が持っているリースを削除する
+func (l *FileLock) Release(holder string) error {
+	return l.withGuard(func() error {
+		lease, err := l.read()
+		if err != nil {
+			return err
+		}
+		if lease == nil || lease.Holder != holder {
+			return nil
+		}
+		return os.Remove(l.Path)
+	})
+}
+
+// Current 現在のリースを返す (期限が切れている場合もそのまま返す)
+func (l *FileLock) Current() (*Lease, error) {
+	return l.read()
+}
+
+func (l *FileLock) read() (*Lease, error) {
+	b, err := ioutil.ReadFile(l.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lease := &Lease{}
+	if err := json.Unmarshal(b, lease); err != nil {
+		return nil, xerrors.Errorf("%s: %w", l.Path, err)
+	}
+	return lease, nil
+}
+
+func (l *FileLock) write(lease *Lease) error {
+	b, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+
+	// 読み込み途中のファイルを他のプロセスが読まないように、一時ファイルに書いてからrenameする
+	tmp := filepath.Join(filepath.Dir(l.Path), "."+filepath.Base(l.Path)+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.Path)
+}
+
+// withGuard <path>.lock を取得できたら fn を実行し、終わったら解放する
+func (l *FileLock) withGuard(fn func() error) error {
+	release, err := acquireGuard(l.Path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return fn()
+}
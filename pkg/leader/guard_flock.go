@@ -0,0 +1,44 @@
+//go:build !windows
+// +build !windows
+
+package leader
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// acquireGuard guard を flock で排他的にロックし、解放する関数を返す
+// ロックはプロセスが異常終了してもOSが解放するため、guardファイルは削除しない
+// (削除すると、削除前のファイルをロックしているプロセスと新しく作られたファイルをロックするプロセスが共存してしまう)
+func acquireGuard(guard string) (func(), error) {
+	f, err := os.OpenFile(guard, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(guardTimeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK && err != syscall.EINTR {
+			f.Close()
+			return nil, xerrors.Errorf("failed to lock %s: %w", guard, err)
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, xerrors.Errorf("timed out waiting for %s", guard)
+		}
+		time.Sleep(guardRetryInterval)
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
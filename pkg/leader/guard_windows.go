@@ -0,0 +1,68 @@
+package leader
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// この時間より古いguardファイルは、取得したプロセスが異常終了したものとみなして削除する
+const guardStale = 10 * time.Second
+
+// acquireGuard guard を O_EXCL で作成し、削除する関数を返す
+// Windowsでは flock が使えないため、ファイルを作成できたプロセスがguardを持つ
+func acquireGuard(guard string) (func(), error) {
+	deadline := time.Now().Add(guardTimeout)
+
+	var owned os.FileInfo
+	for {
+		f, err := os.OpenFile(guard, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			owned, err = f.Stat()
+			f.Close()
+			if err != nil {
+				os.Remove(guard)
+				return nil, err
+			}
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(guard); err == nil && time.Since(info.ModTime()) > guardStale {
+			removeGuard(guard, info)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, xerrors.Errorf("timed out waiting for %s", guard)
+		}
+		time.Sleep(guardRetryInterval)
+	}
+
+	return func() {
+		removeGuard(guard, owned)
+	}, nil
+}
+
+// removeGuard guard が info と同じファイルの場合のみ削除する
+// 他のプロセスが古いguardファイルを削除して作り直したguardを消さないように、
+// 一意な名前にrenameしてから同じファイルかを確かめ、違った場合は元に戻す
+func removeGuard(guard string, info os.FileInfo) {
+	moved := fmt.Sprintf("%s.%d.%d", guard, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(guard, moved); err != nil {
+		return
+	}
+
+	current, err := os.Stat(moved)
+	if err == nil && os.SameFile(current, info) && current.ModTime().Equal(info.ModTime()) {
+		os.Remove(moved)
+		return
+	}
+	// 既に新しいguardが作られている場合は上書きしない
+	if err := os.Link(moved, guard); err == nil {
+		os.Remove(moved)
+	}
+}
@@ -0,0 +1,206 @@
+package leader
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"go.uber.org/zap"
+	"golang.org/x/xerrors"
+)
+
+const (
+	defaultBackend       = "file"
+	defaultLeaseDuration = 15 * time.Second
+)
+
+// Lease リーダーのリース
+type Lease struct {
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	RenewedAt  time.Time `json:"renewed_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Lock リースを保存する場所
+// 複数のホストで動かす場合は、共有のストレージを使う Lock を RegisterBackend で登録して使う
+type Lock interface {
+	// TryAcquire holder としてリースを取得・更新する
+	// 他の holder が有効なリースを持っている場合はfalseと、そのリースを返す
+	TryAcquire(holder string, ttl time.Duration) (bool, *Lease, error)
+	// Release holder が持っているリースを解放する
+	Release(holder string) error
+	// Current 現在のリースを返す (リースがない場合はnil)
+	Current() (*Lease, error)
+}
+
+// Backend 設定ファイルから Lock を生成する
+type Backend func(cfg *types.SchedulerConfig) (Lock, error)
+
+var backends = map[string]Backend{
+	"file": func(cfg *types.SchedulerConfig) (Lock, error) {
+		path := cfg.Setting.LeaderElection.LockFile
+		if path == "" {
+			return nil, xerrors.New("leader_election.lock_file is required for file backend")
+		}
+		return NewFileLock(path), nil
+	},
+}
+
+// RegisterBackend leader_election.backend で name を指定したときに使う Backend を登録する
+func RegisterBackend(name string, backend Backend) {
+	backends[name] = backend
+}
+
+// Status /status で返すリーダーの状態
+type Status struct {
+	ID       string `json:"id"`
+	IsLeader bool   `json:"is_leader"`
+	Leader   *Lease `json:"leader"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Elector リースを定期的に取得・更新し、自分がリーダーかどうかを管理する
+type Elector struct {
+	ID            string
+	LeaseDuration time.Duration
+
+	lock Lock
+	lg   *zap.Logger
+
+	mu sync.Mutex
+	// リーダーとして振る舞ってよい期限
+	// リースの取得を試みる前の時刻から LeaseDuration 後にするため、リースの有効期限よりも前になる
+	leaderUntil time.Time
+	lease       *Lease
+}
+
+// NewElector 設定ファイルから Elector を生成する
+// leader_election.enabled がfalseの場合はnilを返す
+func NewElector(cfg *types.SchedulerConfig, lg *zap.Logger) (*Elector, error) {
+	c := cfg.Setting.LeaderElection
+	if !c.Enabled {
+		return nil, nil
+	}
+
+	name := c.Backend
+	if name == "" {
+		name = defaultBackend
+	}
+	backend, ok := backends[name]
+	if !ok {
+		return nil, xerrors.Errorf("unknown leader_election.backend: %s", name)
+	}
+	lock, err := backend(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Elector{
+		ID:            c.ID,
+		LeaseDuration: defaultLeaseDuration,
+		lock:          lock,
+		lg:            lg,
+	}
+	if e.ID == "" {
+		hostname, _ := os.Hostname()
+		e.ID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	if c.LeaseDuration > 0 {
+		e.LeaseDuration = time.Duration(c.LeaseDuration) * time.Second
+	}
+	return e, nil
+}
+
+// Tick リースの取得・更新を1回試み、自分がリーダーかどうかを返す
+func (e *Elector) Tick() bool {
+	start := time.Now()
+	acquired, lease, err := e.lock.TryAcquire(e.ID, e.LeaseDuration)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	wasLeader := start.Before(e.leaderUntil)
+	e.lease = lease
+	if err != nil {
+		// 更新に失敗しても、既に持っているリースの期限までは leaderUntil を変えない
+		e.lg.Error("Leader: failed to acquire lease", zap.String("id", e.ID), zap.Error(err))
+		return time.Now().Before(e.leaderUntil)
+	}
+
+	if acquired {
+		e.leaderUntil = start.Add(e.LeaseDuration)
+		if !wasLeader {
+			e.lg.Info("Leader: became leader", zap.String("id", e.ID))
+		}
+		return true
+	}
+
+	e.leaderUntil = time.Time{}
+	if wasLeader {
+		e.lg.Warn("Leader: lost leadership", zap.String("id", e.ID), zap.String("leader", lease.Holder))
+	}
+	return false
+}
+
+// Run stop が閉じられるまで LeaseDuration の1/3ごとにリースの取得・更新を行う
+// stop が閉じられたらリースを解放する
+func (e *Elector) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(e.LeaseDuration / 3)
+	defer ticker.Stop()
+
+	e.Tick()
+	for {
+		select {
+		case <-stop:
+			e.Release()
+			return
+		case <-ticker.C:
+			e.Tick()
+		}
+	}
+}
+
+// IsLeader 自分がリーダーかどうかを返す
+// nilの場合(leader_election が無効な場合)は常にtrueを返す
+func (e *Elector) IsLeader() bool {
+	if e == nil {
+		return true
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return time.Now().Before(e.leaderUntil)
+}
+
+// Release 自分が持っているリースを解放し、他のレプリカがすぐにリーダーになれるようにする
+func (e *Elector) Release() {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.leaderUntil = time.Time{}
+	e.mu.Unlock()
+
+	if err := e.lock.Release(e.ID); err != nil {
+		e.lg.Error("Leader: failed to release lease", zap.String("id", e.ID), zap.Error(err))
+		return
+	}
+	e.lg.Info("Leader: released lease", zap.String("id", e.ID))
+}
+
+// Status 現在のリーダーの状態を返す
+func (e *Elector) Status() Status {
+	if e == nil {
+		return Status{IsLeader: true}
+	}
+
+	lease, err := e.lock.Current()
+	if err != nil {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return Status{ID: e.ID, IsLeader: time.Now().Before(e.leaderUntil), Leader: e.lease, Error: err.Error()}
+	}
+	return Status{ID: e.ID, IsLeader: e.IsLeader(), Leader: lease}
+}
@@ -0,0 +1,145 @@
+package leader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestFileLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lock := NewFileLock(filepath.Join(dir, "lease.json"))
+
+	acquired, lease, err := lock.TryAcquire("a", time.Minute)
+	if err != nil || !acquired || lease.Holder != "a" {
+		t.Fatalf("a should acquire lease: acquired=%v lease=%+v err=%v", acquired, lease, err)
+	}
+	acquiredAt := lease.AcquiredAt
+
+	// 有効なリースがある間は他の holder は取得できない
+	acquired, lease, err = lock.TryAcquire("b", time.Minute)
+	if err != nil || acquired || lease.Holder != "a" {
+		t.Fatalf("b should not acquire lease: acquired=%v lease=%+v err=%v", acquired, lease, err)
+	}
+
+	// 更新しても取得した時刻は変わらない
+	acquired, lease, err = lock.TryAcquire("a", time.Minute)
+	if err != nil || !acquired || !lease.AcquiredAt.Equal(acquiredAt) {
+		t.Fatalf("a should renew lease: acquired=%v lease=%+v err=%v", acquired, lease, err)
+	}
+
+	// 他の holder は解放できない
+	if err := lock.Release("b"); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := lock.Current(); current == nil || current.Holder != "a" {
+		t.Fatalf("lease should be held by a: %+v", current)
+	}
+
+	// 解放されたら取得できる
+	if err := lock.Release("a"); err != nil {
+		t.Fatal(err)
+	}
+	acquired, _, err = lock.TryAcquire("b", time.Millisecond)
+	if err != nil || !acquired {
+		t.Fatalf("b should acquire released lease: acquired=%v err=%v", acquired, err)
+	}
+
+	// 期限が切れたリースは取得できる
+	time.Sleep(5 * time.Millisecond)
+	acquired, lease, err = lock.TryAcquire("a", time.Minute)
+	if err != nil || !acquired || lease.Holder != "a" {
+		t.Fatalf("a should acquire expired lease: acquired=%v lease=%+v err=%v", acquired, lease, err)
+	}
+}
+
+func TestFileLockConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "lease.json")
+	// 異常終了したプロセスが残したguardファイルがあっても取得できる
+	if err := ioutil.WriteFile(path+".lock", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同時に取得しようとしても、リースを取得できるのは1つだけ
+	var mu sync.Mutex
+	holders := []string{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		holder := fmt.Sprintf("replica-%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			acquired, _, err := NewFileLock(path).TryAcquire(holder, time.Minute)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if acquired {
+				mu.Lock()
+				holders = append(holders, holder)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(holders) != 1 {
+		t.Fatalf("got holders %v, want exactly one", holders)
+	}
+	if current, _ := NewFileLock(path).Current(); current == nil || current.Holder != holders[0] {
+		t.Errorf("lease should be held by %s: %+v", holders[0], current)
+	}
+}
+
+func TestElector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lock := NewFileLock(filepath.Join(dir, "lease.json"))
+	a := &Elector{ID: "a", LeaseDuration: time.Minute, lock: lock, lg: zap.NewNop()}
+	b := &Elector{ID: "b", LeaseDuration: time.Minute, lock: lock, lg: zap.NewNop()}
+
+	if !a.Tick() || !a.IsLeader() {
+		t.Fatal("a should be leader")
+	}
+	if b.Tick() || b.IsLeader() {
+		t.Fatal("b should be standby")
+	}
+	if s := b.Status(); s.IsLeader || s.Leader == nil || s.Leader.Holder != "a" {
+		t.Fatalf("unexpected status: %+v", s)
+	}
+
+	a.Release()
+	if a.IsLeader() {
+		t.Fatal("a should not be leader after release")
+	}
+	if !b.Tick() || !b.IsLeader() {
+		t.Fatal("b should take over")
+	}
+
+	// leader_election が無効な場合は常にリーダー
+	var disabled *Elector
+	if !disabled.IsLeader() {
+		t.Fatal("nil elector should be leader")
+	}
+}
@@ -0,0 +1,104 @@
+package logging
+
+import (
+	"os"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/xerrors"
+)
+
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+// Options ロガーの設定
+type Options struct {
+	// debug, info, warn, error
+	Level string
+	// 標準エラー出力の形式 (console, json)。ファイルには常にjsonで出力する
+	Format string
+	// ログファイルのパス (空の場合はファイルに出力しない)
+	FilePath string
+	// ログファイルがこのサイズ(MB)を超えたらローテーションする (0の場合はローテーションしない)
+	MaxSizeMB int
+	// ローテーションしたファイルを残す数
+	MaxBackups int
+}
+
+// https://k1low.hatenablog.com/entry/2018/08/15/100000
+var encoderConfig = zapcore.EncoderConfig{
+	TimeKey:        "time",
+	LevelKey:       "level",
+	NameKey:        "name",
+	CallerKey:      "caller",
+	MessageKey:     "msg",
+	StacktraceKey:  "stacktrace",
+	EncodeLevel:    zapcore.LowercaseLevelEncoder,
+	EncodeTime:     zapcore.ISO8601TimeEncoder,
+	EncodeDuration: zapcore.StringDurationEncoder,
+	EncodeCaller:   zapcore.ShortCallerEncoder,
+}
+
+// New 標準エラー出力と、FilePath が指定されている場合はファイルに出力するロガーを返す
+// 標準出力はコマンドの出力(-o json など)に使うため、ログは標準エラー出力に出す
+func New(opts Options) (*zap.Logger, error) {
+	level := zapcore.InfoLevel
+	if opts.Level != "" {
+		if err := level.UnmarshalText([]byte(strings.ToLower(opts.Level))); err != nil {
+			return nil, xerrors.Errorf("invalid log level %q: %w", opts.Level, err)
+		}
+	}
+
+	var encoder zapcore.Encoder
+	switch opts.Format {
+	case "", FormatConsole:
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	case FormatJSON:
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	default:
+		return nil, xerrors.Errorf("invalid log format %q: must be %s or %s", opts.Format, FormatConsole, FormatJSON)
+	}
+
+	cores := []zapcore.Core{
+		zapcore.NewCore(encoder, zapcore.AddSync(os.Stderr), level),
+	}
+
+	if opts.FilePath != "" {
+		file, err := OpenRotatingFile(opts.FilePath, opts.MaxSizeMB, opts.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		cores = append(cores, zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), file, level))
+	}
+
+	return zap.New(zapcore.NewTee(cores...)), nil
+}
+
+// ログのフィールド名をパッケージ間で揃えるための関数
+
+// TickID schedulerの1回の実行を識別するフィールド
+func TickID(id string) zap.Field { return zap.String("tick_id", id) }
+
+// TraceID tracingが有効な場合に、ログとspanを対応付けるフィールド
+func TraceID(id string) zap.Field { return zap.String("trace_id", id) }
+
+// Problem 問題(machine_image_name)のフィールド
+func Problem(name string) zap.Field { return zap.String("problem", name) }
+
+// Instance インスタンス名のフィールド
+func Instance(name string) zap.Field { return zap.String("instance", name) }
+
+// Project GCPのProjectのフィールド
+func Project(name string) zap.Field { return zap.String("project", name) }
+
+// Zone Zoneのフィールド
+func Zone(name string) zap.Field { return zap.String("zone", name) }
+
+// NewTickID schedulerの1回の実行ごとに振る識別子を返す
+func NewTickID() string {
+	return uuid.Must(uuid.NewV4()).String()[:8]
+}
@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// RotatingFile MaxSize を超えたらローテーションするログファイル
+// ローテーションしたファイルは <path>.1, <path>.2, ... の順に古くなり、MaxBackups を超えたものは削除する
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile path を追記モードで開く
+// maxSizeMB が0の場合はローテーションしない
+func OpenRotatingFile(path string, maxSizeMB, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		Path:       path,
+		MaxSize:    int64(maxSizeMB) * 1024 * 1024,
+		MaxBackups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// openFile テストで差し替えられるようにしている
+var openFile = os.OpenFile
+
+func (f *RotatingFile) open() error {
+	file, size, err := openAppend(f.Path)
+	if err != nil {
+		return err
+	}
+	f.file = file
+	f.size = size
+	return nil
+}
+
+func openAppend(path string) (*os.File, int64, error) {
+	file, err := openFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// Write p を書き込む。書き込むと MaxSize を超える場合は先にローテーションする
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var rotateErr error
+	if f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize {
+		rotateErr = f.rotate()
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err == nil {
+		// ローテーションに失敗した場合も書き込みは行い、エラーはzapのエラー出力に任せる
+		err = rotateErr
+	}
+	return n, err
+}
+
+// Sync zapcore.WriteSyncer の実装
+func (f *RotatingFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Sync()
+}
+
+// Close ファイルを閉じる
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// rotate 現在のファイルをバックアップにして、新しいファイルに切り替える
+// 新しいファイルを開けるまでは元のファイルに書き続けられるように、
+// 元のファイルを退避してから新しいファイルを開き、開けた場合のみ切り替える
+func (f *RotatingFile) rotate() error {
+	backup := func(n int) string {
+		return fmt.Sprintf("%s.%d", f.Path, n)
+	}
+	rotating := f.Path + ".rotating"
+
+	// 開いたままrenameするので、元のファイルへの書き込みは退避先に続く
+	if err := os.Rename(f.Path, rotating); err != nil {
+		return err
+	}
+	file, size, err := openAppend(f.Path)
+	if err != nil {
+		// 元の名前に戻して、元のファイルに書き続ける
+		if renameErr := os.Rename(rotating, f.Path); renameErr != nil {
+			return xerrors.Errorf("failed to reopen %s: %v (and failed to restore it: %v)", f.Path, err, renameErr)
+		}
+		return err
+	}
+
+	old := f.file
+	f.file = file
+	f.size = size
+	// 切り替えた後は閉じるのに失敗しても新しいファイルに書き続ける
+	closeErr := old.Close()
+
+	// Windowsでは既存のファイルにrenameできないため、先に一番古いファイルを削除する
+	os.Remove(backup(f.MaxBackups))
+	for n := f.MaxBackups - 1; n >= 1 && err == nil; n-- {
+		if _, statErr := os.Stat(backup(n)); statErr == nil {
+			err = os.Rename(backup(n), backup(n+1))
+		}
+	}
+	if err == nil {
+		if f.MaxBackups > 0 {
+			err = os.Rename(rotating, backup(1))
+		} else {
+			err = os.Remove(rotating)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
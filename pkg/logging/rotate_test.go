@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netcon-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "scheduler.log")
+	f, err := OpenRotatingFile(path, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.MaxSize = 10
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for p, content := range want {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Errorf("%s: got %q, want %q", p, b, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 should not exist", path)
+	}
+}
+
+func TestRotatingFileReopenFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netcon-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "scheduler.log")
+	f, err := OpenRotatingFile(path, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.MaxSize = 10
+
+	// ローテーション後のファイルを開けない場合は、元のファイルに書き続ける
+	openFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	defer func() { openFile = os.OpenFile }()
+
+	if _, err := f.Write([]byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"second\n", "third\n"} {
+		n, err := f.Write([]byte(line))
+		if err == nil {
+			t.Error("Write() should return the rotation error")
+		}
+		if n != len(line) {
+			t.Errorf("Write() wrote %d bytes, want %d", n, len(line))
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "first\nsecond\nthird\n" {
+		t.Errorf("got %q, want all lines in %s", b, path)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("%s.1 should not exist", path)
+	}
+}
+
+func TestNewInvalidOptions(t *testing.T) {
+	if _, err := New(Options{Level: "verbose"}); err == nil || !strings.Contains(err.Error(), "invalid log level") {
+		t.Errorf("got %v, want invalid log level error", err)
+	}
+	if _, err := New(Options{Format: "text"}); err == nil || !strings.Contains(err.Error(), "invalid log format") {
+		t.Errorf("got %v, want invalid log format error", err)
+	}
+}
@@ -0,0 +1,282 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"go.uber.org/zap"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// RulePoolEmpty 問題のReadyなインスタンスが0台になった
+	RulePoolEmpty = "pool-empty"
+	// RuleZoneFull Zoneのインスタンス数が上限に達した
+	RuleZoneFull = "zone-full"
+	// RuleCreateFailure インスタンスの作成に連続で失敗している
+	RuleCreateFailure = "create-failure"
+	// RuleStuckNotReady NOT_READYのまま一定時間が経過したインスタンスがある
+	RuleStuckNotReady = "stuck-not-ready"
+	// RuleBudgetExceeded budget を超えるためインスタンスの作成を見送った
+	RuleBudgetExceeded = "budget-exceeded"
+)
+
+const (
+	// StatusFiring アラートが発生している
+	StatusFiring = "firing"
+	// StatusResolved アラートが解消した
+	StatusResolved = "resolved"
+)
+
+const (
+	defaultRepeatInterval         = 10 * time.Minute
+	defaultCreateFailureThreshold = 3
+	defaultNotReadyTimeout        = 10 * time.Minute
+	// queueSize 送信待ちにできる通知の数 (これを超えた通知は捨てる)
+	queueSize = 100
+	// closeTimeout Close で送信待ちの通知を送り切るのを待つ時間
+	closeTimeout = 10 * time.Second
+)
+
+// Payload Webhookに送信するbody
+// Slackの Incoming Webhook と互換性を持たせるため、本文は text に入れる
+type Payload struct {
+	Text   string `json:"text"`
+	Rule   string `json:"rule"`
+	Key    string `json:"key"`
+	Status string `json:"status"`
+}
+
+type alert struct {
+	rule     string
+	message  string
+	firedAt  time.Time
+	lastSent time.Time
+}
+
+// Notifier ルールに一致したschedulerのイベントをWebhookに通知する
+// 同じkeyのアラートは RepeatInterval の間は再送しない
+// Webhookへの送信はバックグラウンドで行うため、Observe は送信を待たない
+type Notifier struct {
+	Webhooks               []string
+	RepeatInterval         time.Duration
+	CreateFailureThreshold int
+	NotReadyTimeout        time.Duration
+
+	client   *http.Client
+	lg       *zap.Logger
+	mu       sync.Mutex
+	alerts   map[string]*alert
+	failures map[string]int
+	now      func() time.Time
+	queue    chan Payload
+	done     chan struct{}
+	closed   bool
+}
+
+// NewNotifier 設定ファイルからNotifierを生成する
+// Webhookが1つも設定されていない場合はnilを返す (nilのNotifierは何も通知しない)
+func NewNotifier(cfg *types.SchedulerConfig, lg *zap.Logger) *Notifier {
+	c := cfg.Setting.Notifier
+
+	webhooks := []string{}
+	for _, w := range c.Webhooks {
+		if w.URL != "" {
+			webhooks = append(webhooks, w.URL)
+		}
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	n := &Notifier{
+		Webhooks:               webhooks,
+		RepeatInterval:         defaultRepeatInterval,
+		CreateFailureThreshold: defaultCreateFailureThreshold,
+		NotReadyTimeout:        defaultNotReadyTimeout,
+		client:                 &http.Client{Timeout: 5 * time.Second},
+		lg:                     lg,
+		alerts:                 map[string]*alert{},
+		failures:               map[string]int{},
+		now:                    time.Now,
+	}
+
+	if c.RepeatInterval > 0 {
+		n.RepeatInterval = time.Duration(c.RepeatInterval) * time.Second
+	}
+	if c.Rules.CreateFailureThreshold > 0 {
+		n.CreateFailureThreshold = c.Rules.CreateFailureThreshold
+	}
+	if c.Rules.NotReadyTimeout > 0 {
+		n.NotReadyTimeout = time.Duration(c.Rules.NotReadyTimeout) * time.Second
+	}
+
+	n.start()
+	return n
+}
+
+// start 送信待ちの通知をWebhookに送るgoroutineを起動する
+func (n *Notifier) start() {
+	n.queue = make(chan Payload, queueSize)
+	n.done = make(chan struct{})
+
+	go func() {
+		defer close(n.done)
+		for p := range n.queue {
+			n.send(p)
+		}
+	}()
+}
+
+// Close 送信待ちの通知を送り切ってから送信用のgoroutineを止める
+// closeTimeout を過ぎても送り切れない場合は残りを諦める
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.closed = true
+	close(n.queue)
+	n.mu.Unlock()
+
+	select {
+	case <-n.done:
+	case <-time.After(closeTimeout):
+		n.lg.Warn("Notifier: gave up sending queued notifications", zap.Int("remaining", len(n.queue)))
+	}
+}
+
+// Observe keyで識別される条件の状態を通知する
+// active が true になったときに通知し、その後は RepeatInterval ごとに再通知する
+// active が false になったときはアラートが発生していれば解消を通知する
+func (n *Notifier) Observe(rule, key string, active bool, message string) {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := n.now()
+	a, firing := n.alerts[key]
+
+	if !active {
+		if firing {
+			delete(n.alerts, key)
+			n.enqueue(Payload{
+				Text:   fmt.Sprintf("[RESOLVED] %s: %s (firing since %s)", rule, a.message, a.firedAt.Format(time.RFC3339)),
+				Rule:   rule,
+				Key:    key,
+				Status: StatusResolved,
+			})
+		}
+		return
+	}
+
+	if firing && now.Sub(a.lastSent) < n.RepeatInterval {
+		// 重複したアラートは送らない
+		a.message = message
+		return
+	}
+
+	if !firing {
+		a = &alert{rule: rule, firedAt: now}
+		n.alerts[key] = a
+	}
+	a.message = message
+	a.lastSent = now
+
+	n.enqueue(Payload{
+		Text:   fmt.Sprintf("[FIRING] %s: %s", rule, message),
+		Rule:   rule,
+		Key:    key,
+		Status: StatusFiring,
+	})
+}
+
+// RecordFailure keyの連続失敗回数を1つ増やし、増やした後の回数を返す
+func (n *Notifier) RecordFailure(key string) int {
+	if n == nil {
+		return 0
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.failures[key]++
+	return n.failures[key]
+}
+
+// ResetFailure keyの連続失敗回数を0に戻す
+func (n *Notifier) ResetFailure(key string) {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.failures, key)
+}
+
+// enqueue payloadを送信待ちにする
+// 送信が詰まってもschedulerを待たせないように、キューが一杯の場合は捨てる
+// n.mu を取得した状態で呼ぶこと
+func (n *Notifier) enqueue(payload Payload) {
+	if n.closed {
+		return
+	}
+
+	select {
+	case n.queue <- payload:
+	default:
+		n.lg.Warn("Notifier: notification queue is full, dropping notification", zap.String("rule", payload.Rule), zap.String("key", payload.Key))
+	}
+}
+
+// send 全てのWebhookにpayloadを送信する
+// 通知の失敗でschedulerを止めたくないので、エラーはログに出力するだけにしている
+func (n *Notifier) send(payload Payload) {
+	for _, u := range n.Webhooks {
+		if err := n.post(u, payload); err != nil {
+			n.lg.Error("Notifier: failed to send notification", zap.String("rule", payload.Rule), zap.Error(err))
+		}
+	}
+}
+
+func (n *Notifier) post(u string, payload Payload) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", u, bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return xerrors.New(fmt.Sprintf("status code not 2xx: status code is %d: body: %s", resp.StatusCode, body))
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func Test_Observe(t *testing.T) {
+	mu := sync.Mutex{}
+	received := []Payload{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p Payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		received = append(received, p)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	now := time.Date(2021, 1, 7, 21, 0, 0, 0, time.UTC)
+	n := &Notifier{
+		Webhooks:       []string{ts.URL},
+		RepeatInterval: 10 * time.Minute,
+		client:         ts.Client(),
+		lg:             zap.NewNop(),
+		alerts:         map[string]*alert{},
+		failures:       map[string]int{},
+		now:            func() time.Time { return now },
+	}
+	n.start()
+
+	// 発生 -> 重複は送らない -> RepeatInterval 経過後に再送 -> 解消
+	n.Observe(RulePoolEmpty, "pool-empty/image-aki", true, "empty")
+	n.Observe(RulePoolEmpty, "pool-empty/image-aki", true, "empty")
+	now = now.Add(11 * time.Minute)
+	n.Observe(RulePoolEmpty, "pool-empty/image-aki", true, "empty")
+	n.Observe(RulePoolEmpty, "pool-empty/image-aki", false, "")
+	n.Observe(RulePoolEmpty, "pool-empty/image-aki", false, "")
+	n.Close()
+
+	want := []string{StatusFiring, StatusFiring, StatusResolved}
+	if len(received) != len(want) {
+		t.Fatalf("got %d notifications, want %d: %#v", len(received), len(want), received)
+	}
+	for i, s := range want {
+		if received[i].Status != s {
+			t.Errorf("notification %d: got status %s, want %s", i, received[i].Status, s)
+		}
+	}
+}
+
+func Test_ObserveDoesNotWaitForWebhook(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan Payload, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var p Payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Error(err)
+		}
+		received <- p
+	}))
+	defer ts.Close()
+
+	n := &Notifier{
+		Webhooks:       []string{ts.URL},
+		RepeatInterval: 10 * time.Minute,
+		client:         ts.Client(),
+		lg:             zap.NewNop(),
+		alerts:         map[string]*alert{},
+		failures:       map[string]int{},
+		now:            time.Now,
+	}
+	n.start()
+
+	// Webhookが応答しなくてもObserveとRecordFailureは待たされない
+	finished := make(chan struct{})
+	go func() {
+		n.Observe(RuleZoneFull, "zone-full/a/b", true, "full")
+		n.Observe(RuleCreateFailure, "create-failure/image-aki", true, "failed")
+		n.RecordFailure(RuleCreateFailure)
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("Observe waited for the webhook")
+	}
+
+	close(release)
+	n.Close()
+	if len(received) != 2 {
+		t.Errorf("got %d notifications, want 2", len(received))
+	}
+	// Close の後の通知は捨てる
+	n.Observe(RuleZoneFull, "zone-full/a/c", true, "full")
+	n.Close()
+}
+
+func Test_NilNotifier(t *testing.T) {
+	var n *Notifier
+	n.Observe(RuleZoneFull, "zone-full/a/b", true, "full")
+	if got := n.RecordFailure(RuleCreateFailure); got != 0 {
+		t.Errorf("got %d, want 0", got)
+	}
+	n.ResetFailure(RuleCreateFailure)
+	n.Close()
+}
@@ -0,0 +1,256 @@
+package prober
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/logging"
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"go.uber.org/zap"
+	"golang.org/x/xerrors"
+)
+
+const (
+	defaultTimeout          = 3 * time.Second
+	defaultFailureThreshold = 3
+	defaultConcurrency      = 16
+)
+
+// Result 1つのサービスに対する確認結果
+type Result struct {
+	Service string `json:"service"`
+	Address string `json:"address"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+	// 確認にかかったミリ秒数
+	LatencyMs int64 `json:"latency_ms"`
+}
+
+// Health インスタンスごとの確認結果
+// いずれかのサービスに接続できなかった場合は失敗として数える
+type Health struct {
+	InstanceName        string    `json:"instance_name"`
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastProbedAt        time.Time `json:"last_probed_at"`
+	Results             []Result  `json:"results"`
+}
+
+// Prober READYなインスタンスの各サービスに接続し、実際に使える状態かを確認する
+// スコアサーバのステータスだけでは、VMのSSHやHTTPSが落ちていることを検知できないため
+type Prober struct {
+	Timeout          time.Duration
+	FailureThreshold int
+	TLS              bool
+	SSHBanner        bool
+	Concurrency      int
+
+	lg     *zap.Logger
+	mu     sync.Mutex
+	health map[string]*Health
+	dial   func(network, address string, timeout time.Duration) (net.Conn, error)
+	now    func() time.Time
+}
+
+// NewProber 設定ファイルからProberを生成する
+// prober.enabled がfalseの場合はnilを返す (nilのProberは何も確認しない)
+func NewProber(cfg *types.SchedulerConfig, lg *zap.Logger) *Prober {
+	c := cfg.Setting.Prober
+	if !c.Enabled {
+		return nil
+	}
+
+	p := &Prober{
+		Timeout:          defaultTimeout,
+		FailureThreshold: defaultFailureThreshold,
+		TLS:              c.TLS,
+		SSHBanner:        c.SSHBanner,
+		Concurrency:      defaultConcurrency,
+		lg:               lg,
+		health:           map[string]*Health{},
+		dial:             net.DialTimeout,
+		now:              time.Now,
+	}
+	if c.TimeoutMs > 0 {
+		p.Timeout = time.Duration(c.TimeoutMs) * time.Millisecond
+	}
+	if c.FailureThreshold > 0 {
+		p.FailureThreshold = c.FailureThreshold
+	}
+	if c.Concurrency > 0 {
+		p.Concurrency = c.Concurrency
+	}
+
+	return p
+}
+
+// Probe instances (インスタンス名 -> サービスの接続先) の全てのサービスに接続し、結果を記録する
+// instances に含まれないインスタンスの結果は削除する
+func (p *Prober) Probe(instances map[string][]types.Endpoint) {
+	if p == nil {
+		return
+	}
+
+	type job struct {
+		name      string
+		endpoints []types.Endpoint
+	}
+	jobs := make(chan job)
+	go func() {
+		for name, endpoints := range instances {
+			jobs <- job{name: name, endpoints: endpoints}
+		}
+		close(jobs)
+	}()
+
+	results := map[string][]Result{}
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for w := 0; w < p.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				rs := []Result{}
+				for _, e := range j.endpoints {
+					rs = append(rs, p.probe(e))
+				}
+				mu.Lock()
+				results[j.name] = rs
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	health := map[string]*Health{}
+	for name, rs := range results {
+		h, ok := p.health[name]
+		if !ok {
+			h = &Health{InstanceName: name}
+		}
+		h.LastProbedAt = now
+		h.Results = rs
+		h.Healthy = true
+		for _, r := range rs {
+			if !r.OK {
+				h.Healthy = false
+			}
+		}
+		if h.Healthy {
+			h.ConsecutiveFailures = 0
+		} else {
+			h.ConsecutiveFailures++
+			p.lg.Warn("Prober: Instance is unhealthy",
+				logging.Instance(name),
+				zap.Int("consecutive_failures", h.ConsecutiveFailures),
+				zap.Int("failure_threshold", p.FailureThreshold),
+				zap.String("failed", failedResults(rs)),
+			)
+		}
+		health[name] = h
+	}
+	p.health = health
+}
+
+// Unhealthy FailureThreshold 回以上連続で確認に失敗しているか
+func (p *Prober) Unhealthy(name string) bool {
+	if p == nil {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h, ok := p.health[name]
+	return ok && h.ConsecutiveFailures >= p.FailureThreshold
+}
+
+// Snapshot 現在の確認結果のコピーを返す
+func (p *Prober) Snapshot() map[string]Health {
+	snapshot := map[string]Health{}
+	if p == nil {
+		return snapshot
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for name, h := range p.health {
+		c := *h
+		c.Results = append([]Result{}, h.Results...)
+		snapshot[name] = c
+	}
+	return snapshot
+}
+
+// probe 1つのサービスに接続する
+// SSH は SSHBanner がtrueの場合に banner(SSH-2.0-...) を受信できるか、
+// HTTPS は TLS がtrueの場合にTLSのhandshakeができるかを確認し、それ以外はTCPで接続できるかを確認する
+func (p *Prober) probe(e types.Endpoint) Result {
+	address := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	r := Result{Service: e.Service, Address: address}
+
+	start := p.now()
+	err := p.check(strings.ToUpper(e.Service), address)
+	r.LatencyMs = p.now().Sub(start).Milliseconds()
+
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.OK = true
+	return r
+}
+
+func (p *Prober) check(service, address string) error {
+	conn, err := p.dial("tcp", address, p.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(p.Timeout)); err != nil {
+		return err
+	}
+
+	switch {
+	case service == "SSH" && p.SSHBanner:
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return xerrors.Errorf("failed to read ssh banner: %w", err)
+		}
+		if !strings.HasPrefix(line, "SSH-") {
+			return xerrors.New(fmt.Sprintf("unexpected ssh banner: %q", strings.TrimSpace(line)))
+		}
+	case service == "HTTPS" && p.TLS:
+		// 問題VMは自己署名証明書を使っているため、証明書の検証は行わない
+		host, _, _ := net.SplitHostPort(address)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+		if err := tlsConn.Handshake(); err != nil {
+			return xerrors.Errorf("tls handshake error: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func failedResults(rs []Result) string {
+	msgs := []string{}
+	for _, r := range rs {
+		if !r.OK {
+			msgs = append(msgs, r.Service+" "+r.Address+": "+r.Error)
+		}
+	}
+	return strings.Join(msgs, ", ")
+}
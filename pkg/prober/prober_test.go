@@ -0,0 +1,109 @@
+package prober
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"go.uber.org/zap"
+)
+
+// listen bannerを返すTCPサーバを起動し、接続先を返す
+func listen(t *testing.T, banner string) (types.Endpoint, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(banner))
+			conn.Close()
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return types.Endpoint{Service: "SSH", Host: addr.IP.String(), Port: addr.Port}, func() { l.Close() }
+}
+
+func newTestProber(threshold int) *Prober {
+	cfg := &types.SchedulerConfig{}
+	cfg.Setting.Prober.Enabled = true
+	cfg.Setting.Prober.TimeoutMs = 500
+	cfg.Setting.Prober.FailureThreshold = threshold
+	cfg.Setting.Prober.SSHBanner = true
+	return NewProber(cfg, zap.NewNop())
+}
+
+func Test_Probe(t *testing.T) {
+	healthy, closeHealthy := listen(t, "SSH-2.0-OpenSSH_8.2\r\n")
+	defer closeHealthy()
+	wrongBanner, closeWrongBanner := listen(t, "HTTP/1.1 400 Bad Request\r\n")
+	defer closeWrongBanner()
+
+	// 閉じたportに接続する
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	closed := types.Endpoint{Service: "HTTPS", Host: "127.0.0.1", Port: closedPort}
+
+	p := newTestProber(2)
+	instances := map[string][]types.Endpoint{
+		"healthy":      {healthy},
+		"wrong-banner": {wrongBanner},
+		"closed":       {healthy, closed},
+	}
+
+	p.Probe(instances)
+	for name := range instances {
+		if p.Unhealthy(name) {
+			t.Errorf("Unhealthy(%s) = true after 1 failure, want false (threshold 2)", name)
+		}
+	}
+
+	p.Probe(instances)
+	want := map[string]bool{"healthy": false, "wrong-banner": true, "closed": true}
+	for name, unhealthy := range want {
+		if got := p.Unhealthy(name); got != unhealthy {
+			t.Errorf("Unhealthy(%s) = %v, want %v", name, got, unhealthy)
+		}
+	}
+
+	snapshot := p.Snapshot()
+	if h := snapshot["closed"]; h.Healthy || h.ConsecutiveFailures != 2 || len(h.Results) != 2 || !h.Results[0].OK || h.Results[1].OK {
+		t.Errorf("Snapshot()[closed] = %#v", h)
+	}
+	if h := snapshot["healthy"]; !h.Healthy || h.Results[0].Address != net.JoinHostPort(healthy.Host, strconv.Itoa(healthy.Port)) {
+		t.Errorf("Snapshot()[healthy] = %#v", h)
+	}
+
+	// 接続できるようになれば失敗回数はリセットされ、対象から外れたインスタンスの結果は削除される
+	p.Probe(map[string][]types.Endpoint{"closed": {healthy}})
+	if p.Unhealthy("closed") || p.Unhealthy("wrong-banner") {
+		t.Errorf("Unhealthy() should be reset")
+	}
+	if _, ok := p.Snapshot()["wrong-banner"]; ok {
+		t.Errorf("Snapshot() should not contain instances that were not probed")
+	}
+}
+
+func Test_NilProber(t *testing.T) {
+	var p *Prober
+	p.Probe(map[string][]types.Endpoint{"a": {}})
+	if p.Unhealthy("a") {
+		t.Errorf("nil Prober should not report unhealthy instances")
+	}
+	if len(p.Snapshot()) != 0 {
+		t.Errorf("nil Prober should return an empty snapshot")
+	}
+	if NewProber(&types.SchedulerConfig{}, zap.NewNop()) != nil {
+		t.Errorf("NewProber() should return nil when prober is disabled")
+	}
+}
@@ -0,0 +1,148 @@
+package receiver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/logging"
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"go.uber.org/zap"
+)
+
+const defaultDebounce = 500 * time.Millisecond
+
+// Event スコアサーバから送られてくるイベント
+type Event struct {
+	// assigned, abandoned など
+	Event string `json:"event"`
+	// ProblemEnvironment.Name
+	Name string `json:"name"`
+}
+
+// Receiver スコアサーバからのcallbackを受け取り、schedulerの即時実行を要求する
+// 短時間に届いた複数のcallbackは1回の実行にまとめる
+type Receiver struct {
+	ListenAddress string
+	Token         string
+	Debounce      time.Duration
+
+	trigger chan struct{}
+	status  func() interface{}
+	lg      *zap.Logger
+}
+
+// NewReceiver 設定ファイルからReceiverを生成する
+// listen_address が設定されていない場合はnilを返す
+func NewReceiver(cfg *types.SchedulerConfig, lg *zap.Logger) *Receiver {
+	c := cfg.Setting.Receiver
+	if c.ListenAddress == "" {
+		return nil
+	}
+
+	r := &Receiver{
+		ListenAddress: c.ListenAddress,
+		Token:         c.Token,
+		Debounce:      defaultDebounce,
+		// bufferを1にしておくことで、実行待ちのtriggerは1つにまとめられる
+		trigger: make(chan struct{}, 1),
+		lg:      lg,
+	}
+	if c.DebounceMs > 0 {
+		r.Debounce = time.Duration(c.DebounceMs) * time.Millisecond
+	}
+
+	return r
+}
+
+// SetStatus /status で返す内容を設定する
+func (r *Receiver) SetStatus(fn func() interface{}) {
+	r.status = fn
+}
+
+// Fire schedulerの実行を要求する
+// 既に実行待ちのtriggerがある場合は何もしない
+func (r *Receiver) Fire() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Run triggerを待ち受け、Debounce だけ待ってから fn を実行する
+// 待っている間に届いたtriggerは同じ実行にまとめられる
+func (r *Receiver) Run(fn func()) {
+	for range r.trigger {
+		time.Sleep(r.Debounce)
+
+		// 待っている間に届いたtriggerを捨てる
+		select {
+		case <-r.trigger:
+		default:
+		}
+
+		fn()
+	}
+}
+
+// ListenAndServe HTTPサーバを起動する
+func (r *Receiver) ListenAndServe() error {
+	return http.ListenAndServe(r.ListenAddress, r.Handler())
+}
+
+// Handler Receiverのhttp.Handlerを返す
+func (r *Receiver) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", r.handleEvent)
+	mux.HandleFunc("/status", r.handleStatus)
+	return mux
+}
+
+func (r *Receiver) handleEvent(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Token != "" && req.Header.Get("Authorization") != "Bearer "+r.Token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// bodyは必須ではない。読めた場合はログに出力する
+	body, _ := ioutil.ReadAll(req.Body)
+	ev := Event{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &ev); err != nil {
+			r.lg.Error("Receiver: invalid event body", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	r.lg.Info("Receiver: event received", zap.String("event", ev.Event), logging.Instance(ev.Name))
+	r.Fire()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (r *Receiver) handleStatus(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Token != "" && req.Header.Get("Authorization") != "Bearer "+r.Token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var status interface{} = struct{}{}
+	if r.status != nil {
+		status = r.status()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		r.lg.Error("Receiver: failed to encode status", zap.Error(err))
+	}
+}
@@ -0,0 +1,165 @@
+package receiver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"go.uber.org/zap"
+)
+
+func newTestReceiver(token string, debounce time.Duration) *Receiver {
+	cfg := &types.SchedulerConfig{}
+	cfg.Setting.Receiver.ListenAddress = "127.0.0.1:0"
+	cfg.Setting.Receiver.Token = token
+	cfg.Setting.Receiver.DebounceMs = int(debounce / time.Millisecond)
+	return NewReceiver(cfg, zap.NewNop())
+}
+
+func Test_NewReceiver(t *testing.T) {
+	if r := NewReceiver(&types.SchedulerConfig{}, zap.NewNop()); r != nil {
+		t.Errorf("NewReceiver() without listen_address: got %#v, want nil", r)
+	}
+	if r := newTestReceiver("", 0); r.Debounce != defaultDebounce {
+		t.Errorf("Debounce: got %s, want %s", r.Debounce, defaultDebounce)
+	}
+}
+
+func Test_handleEvent(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		method        string
+		authorization string
+		body          string
+		want          int
+		wantFired     bool
+	}{
+		{name: "no token", method: "POST", body: `{"event":"assigned","name":"image-sc0-aaaaa"}`, want: http.StatusAccepted, wantFired: true},
+		{name: "empty body", method: "POST", want: http.StatusAccepted, wantFired: true},
+		{name: "valid token", token: "secret", method: "POST", authorization: "Bearer secret", want: http.StatusAccepted, wantFired: true},
+		{name: "missing token", token: "secret", method: "POST", want: http.StatusUnauthorized},
+		{name: "wrong token", token: "secret", method: "POST", authorization: "Bearer wrong", want: http.StatusUnauthorized},
+		{name: "token without Bearer", token: "secret", method: "POST", authorization: "secret", want: http.StatusUnauthorized},
+		{name: "invalid body", method: "POST", body: `{`, want: http.StatusBadRequest},
+		{name: "GET", method: "GET", want: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestReceiver(tt.token, 0)
+			req := httptest.NewRequest(tt.method, "/events", strings.NewReader(tt.body))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			r.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status: got %d, want %d", rec.Code, tt.want)
+			}
+			if fired := len(r.trigger) == 1; fired != tt.wantFired {
+				t.Errorf("fired: got %v, want %v", fired, tt.wantFired)
+			}
+		})
+	}
+}
+
+func Test_handleStatus(t *testing.T) {
+	r := newTestReceiver("secret", 0)
+	r.SetStatus(func() interface{} {
+		return map[string]interface{}{"id": "host-a-1234", "is_leader": true}
+	})
+
+	req := httptest.NewRequest("GET", "/status", nil)
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("without token: got %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	req = httptest.NewRequest("POST", "/status", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+
+	req = httptest.NewRequest("GET", "/status", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type: got %q, want application/json", ct)
+	}
+	var got struct {
+		ID       string `json:"id"`
+		IsLeader bool   `json:"is_leader"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != "host-a-1234" || !got.IsLeader {
+		t.Errorf("got %+v", got)
+	}
+
+	// SetStatus していない場合は空のobjectを返す
+	r = newTestReceiver("", 0)
+	rec = httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/status", nil))
+	if body := strings.TrimSpace(rec.Body.String()); body != "{}" {
+		t.Errorf("without SetStatus: got %s, want {}", body)
+	}
+}
+
+func Test_Run(t *testing.T) {
+	r := newTestReceiver("", 50*time.Millisecond)
+
+	var runs int32
+	done := make(chan struct{}, 10)
+	go r.Run(func() {
+		atomic.AddInt32(&runs, 1)
+		done <- struct{}{}
+	})
+
+	ts := httptest.NewServer(r.Handler())
+	defer ts.Close()
+
+	// Debounce の間に届いたcallbackは1回の実行にまとめる
+	for i := 0; i < 5; i++ {
+		resp, err := http.Post(ts.URL+"/events", "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("fn was not called")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Errorf("runs after burst: got %d, want 1", n)
+	}
+
+	// 実行後に届いたcallbackは次の実行になる
+	r.Fire()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("fn was not called after second trigger")
+	}
+	if n := atomic.LoadInt32(&runs); n != 2 {
+		t.Errorf("runs: got %d, want 2", n)
+	}
+}
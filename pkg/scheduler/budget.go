@@ -0,0 +1,123 @@
+package scheduler
+
+import (
+	"sort"
+
+	"go.uber.org/zap"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+)
+
+// ApplyBudget budget の max_instance と hourly_budget を超えないように、作成対象のインスタンスを減らす
+// 上限は全体のインスタンス数と費用に対するものなので、設定ファイルにない問題のインスタンスも environments から数える
+// (environments が nil の場合は problems に集計されたインスタンスだけを数える)
+// 同じ実行で削除するインスタンスは先に削除されるため、数えたインスタンスであれば現在のインスタンスから除く
+// 特定の問題だけが作成されなくならないように、問題ごとに1台ずつ順番に作成対象にする
+// 返り値は作成するインスタンスと、budget を超えるため作成を見送ったインスタンス
+func ApplyBudget(creations []CreationTargetInstance, deletions []DeletionTargetInstance, problems map[string]*Problem, environments []types.Environment, cfg *types.SchedulerConfig, lg *zap.Logger) ([]CreationTargetInstance, []CreationTargetInstance) {
+	budget := cfg.Setting.Budget
+	if budget.MaxInstance <= 0 && budget.HourlyBudget <= 0 {
+		return creations, []CreationTargetInstance{}
+	}
+
+	// 現在のインスタンス数と1時間あたりの費用
+	instances := 0
+	hourlyCost := 0.0
+	// 数えたインスタンスの1時間あたりの費用 (インスタンス名ごと)
+	counted := map[string]float64{}
+	if environments == nil {
+		for name, problem := range problems {
+			instances += problem.CurrentInstance - problem.Pending
+			hourlyCost += float64(problem.CurrentInstance-problem.Pending) * problem.HourlyCost
+			for _, d := range deletions {
+				if d.ProblemName == name {
+					counted[d.InstanceName] = problem.HourlyCost
+				}
+			}
+		}
+	}
+	// 設定ファイルにない問題のインスタンスは費用がわからないため、上限を超えない側に倒して設定ファイルで最も高い費用で数える
+	unknownCost := 0.0
+	for _, problem := range problems {
+		if problem.HourlyCost > unknownCost {
+			unknownCost = problem.HourlyCost
+		}
+	}
+	for _, e := range environments {
+		cost := unknownCost
+		if e.MachineImageName != nil {
+			if problem, ok := problems[*e.MachineImageName]; ok {
+				cost = problem.HourlyCost
+			}
+		}
+		instances++
+		hourlyCost += cost
+		counted[e.Name] = cost
+	}
+	// 作成中のインスタンス
+	for _, problem := range problems {
+		instances += problem.Pending
+		hourlyCost += float64(problem.Pending) * problem.HourlyCost
+	}
+	for _, d := range deletions {
+		cost, ok := counted[d.InstanceName]
+		if !ok {
+			continue
+		}
+		// 同じインスタンスを2回以上除かないようにする
+		delete(counted, d.InstanceName)
+		instances--
+		hourlyCost -= cost
+	}
+
+	// 問題ごとに分けて、1台ずつ順番に取り出す
+	queues := map[string][]CreationTargetInstance{}
+	names := []string{}
+	for _, c := range creations {
+		if _, ok := queues[c.ProblemName]; !ok {
+			names = append(names, c.ProblemName)
+		}
+		queues[c.ProblemName] = append(queues[c.ProblemName], c)
+	}
+	sort.Strings(names)
+
+	allowed := []CreationTargetInstance{}
+	refused := []CreationTargetInstance{}
+	for len(allowed)+len(refused) < len(creations) {
+		for _, name := range names {
+			if len(queues[name]) == 0 {
+				continue
+			}
+			c := queues[name][0]
+			queues[name] = queues[name][1:]
+
+			cost := 0.0
+			if problem, ok := problems[name]; ok {
+				cost = problem.HourlyCost
+			}
+			if budget.MaxInstance > 0 && instances+1 > budget.MaxInstance {
+				refused = append(refused, c)
+				continue
+			}
+			if budget.HourlyBudget > 0 && hourlyCost+cost > budget.HourlyBudget {
+				refused = append(refused, c)
+				continue
+			}
+			instances++
+			hourlyCost += cost
+			allowed = append(allowed, c)
+		}
+	}
+
+	if len(refused) > 0 {
+		lg.Warn("Scheduler: ApplyBudget. Creations refused",
+			zap.Int("refused", len(refused)),
+			zap.Int("instances", instances),
+			zap.Int("max_instance", budget.MaxInstance),
+			zap.Float64("hourly_cost", hourlyCost),
+			zap.Float64("hourly_budget", budget.HourlyBudget),
+		)
+	}
+
+	return allowed, refused
+}
@@ -0,0 +1,138 @@
+package scheduler
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"go.uber.org/zap"
+)
+
+func newBudgetTestConfig() *types.SchedulerConfig {
+	cfg := newTestConfig(3)
+	cfg.Setting.Problems = append(cfg.Setting.Problems, types.ProblemSetting{
+		MachineImageName: "image-sc1", ProblemID: "561d9876-7568-4096-b164-126cba6e4eb7", PoolCount: 3, MachineType: "n1-standard-2",
+	})
+	cfg.Setting.Problems[0].HourlyCost = 1
+	cfg.Setting.Budget.MachineTypes = map[string]float64{"n1-standard-2": 0.5}
+	return cfg
+}
+
+func Test_ApplyBudget(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxInstance  int
+		hourlyBudget float64
+		wantAllowed  map[string]int
+	}{
+		{name: "no budget", wantAllowed: map[string]int{"image-sc0": 3, "image-sc1": 3}},
+		// 問題ごとに1台ずつ順番に作成対象にする
+		{name: "max_instance", maxInstance: 4, wantAllowed: map[string]int{"image-sc0": 2, "image-sc1": 2}},
+		// image-sc0 は1台1.0、image-sc1 は1台0.5 (1.0 + 0.5 + 1.0 で2.5に達する)
+		{name: "hourly_budget", hourlyBudget: 2.5, wantAllowed: map[string]int{"image-sc0": 2, "image-sc1": 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newBudgetTestConfig()
+			cfg.Setting.Budget.MaxInstance = tt.maxInstance
+			cfg.Setting.Budget.HourlyBudget = tt.hourlyBudget
+
+			problems, zonePriorities := InitScheduler(cfg, zap.NewNop())
+			creations, _ := SchedulingList(problems, zonePriorities, zap.NewNop())
+			allowed, refused := ApplyBudget(creations, nil, problems, nil, cfg, zap.NewNop())
+
+			got := map[string]int{}
+			for _, c := range allowed {
+				got[c.ProblemName]++
+			}
+			for name, want := range tt.wantAllowed {
+				if got[name] != want {
+					t.Errorf("allowed %s = %d, want %d", name, got[name], want)
+				}
+			}
+			if len(allowed)+len(refused) != len(creations) {
+				t.Errorf("allowed + refused = %d, want %d", len(allowed)+len(refused), len(creations))
+			}
+		})
+	}
+}
+
+func Test_ApplyBudgetCountsAllEnvironments(t *testing.T) {
+	cfg := newBudgetTestConfig()
+	cfg.Setting.Budget.MaxInstance = 4
+
+	ready := newTestProblemEnvironment("image-sc0-aaa", "READY")
+	abandoned := newTestProblemEnvironment("image-sc0-bbb", "ABANDONED")
+	// 設定ファイルにない問題のインスタンスも max_instance に数える
+	old := newTestProblemEnvironment("image-old-ccc", "READY")
+	oldImage := "image-old"
+	old.MachineImageName = &oldImage
+	environments := types.NewEnvironments([]types.ProblemEnvironment{ready, abandoned, old})
+
+	problems, zonePriorities := InitScheduler(cfg, zap.NewNop())
+	problems, zonePriorities, abandonedInstances := Aggregate(problems, zonePriorities, environments, zap.NewNop())
+	creations, _ := SchedulingList(problems, zonePriorities, zap.NewNop())
+
+	tests := []struct {
+		name        string
+		deletions   []DeletionTargetInstance
+		wantAllowed int
+	}{
+		// 3台のうち ABANDONED の1台は削除されるため、あと2台作成できる
+		{name: "abandoned", deletions: abandonedInstances, wantAllowed: 2},
+		// 数えていないインスタンスの削除と、同じインスタンスの重複した削除では減らさない
+		{name: "uncounted and duplicated deletions", deletions: append(abandonedInstances,
+			DeletionTargetInstance{ProblemName: "image-sc0", InstanceName: "image-sc0-zzz"},
+			abandonedInstances[0],
+		), wantAllowed: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, refused := ApplyBudget(creations, tt.deletions, problems, environments, cfg, zap.NewNop())
+			if len(allowed) != tt.wantAllowed || len(allowed)+len(refused) != len(creations) {
+				t.Errorf("allowed %d, refused %d, want allowed %d of %d", len(allowed), len(refused), tt.wantAllowed, len(creations))
+			}
+		})
+	}
+
+	// 設定ファイルにない問題のインスタンスは最も高い費用(image-sc0 の1.0)で数える
+	cfg.Setting.Budget.MaxInstance = 0
+	cfg.Setting.Budget.HourlyBudget = 3
+	allowed, _ := ApplyBudget(creations, abandonedInstances, problems, environments, cfg, zap.NewNop())
+	cost := 0.0
+	for _, c := range allowed {
+		cost += problems[c.ProblemName].HourlyCost
+	}
+	if cost > 1+1e-9 {
+		t.Errorf("hourly cost of allowed creations = %f, want <= 1", cost)
+	}
+}
+
+func Test_EstimateCost(t *testing.T) {
+	cfg := newBudgetTestConfig()
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	a := newTestProblemEnvironment("image-sc0-aaa", "READY")
+	a.CreatedAt = start
+	b := newTestProblemEnvironment("image-sc0-bbb", "READY")
+	b.CreatedAt = start
+
+	// image-sc0-bbb は1時間後の記録にはない (削除された)
+	names := []string{"0.json", "1.json"}
+	records := map[string]*Record{
+		"0.json": {Time: start, ProblemEnvironments: []types.ProblemEnvironment{a, b}},
+		"1.json": {Time: start.Add(time.Hour), ProblemEnvironments: []types.ProblemEnvironment{a}},
+	}
+
+	report := EstimateCost(cfg, names, records, start.Add(2*time.Hour))
+	if len(report.Problems) != 1 || report.Problems[0].Instances != 2 || report.Running != 1 {
+		t.Fatalf("EstimateCost() = %#v", report)
+	}
+	// image-sc0-aaa が2時間、image-sc0-bbb が1時間
+	if math.Abs(report.Cost-3) > 1e-9 || math.Abs(report.RunningHourlyCost-1) > 1e-9 {
+		t.Errorf("Cost = %f, RunningHourlyCost = %f, want 3, 1", report.Cost, report.RunningHourlyCost)
+	}
+}
@@ -0,0 +1,152 @@
+package scheduler
+
+import (
+	"sort"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+)
+
+// ProblemCost 問題ごとの費用の見積もり
+type ProblemCost struct {
+	MachineImageName string  `json:"machine_image_name"`
+	Instances        int     `json:"instances"`
+	Running          int     `json:"running"`
+	InstanceHours    float64 `json:"instance_hours"`
+	HourlyCost       float64 `json:"hourly_cost"`
+	Cost             float64 `json:"cost"`
+}
+
+// ZoneCost Zoneごとの費用の見積もり
+type ZoneCost struct {
+	ProjectName   string  `json:"project"`
+	ZoneName      string  `json:"zone"`
+	Instances     int     `json:"instances"`
+	Running       int     `json:"running"`
+	InstanceHours float64 `json:"instance_hours"`
+	Cost          float64 `json:"cost"`
+}
+
+// CostReport scheduler start --record-dir の記録から見積もった費用
+type CostReport struct {
+	From     time.Time     `json:"from"`
+	To       time.Time     `json:"to"`
+	Problems []ProblemCost `json:"problems"`
+	Zones    []ZoneCost    `json:"zones"`
+	Cost     float64       `json:"cost"`
+	// 最後の記録に含まれているインスタンスの数と1時間あたりの費用
+	Running           int     `json:"running"`
+	RunningHourlyCost float64 `json:"running_hourly_cost"`
+	MaxInstance       int     `json:"max_instance"`
+	HourlyBudget      float64 `json:"hourly_budget"`
+}
+
+type instanceLifetime struct {
+	machineImageName string
+	project          string
+	zone             string
+	start            time.Time
+	end              time.Time
+	running          bool
+}
+
+// EstimateCost 記録に含まれるインスタンスの稼働時間と、設定ファイルの1時間あたりの費用から費用を見積もる
+// 稼働時間は作成日時から、記録に含まれなくなった最初の記録の時刻まで (最後の記録に含まれているインスタンスは now まで) とする
+func EstimateCost(cfg *types.SchedulerConfig, names []string, records map[string]*Record, now time.Time) *CostReport {
+	report := &CostReport{
+		Problems:     []ProblemCost{},
+		Zones:        []ZoneCost{},
+		MaxInstance:  cfg.Setting.Budget.MaxInstance,
+		HourlyBudget: cfg.Setting.Budget.HourlyBudget,
+	}
+
+	lifetimes := map[string]*instanceLifetime{}
+	for n, name := range names {
+		record := records[name]
+		if n == 0 {
+			report.From = record.Time
+		}
+
+		seen := map[string]bool{}
+		for _, e := range types.NewEnvironments(record.ProblemEnvironments) {
+			if e.MachineImageName == nil {
+				continue
+			}
+			seen[e.Name] = true
+			if _, ok := lifetimes[e.Name]; !ok {
+				lifetimes[e.Name] = &instanceLifetime{
+					machineImageName: *e.MachineImageName,
+					project:          e.ProjectName,
+					zone:             e.ZoneName,
+					start:            e.CreatedAt,
+				}
+			}
+			lifetimes[e.Name].running = true
+		}
+
+		// 前回の記録にあって今回の記録にないインスタンスは削除されたとみなす
+		for instanceName, l := range lifetimes {
+			if l.running && !seen[instanceName] {
+				l.running = false
+				l.end = record.Time
+			}
+		}
+	}
+	report.To = now
+
+	problems := map[string]*ProblemCost{}
+	zones := map[string]*ZoneCost{}
+	for _, l := range lifetimes {
+		if l.running {
+			l.end = now
+		}
+		hours := 0.0
+		if l.end.After(l.start) {
+			hours = l.end.Sub(l.start).Hours()
+		}
+		hourlyCost := cfg.Setting.HourlyCost(l.machineImageName)
+
+		p, ok := problems[l.machineImageName]
+		if !ok {
+			p = &ProblemCost{MachineImageName: l.machineImageName, HourlyCost: hourlyCost}
+			problems[l.machineImageName] = p
+		}
+		z, ok := zones[l.project+"/"+l.zone]
+		if !ok {
+			z = &ZoneCost{ProjectName: l.project, ZoneName: l.zone}
+			zones[l.project+"/"+l.zone] = z
+		}
+
+		p.Instances++
+		p.InstanceHours += hours
+		p.Cost += hours * hourlyCost
+		z.Instances++
+		z.InstanceHours += hours
+		z.Cost += hours * hourlyCost
+		report.Cost += hours * hourlyCost
+		if l.running {
+			p.Running++
+			z.Running++
+			report.Running++
+			report.RunningHourlyCost += hourlyCost
+		}
+	}
+
+	for _, p := range problems {
+		report.Problems = append(report.Problems, *p)
+	}
+	sort.Slice(report.Problems, func(i, j int) bool {
+		return report.Problems[i].MachineImageName < report.Problems[j].MachineImageName
+	})
+	for _, z := range zones {
+		report.Zones = append(report.Zones, *z)
+	}
+	sort.Slice(report.Zones, func(i, j int) bool {
+		if report.Zones[i].ProjectName != report.Zones[j].ProjectName {
+			return report.Zones[i].ProjectName < report.Zones[j].ProjectName
+		}
+		return report.Zones[i].ZoneName < report.Zones[j].ZoneName
+	})
+
+	return report
+}
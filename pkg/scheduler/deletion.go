@@ -0,0 +1,127 @@
+package scheduler
+
+import (
+	"sort"
+
+	"golang.org/x/xerrors"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+)
+
+const (
+	// DeletionPolicyNewest 作成日時が新しいインスタンスから削除する (デフォルト)
+	// インスタンスを作成してからインスタンス内部でプロビジョニングを行っているため、新しいインスタンスほど使われていない
+	DeletionPolicyNewest = "newest"
+	// DeletionPolicyOldest 作成日時が古いインスタンスから削除する
+	DeletionPolicyOldest = "oldest"
+	// DeletionPolicyNotReadyFirst NOT_READYなインスタンスから削除する (NOT_READY同士、READY同士は新しい順)
+	DeletionPolicyNotReadyFirst = "not_ready_first"
+	// DeletionPolicyLowPriorityZone 優先度の低いZone(priorityの値が大きいZone)のインスタンスから削除する
+	DeletionPolicyLowPriorityZone = "low_priority_zone"
+	// DeletionPolicyBusiestZone max_instance に対するインスタンス数の割合が大きいZoneのインスタンスから削除する
+	DeletionPolicyBusiestZone = "busiest_zone"
+)
+
+// DeletionPolicy pool_count を超えたインスタンスのうち、どれを削除するかを決める
+type DeletionPolicy interface {
+	// Order candidates を削除する順に並び替える
+	// candidates は作成日時が新しい順に並んでいる
+	Order(candidates []Instance, zonePriorities []*ZonePriority) []Instance
+	// NotReady trueの場合はNOT_READYなインスタンスも削除の候補にする
+	NotReady() bool
+}
+
+// DeletionPolicyFunc Order だけを実装する DeletionPolicy
+type DeletionPolicyFunc func(candidates []Instance, zonePriorities []*ZonePriority) []Instance
+
+// Order candidates を削除する順に並び替える
+func (f DeletionPolicyFunc) Order(candidates []Instance, zonePriorities []*ZonePriority) []Instance {
+	return f(candidates, zonePriorities)
+}
+
+// NotReady READYなインスタンスのみを削除の候補にする
+func (f DeletionPolicyFunc) NotReady() bool {
+	return false
+}
+
+type notReadyFirstPolicy struct{}
+
+func (notReadyFirstPolicy) Order(candidates []Instance, zonePriorities []*ZonePriority) []Instance {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].InnerStatus == types.InnerStatusNotReady && candidates[j].InnerStatus != types.InnerStatusNotReady
+	})
+	return candidates
+}
+
+func (notReadyFirstPolicy) NotReady() bool {
+	return true
+}
+
+var deletionPolicies = map[string]DeletionPolicy{
+	DeletionPolicyNewest: DeletionPolicyFunc(func(candidates []Instance, _ []*ZonePriority) []Instance {
+		return candidates
+	}),
+	DeletionPolicyOldest: DeletionPolicyFunc(func(candidates []Instance, _ []*ZonePriority) []Instance {
+		sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].CreatedAt.Before(candidates[j].CreatedAt) })
+		return candidates
+	}),
+	DeletionPolicyNotReadyFirst: notReadyFirstPolicy{},
+	DeletionPolicyLowPriorityZone: DeletionPolicyFunc(func(candidates []Instance, zonePriorities []*ZonePriority) []Instance {
+		priority := func(instance Instance) int {
+			if zp := findZone(zonePriorities, instance); zp != nil {
+				return zp.Priority
+			}
+			return 0
+		}
+		sort.SliceStable(candidates, func(i, j int) bool { return priority(candidates[i]) > priority(candidates[j]) })
+		return candidates
+	}),
+	DeletionPolicyBusiestZone: DeletionPolicyFunc(func(candidates []Instance, zonePriorities []*ZonePriority) []Instance {
+		load := func(instance Instance) float64 {
+			if zp := findZone(zonePriorities, instance); zp != nil && zp.MaxInstance > 0 {
+				return float64(zp.CurrentInstance) / float64(zp.MaxInstance)
+			}
+			return 0
+		}
+		sort.SliceStable(candidates, func(i, j int) bool { return load(candidates[i]) > load(candidates[j]) })
+		return candidates
+	}),
+}
+
+// RegisterDeletionPolicy name で DeletionPolicy を登録する
+// 設定ファイルの deletion_policy で name を指定すると使われる
+func RegisterDeletionPolicy(name string, policy DeletionPolicy) {
+	deletionPolicies[name] = policy
+}
+
+// LookupDeletionPolicy name で登録されている DeletionPolicy を返す
+// name が空の場合は DeletionPolicyNewest を返す
+func LookupDeletionPolicy(name string) (DeletionPolicy, error) {
+	if name == "" {
+		name = DeletionPolicyNewest
+	}
+	policy, ok := deletionPolicies[name]
+	if !ok {
+		return nil, xerrors.Errorf("unknown deletion_policy: %s", name)
+	}
+	return policy, nil
+}
+
+// ValidateDeletionPolicies 設定ファイルの deletion_policy が全て登録されているかを確認する
+func ValidateDeletionPolicies(cfg *types.SchedulerConfig) error {
+	for _, p := range cfg.Setting.Problems {
+		if _, err := LookupDeletionPolicy(p.DeletionPolicy); err != nil {
+			return xerrors.Errorf("problem %s: %w", p.MachineImageName, err)
+		}
+	}
+	return nil
+}
+
+func findZone(zonePriorities []*ZonePriority, instance Instance) *ZonePriority {
+	for _, zp := range zonePriorities {
+		if zp.ProjectName == instance.ProjectName && zp.ZoneName == instance.ZoneName {
+			return zp
+		}
+	}
+	return nil
+}
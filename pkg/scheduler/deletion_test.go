@@ -0,0 +1,80 @@
+package scheduler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"go.uber.org/zap"
+)
+
+func Test_DeletionPolicy(t *testing.T) {
+	base := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		policy string
+		want   []string
+	}{
+		{policy: "", want: []string{"ready-3", "ready-2"}},
+		{policy: DeletionPolicyOldest, want: []string{"ready-0", "ready-1"}},
+		{policy: DeletionPolicyNotReadyFirst, want: []string{"not-ready-0", "ready-3"}},
+		// zone-b は priority が低い
+		{policy: DeletionPolicyLowPriorityZone, want: []string{"ready-3", "ready-1"}},
+		// zone-a は max_instance に対するインスタンス数の割合が大きい
+		{policy: DeletionPolicyBusiestZone, want: []string{"ready-2", "ready-0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			cfg := newTestConfig(3)
+			cfg.Setting.Problems[0].DeletionPolicy = tt.policy
+			if err := ValidateDeletionPolicies(cfg); err != nil {
+				t.Fatal(err)
+			}
+			problems, _ := InitScheduler(cfg, zap.NewNop())
+			zonePriorities := []*ZonePriority{
+				{ProjectName: "p", ZoneName: "zone-a", Priority: 1, MaxInstance: 4, CurrentInstance: 4},
+				{ProjectName: "p", ZoneName: "zone-b", Priority: 2, MaxInstance: 10, CurrentInstance: 1},
+			}
+
+			problem := problems["image-sc0"]
+			// ready-0 (zone-a) が最も古く、ready-3 (zone-b) が最も新しい
+			for i, zone := range []string{"zone-a", "zone-b", "zone-a", "zone-b"} {
+				problem.Ready++
+				problem.KeptInstances = append(problem.KeptInstances, Instance{
+					InstanceName: fmt.Sprintf("ready-%d", i),
+					ProjectName:  "p",
+					ZoneName:     zone,
+					InnerStatus:  types.InnerStatusReady,
+					CreatedAt:    base.Add(time.Duration(i) * time.Minute),
+				})
+			}
+			problem.NotReady++
+			problem.NotReadyInstances = append(problem.NotReadyInstances, Instance{
+				InstanceName: "not-ready-0",
+				ProjectName:  "p",
+				ZoneName:     "zone-a",
+				InnerStatus:  types.InnerStatusNotReady,
+				CreatedAt:    base,
+			})
+
+			_, deletions := SchedulingList(problems, zonePriorities, zap.NewNop())
+
+			got := []string{}
+			for _, d := range deletions {
+				got = append(got, d.InstanceName)
+				if want := tt.policy; want != "" && d.Policy != want {
+					t.Errorf("Policy = %s, want %s", d.Policy, want)
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("deletions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if err := ValidateDeletionPolicies(&types.SchedulerConfig{Setting: types.Setting{Problems: []types.ProblemSetting{{DeletionPolicy: "random"}}}}); err == nil {
+		t.Errorf("ValidateDeletionPolicies() should fail for unknown policy")
+	}
+}
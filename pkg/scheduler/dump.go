@@ -14,7 +14,7 @@ func Dump(cfg *types.SchedulerConfig, ssClient *scoreserver.Client, vmmsClient *
 	// ScoreServer からデータを取得し、現在のインスタンス状況を集計する
 	problems, zonePriorities, _, err := AggregateInstance(problems, zonePriorities, ssClient, lg)
 	if err != nil {
-		lg.Error("Scheduler Aggregate: " + err.Error())
+		lg.Error("Scheduler: Aggregate failed", zap.Error(err))
 		return nil, nil, err
 	}
 
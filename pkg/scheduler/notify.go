@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/notifier"
+)
+
+// NotifyAggregation 集計結果に対して通知ルールを評価する
+// - 問題のReadyなインスタンスが0台
+// - Zoneのインスタンス数が上限に達している
+// - NOT_READYのまま NotReadyTimeout を超えたインスタンスがある
+func NotifyAggregation(problems map[string]*Problem, zonePriorities []*ZonePriority, nt *notifier.Notifier, now time.Time) {
+	if nt == nil {
+		return
+	}
+
+	for name, problem := range problems {
+		nt.Observe(
+			notifier.RulePoolEmpty,
+			notifier.RulePoolEmpty+"/"+name,
+			problem.PoolCount > 0 && problem.Ready == 0,
+			fmt.Sprintf("problem %s has no READY instance (pool_count: %d, not_ready: %d)", name, problem.PoolCount, problem.NotReady),
+		)
+
+		stuck := []string{}
+		for _, instance := range problem.NotReadyInstances {
+			if now.Sub(instance.CreatedAt) > nt.NotReadyTimeout {
+				stuck = append(stuck, instance.InstanceName)
+			}
+		}
+		nt.Observe(
+			notifier.RuleStuckNotReady,
+			notifier.RuleStuckNotReady+"/"+name,
+			len(stuck) > 0,
+			fmt.Sprintf("problem %s has %d instance(s) stuck in NOT_READY for more than %s: %v", name, len(stuck), nt.NotReadyTimeout, stuck),
+		)
+	}
+
+	for _, zp := range zonePriorities {
+		nt.Observe(
+			notifier.RuleZoneFull,
+			notifier.RuleZoneFull+"/"+zp.ProjectName+"/"+zp.ZoneName,
+			zp.MaxInstance > 0 && zp.CurrentInstance >= zp.MaxInstance,
+			fmt.Sprintf("zone %s/%s is full (%d/%d)", zp.ProjectName, zp.ZoneName, zp.CurrentInstance, zp.MaxInstance),
+		)
+	}
+}
+
+// NotifyCreation CreateInstances の結果から連続した作成失敗を検知する
+func NotifyCreation(nt *notifier.Notifier, err error) {
+	if nt == nil {
+		return
+	}
+
+	if err == nil {
+		nt.ResetFailure(notifier.RuleCreateFailure)
+		nt.Observe(notifier.RuleCreateFailure, notifier.RuleCreateFailure, false, "")
+		return
+	}
+
+	count := nt.RecordFailure(notifier.RuleCreateFailure)
+	nt.Observe(
+		notifier.RuleCreateFailure,
+		notifier.RuleCreateFailure,
+		count >= nt.CreateFailureThreshold,
+		fmt.Sprintf("instance creation failed %d times in a row: %s", count, err.Error()),
+	)
+}
+
+// NotifyBudget budget を超えるため作成を見送ったインスタンスがあれば通知する
+func NotifyBudget(nt *notifier.Notifier, refused []CreationTargetInstance) {
+	if nt == nil {
+		return
+	}
+
+	names := []string{}
+	for _, i := range refused {
+		names = append(names, i.ProblemName)
+	}
+	nt.Observe(
+		notifier.RuleBudgetExceeded,
+		notifier.RuleBudgetExceeded,
+		len(refused) > 0,
+		fmt.Sprintf("%d instance creation(s) refused by budget: %v", len(refused), names),
+	)
+}
@@ -0,0 +1,188 @@
+package scheduler
+
+import (
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/janog-netcon/netcon-cli/pkg/logging"
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"github.com/janog-netcon/netcon-cli/pkg/vmms"
+)
+
+const (
+	defaultPendingCreationTimeout = 5 * time.Minute
+	// 作成を要求した時刻と、スコアサーバが返す作成日時のずれの許容範囲
+	pendingClockSkew = time.Minute
+)
+
+// PendingCreation 作成を要求したが、まだ問題環境情報に現れていないインスタンス
+type PendingCreation struct {
+	// vm-management-serverに送った冪等キー
+	Key              string
+	ProblemName      string
+	ProblemID        string
+	MachineImageName string
+	ProjectName      string
+	ZoneName         string
+	// 作成に成功した場合のインスタンス名 (タイムアウトなどで結果が分からない場合は空)
+	InstanceName string `json:",omitempty"`
+	RequestedAt  time.Time
+}
+
+// PendingCreations 作成を要求したインスタンスを、問題環境情報に現れるまで冪等キーごとに保持する
+// 保持している間は作成中のインスタンスとして数え、同じインスタンスを重複して作成しないようにする
+type PendingCreations struct {
+	pending map[string]*PendingCreation
+	// 結果が分からないまま作成を要求したインスタンスとみなした、問題環境情報のインスタンス
+	// (同じインスタンスを複数の PendingCreation に対応させないために保持する)
+	claimed map[string]bool
+}
+
+// NewPendingCreations 空の PendingCreations を返す
+func NewPendingCreations() *PendingCreations {
+	return &PendingCreations{
+		pending: map[string]*PendingCreation{},
+		claimed: map[string]bool{},
+	}
+}
+
+// add 作成を要求する前に登録し、冪等キーを払い出す
+// PendingCreations がnilの場合も冪等キーは払い出す
+func (pc *PendingCreations) add(target CreationTargetInstance, project, zone string, now time.Time) *PendingCreation {
+	p := &PendingCreation{
+		Key:              vmms.NewIdempotencyKey(),
+		ProblemName:      target.ProblemName,
+		ProblemID:        target.ProblemID,
+		MachineImageName: target.MachineImageName,
+		ProjectName:      project,
+		ZoneName:         zone,
+		RequestedAt:      now,
+	}
+	if pc != nil {
+		pc.pending[p.Key] = p
+	}
+	return p
+}
+
+// created 作成に成功したインスタンス名を記録する
+// 問題環境情報に現れるまでは作成中のインスタンスとして数え続ける
+func (pc *PendingCreations) created(key, instanceName string) {
+	if pc == nil {
+		return
+	}
+	if p, ok := pc.pending[key]; ok {
+		p.InstanceName = instanceName
+	}
+}
+
+// remove 作成されていないことが分かったインスタンスを取り除く
+func (pc *PendingCreations) remove(key string) {
+	if pc == nil {
+		return
+	}
+	delete(pc.pending, key)
+}
+
+// Resolve 問題環境情報に現れたインスタンスと、timeout を過ぎたインスタンスを取り除き、残りを返す
+// 結果が分からないインスタンスは、同じ問題・Zoneで要求した時刻以降に作成されたインスタンスが現れたら作成されたとみなす
+func (pc *PendingCreations) Resolve(environments []types.Environment, now time.Time, timeout time.Duration, lg *zap.Logger) []PendingCreation {
+	if pc == nil {
+		return []PendingCreation{}
+	}
+	if timeout <= 0 {
+		timeout = defaultPendingCreationTimeout
+	}
+
+	present := map[string]types.Environment{}
+	for _, e := range environments {
+		present[e.Name] = e
+	}
+	for name := range pc.claimed {
+		if _, ok := present[name]; !ok {
+			delete(pc.claimed, name)
+		}
+	}
+
+	// インスタンス名が分かっているもの
+	for key, p := range pc.pending {
+		if p.InstanceName == "" {
+			continue
+		}
+		if _, ok := present[p.InstanceName]; ok {
+			pc.claimed[p.InstanceName] = true
+			delete(pc.pending, key)
+		}
+	}
+
+	// 結果が分からないもの (要求した順に、作成日時が古いインスタンスから対応させる)
+	candidates := append([]types.Environment{}, environments...)
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].CreatedAt.Before(candidates[j].CreatedAt)
+	})
+	for _, p := range pc.sorted() {
+		if p.InstanceName != "" {
+			continue
+		}
+		for _, e := range candidates {
+			if pc.claimed[e.Name] || e.MachineImageName == nil || *e.MachineImageName != p.MachineImageName ||
+				e.ProjectName != p.ProjectName || e.ZoneName != p.ZoneName || e.CreatedAt.Before(p.RequestedAt.Add(-pendingClockSkew)) {
+				continue
+			}
+			lg.Info("PendingCreation: Instance appeared", logging.Problem(p.ProblemName), logging.Instance(e.Name), zap.String("idempotency_key", p.Key))
+			pc.claimed[e.Name] = true
+			delete(pc.pending, p.Key)
+			break
+		}
+	}
+
+	for key, p := range pc.pending {
+		if now.Sub(p.RequestedAt) > timeout {
+			lg.Warn("PendingCreation: Instance did not appear, give up waiting",
+				logging.Problem(p.ProblemName),
+				logging.Instance(p.InstanceName),
+				logging.Project(p.ProjectName),
+				logging.Zone(p.ZoneName),
+				zap.String("idempotency_key", p.Key),
+				zap.Duration("timeout", timeout),
+			)
+			delete(pc.pending, key)
+		}
+	}
+
+	pending := []PendingCreation{}
+	for _, p := range pc.sorted() {
+		pending = append(pending, *p)
+	}
+	return pending
+}
+
+func (pc *PendingCreations) sorted() []*PendingCreation {
+	pending := []*PendingCreation{}
+	for _, p := range pc.pending {
+		pending = append(pending, p)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if !pending[i].RequestedAt.Equal(pending[j].RequestedAt) {
+			return pending[i].RequestedAt.Before(pending[j].RequestedAt)
+		}
+		return pending[i].Key < pending[j].Key
+	})
+	return pending
+}
+
+// ApplyPending 作成中のインスタンスを、問題の Pending とZoneの CurrentInstance に数える
+func ApplyPending(pending []PendingCreation, problems map[string]*Problem, zonePriorities []*ZonePriority) {
+	for _, p := range pending {
+		if problem, ok := problems[p.ProblemName]; ok {
+			problem.Pending++
+			problem.CurrentInstance++
+		}
+		for _, zp := range zonePriorities {
+			if zp.ProjectName == p.ProjectName && zp.ZoneName == p.ZoneName {
+				zp.CurrentInstance++
+			}
+		}
+	}
+}
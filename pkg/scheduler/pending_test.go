@@ -0,0 +1,96 @@
+package scheduler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"github.com/janog-netcon/netcon-cli/pkg/vmms"
+	"go.uber.org/zap"
+)
+
+// testInstanceClient 作成するたびに errs の先頭のエラーを返す InstanceClient
+type testInstanceClient struct {
+	errs    []error
+	keys    []string
+	deleted []string
+}
+
+func (c *testInstanceClient) CreateInstance(idempotencyKey, problemID, machineImageName, project, zone string) (*types.Instance, error) {
+	c.keys = append(c.keys, idempotencyKey)
+	err := c.errs[0]
+	c.errs = c.errs[1:]
+	if err != nil {
+		return nil, err
+	}
+	return &types.Instance{InstanceName: "image-sc0-aaa"}, nil
+}
+
+func (c *testInstanceClient) DeleteInstance(name, project, zone string) error {
+	c.deleted = append(c.deleted, name)
+	return nil
+}
+
+func Test_PendingCreations(t *testing.T) {
+	cfg := newTestConfig(3)
+	lg := zap.NewNop()
+	pc := NewPendingCreations()
+
+	tick := func(pes []types.ProblemEnvironment, now time.Time) ([]CreationTargetInstance, []*ZonePriority) {
+		problems, zonePriorities := InitScheduler(cfg, lg)
+		environments := types.NewEnvironments(pes)
+		problems, zonePriorities, _ = Aggregate(problems, zonePriorities, environments, lg)
+		ApplyPending(pc.Resolve(environments, now, 0, lg), problems, zonePriorities)
+		creations, _ := SchedulingList(problems, zonePriorities, lg)
+		return creations, zonePriorities
+	}
+
+	// 1台目は作成に成功し、2台目はタイムアウトして結果が分からず、3台目は作成されなかった
+	creations, zonePriorities := tick(nil, time.Now())
+	if len(creations) != 3 {
+		t.Fatalf("creations = %d, want 3", len(creations))
+	}
+	cli := &testInstanceClient{errs: []error{
+		nil,
+		&vmms.StatusError{StatusCode: http.StatusGatewayTimeout},
+		&vmms.StatusError{StatusCode: http.StatusBadRequest},
+	}}
+	if err := CreateInstances(creations, zonePriorities, cli, pc, 0, nil, lg); err == nil {
+		t.Fatal("CreateInstances() should return error")
+	}
+	cli.errs = []error{&vmms.StatusError{StatusCode: http.StatusBadRequest}}
+	if err := CreateInstances(creations[2:], zonePriorities, cli, pc, 0, nil, lg); err == nil {
+		t.Fatal("CreateInstances() should return error")
+	}
+	if cli.keys[0] == "" || cli.keys[0] == cli.keys[1] {
+		t.Errorf("idempotency keys should be unique: %v", cli.keys)
+	}
+
+	// 問題環境情報に現れるまでは作成中として数え、作成されなかった1台だけを作り直す
+	creations, zonePriorities = tick(nil, time.Now())
+	if len(creations) != 1 {
+		t.Errorf("creations = %d, want 1 while 2 instances are pending", len(creations))
+	}
+	if zonePriorities[0].CurrentInstance != 2 {
+		t.Errorf("zone CurrentInstance = %d, want 2", zonePriorities[0].CurrentInstance)
+	}
+
+	// 作成に成功したインスタンスと、タイムアウトしたが作成されていたインスタンスが現れた
+	aaa := newTestProblemEnvironment("image-sc0-aaa", "NOT_READY")
+	aaa.CreatedAt = time.Now()
+	bbb := newTestProblemEnvironment("image-sc0-bbb", "NOT_READY")
+	bbb.CreatedAt = time.Now()
+	if pending := pc.Resolve(types.NewEnvironments([]types.ProblemEnvironment{aaa, bbb}), time.Now(), 0, lg); len(pending) != 0 {
+		t.Errorf("pending = %v, want none", pending)
+	}
+
+	// 現れないまま timeout を過ぎたら作成中として数えない
+	pc.add(creations[0], "networkcontest", "asia-northeast1-b", time.Now())
+	if pending := pc.Resolve(nil, time.Now(), time.Minute, lg); len(pending) != 1 {
+		t.Errorf("pending = %v, want 1", pending)
+	}
+	if pending := pc.Resolve(nil, time.Now().Add(2*time.Minute), time.Minute, lg); len(pending) != 0 {
+		t.Errorf("pending = %v, want none after timeout", pending)
+	}
+}
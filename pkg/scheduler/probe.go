@@ -0,0 +1,65 @@
+package scheduler
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/janog-netcon/netcon-cli/pkg/logging"
+	"github.com/janog-netcon/netcon-cli/pkg/prober"
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+)
+
+// ProbeInstances READYなインスタンスの各サービスに接続できるかを確認する
+// 連続して接続できなかったインスタンスは Ready から除いて Unhealthy として数え、削除対象として返す
+// (Readyが減るため、SchedulingList で代わりのインスタンスが作成される)
+func ProbeInstances(problems map[string]*Problem, pr *prober.Prober, lg *zap.Logger) []DeletionTargetInstance {
+	unhealthyInstances := []DeletionTargetInstance{}
+	if pr == nil {
+		return unhealthyInstances
+	}
+
+	lg.Info("Scheduler: ProbeInstances")
+
+	targets := map[string][]types.Endpoint{}
+	for _, problem := range problems {
+		for _, instance := range problem.KeptInstances {
+			if instance.InnerStatus == types.InnerStatusReady {
+				targets[instance.InstanceName] = instance.Services
+			}
+		}
+	}
+	pr.Probe(targets)
+
+	return markUnhealthy(problems, func(instance Instance) bool {
+		return pr.Unhealthy(instance.InstanceName)
+	}, lg)
+}
+
+// markUnhealthy unhealthy がtrueを返すREADYなインスタンスを Ready から除いて Unhealthy として数え、削除対象として返す
+// scheduler replay では記録された削除対象を使って同じ処理を行う
+func markUnhealthy(problems map[string]*Problem, unhealthy func(Instance) bool, lg *zap.Logger) []DeletionTargetInstance {
+	unhealthyInstances := []DeletionTargetInstance{}
+
+	for name, problem := range problems {
+		kept := []Instance{}
+		for _, instance := range problem.KeptInstances {
+			if instance.InnerStatus != types.InnerStatusReady || !unhealthy(instance) {
+				kept = append(kept, instance)
+				continue
+			}
+
+			lg.Warn("Scheduler: ProbeInstances. Replace unhealthy instance", logging.Problem(name), logging.Instance(instance.InstanceName))
+			problem.Ready--
+			problem.Unhealthy++
+			problem.UnhealthyInstances = append(problem.UnhealthyInstances, instance)
+			unhealthyInstances = append(unhealthyInstances, DeletionTargetInstance{
+				ProblemName:  name,
+				InstanceName: instance.InstanceName,
+				ProjectName:  instance.ProjectName,
+				ZoneName:     instance.ZoneName,
+			})
+		}
+		problem.KeptInstances = kept
+	}
+
+	return unhealthyInstances
+}
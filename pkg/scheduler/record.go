@@ -0,0 +1,221 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"golang.org/x/xerrors"
+)
+
+// Actions 1回の実行でschedulerが計画した作成・削除
+type Actions struct {
+	// ABANDONEDなため削除するインスタンス
+	Abandoned []DeletionTargetInstance `json:"abandoned"`
+	// Proberで接続できなかったため削除するインスタンス
+	Unhealthy []DeletionTargetInstance `json:"unhealthy"`
+	// pool_count を超えているため削除するインスタンス
+	Deletions []DeletionTargetInstance `json:"deletions"`
+	Creations []CreationTargetInstance `json:"creations"`
+	// budget を超えるため作成を見送ったインスタンス
+	Refused []CreationTargetInstance `json:"refused,omitempty"`
+}
+
+// Record 1回の実行の記録
+// スコアサーバの /problem-environments のレスポンスと、それに対してschedulerが計画した作成・削除を保存する
+type Record struct {
+	Time                time.Time                  `json:"time"`
+	ProblemEnvironments []types.ProblemEnvironment `json:"problem_environments"`
+	// 記録した時点で作成中だったインスタンス
+	Pending []PendingCreation `json:"pending,omitempty"`
+	Actions Actions           `json:"actions"`
+}
+
+// Recorder schedulerの実行ごとに Record をディレクトリに保存する
+type Recorder struct {
+	Dir string
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewRecorder dir に記録するRecorderを返す
+// dir が空の場合はnilを返す (nilのRecorderは何も記録しない)
+func NewRecorder(dir string) (*Recorder, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Recorder{Dir: dir}, nil
+}
+
+// Save Record をjsonで保存する
+// パスワードは記録しない
+func (r *Recorder) Save(record *Record) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	redacted := *record
+	redacted.ProblemEnvironments = []types.ProblemEnvironment{}
+	for _, pe := range record.ProblemEnvironments {
+		redacted.ProblemEnvironments = append(redacted.ProblemEnvironments, pe.Redacted())
+	}
+
+	b, err := json.MarshalIndent(&redacted, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	r.seq++
+	name := fmt.Sprintf("%s-%06d.json", record.Time.UTC().Format("20060102T150405.000Z"), r.seq)
+
+	// 書き込み途中のファイルを replay で読まないように、一時ファイルに書いてからrenameする
+	tmp := filepath.Join(r.Dir, "."+name+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(r.Dir, name))
+}
+
+// LoadRecords dir に保存された Record を時刻順に読み込む
+// 返り値のmapのkeyはファイル名
+func LoadRecords(dir string) ([]string, map[string]*Record, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(paths)
+
+	names := []string{}
+	records := map[string]*Record{}
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		record := &Record{}
+		if err := json.Unmarshal(b, record); err != nil {
+			return nil, nil, xerrors.Errorf("%s: %w", path, err)
+		}
+		name := filepath.Base(path)
+		names = append(names, name)
+		records[name] = record
+	}
+
+	return names, records, nil
+}
+
+// Replay 記録された /problem-environments のレスポンスに対して、現在の設定で集計と SchedulingList を行い、計画される作成・削除を返す
+// Proberの結果は再現できないため、記録されている Unhealthy なインスタンスをそのまま使う
+func Replay(cfg *types.SchedulerConfig, record *Record, lg *zap.Logger) Actions {
+	problems, zonePriorities := InitScheduler(cfg, lg)
+	environments := types.NewEnvironments(record.ProblemEnvironments)
+	problems, zonePriorities, abandonedInstances := Aggregate(problems, zonePriorities, environments, lg)
+	ApplyPending(record.Pending, problems, zonePriorities)
+
+	recordedUnhealthy := map[string]bool{}
+	for _, i := range record.Actions.Unhealthy {
+		recordedUnhealthy[i.InstanceName] = true
+	}
+	unhealthyInstances := markUnhealthy(problems, func(instance Instance) bool {
+		return recordedUnhealthy[instance.InstanceName]
+	}, lg)
+	ExpireInstances(problems, record.Time, lg)
+
+	creationTargetInstances, deletionTargetInstances := SchedulingList(problems, zonePriorities, lg)
+	creationTargetInstances, refusedInstances := ApplyBudget(creationTargetInstances, allDeletions(abandonedInstances, unhealthyInstances, deletionTargetInstances), problems, environments, cfg, lg)
+
+	return Actions{
+		Abandoned: abandonedInstances,
+		Unhealthy: unhealthyInstances,
+		Deletions: deletionTargetInstances,
+		Creations: creationTargetInstances,
+		Refused:   refusedInstances,
+	}
+}
+
+// DiffActions 記録された作成・削除と再計算した作成・削除の差分を返す
+// SchedulingList は問題をmapで扱うため順番は比較せず、削除はインスタンス名、作成は問題ごとの数で比較する
+// 差分は "+" (再計算で増えた) と "-" (再計算で減った) で始まる文字列になる
+func DiffActions(recorded, replayed Actions) []string {
+	diff := []string{}
+	diff = append(diff, diffDeletions("abandoned", recorded.Abandoned, replayed.Abandoned)...)
+	diff = append(diff, diffDeletions("unhealthy", recorded.Unhealthy, replayed.Unhealthy)...)
+	diff = append(diff, diffDeletions("delete", recorded.Deletions, replayed.Deletions)...)
+
+	count := func(instances []CreationTargetInstance) map[string]int {
+		m := map[string]int{}
+		for _, i := range instances {
+			m[i.ProblemName]++
+		}
+		return m
+	}
+	recordedCreations, replayedCreations := count(recorded.Creations), count(replayed.Creations)
+	for _, name := range unionKeys(recordedCreations, replayedCreations) {
+		r, p := recordedCreations[name], replayedCreations[name]
+		switch {
+		case p > r:
+			diff = append(diff, fmt.Sprintf("+ create %s x%d (recorded %d, replayed %d)", name, p-r, r, p))
+		case p < r:
+			diff = append(diff, fmt.Sprintf("- create %s x%d (recorded %d, replayed %d)", name, r-p, r, p))
+		}
+	}
+
+	return diff
+}
+
+func diffDeletions(kind string, recorded, replayed []DeletionTargetInstance) []string {
+	// 削除の理由 (Policy) は比較せず、差分の表示にのみ使う
+	policies := map[string]string{}
+	toMap := func(instances []DeletionTargetInstance) map[string]int {
+		m := map[string]int{}
+		for _, i := range instances {
+			key := i.ProblemName + " " + i.InstanceName
+			m[key]++
+			if i.Policy != "" {
+				policies[key] = " (policy: " + i.Policy + ")"
+			}
+		}
+		return m
+	}
+	r, p := toMap(recorded), toMap(replayed)
+
+	diff := []string{}
+	for _, key := range unionKeys(r, p) {
+		switch {
+		case p[key] > r[key]:
+			diff = append(diff, fmt.Sprintf("+ %s %s%s", kind, key, policies[key]))
+		case p[key] < r[key]:
+			diff = append(diff, fmt.Sprintf("- %s %s%s", kind, key, policies[key]))
+		}
+	}
+	return diff
+}
+
+func unionKeys(a, b map[string]int) []string {
+	keys := []string{}
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
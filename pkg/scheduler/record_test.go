@@ -0,0 +1,85 @@
+package scheduler
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"go.uber.org/zap"
+)
+
+func newTestConfig(poolCount int) *types.SchedulerConfig {
+	cfg := &types.SchedulerConfig{}
+	cfg.Setting.Projects = []types.ProjectSetting{
+		{Name: "networkcontest", Zones: []types.ZoneSetting{{Name: "asia-northeast1-b", MaxInstance: 10, Priority: 1}}},
+	}
+	cfg.Setting.Problems = []types.ProblemSetting{
+		{MachineImageName: "image-sc0", ProblemID: "227803fb-2fe1-4b89-a805-79e7679bf030", PoolCount: poolCount},
+	}
+	return cfg
+}
+
+func newTestProblemEnvironment(name, innerStatus string) types.ProblemEnvironment {
+	image := "image-sc0"
+	return types.ProblemEnvironment{
+		ID:               uuid.Must(uuid.NewV4()),
+		InnerStatus:      &innerStatus,
+		Host:             "192.0.2.1",
+		Password:         "secret",
+		ProblemID:        "227803fb-2fe1-4b89-a805-79e7679bf030",
+		ProjectName:      "networkcontest",
+		ZoneName:         "asia-northeast1-b",
+		Name:             name,
+		MachineImageName: &image,
+	}
+}
+
+func Test_RecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rec, err := NewRecorder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pes := []types.ProblemEnvironment{
+		newTestProblemEnvironment("image-sc0-aaa", "READY"),
+		newTestProblemEnvironment("image-sc0-bbb", "READY"),
+	}
+	cfg := newTestConfig(2)
+	actions := Replay(cfg, &Record{ProblemEnvironments: pes}, zap.NewNop())
+	if err := rec.Save(&Record{Time: time.Now(), ProblemEnvironments: pes, Actions: actions}); err != nil {
+		t.Fatal(err)
+	}
+
+	names, records, err := LoadRecords(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 {
+		t.Fatalf("LoadRecords() = %v, want 1 record", names)
+	}
+	record := records[names[0]]
+	if record.ProblemEnvironments[0].Password != types.RedactedSecret {
+		t.Errorf("password should be redacted, got %q", record.ProblemEnvironments[0].Password)
+	}
+
+	// 同じ設定では差分は出ない
+	if diff := DiffActions(record.Actions, Replay(cfg, record, zap.NewNop())); len(diff) != 0 {
+		t.Errorf("DiffActions() = %v, want no diff", diff)
+	}
+
+	// pool_count を増やすと作成が増える
+	diff := DiffActions(record.Actions, Replay(newTestConfig(3), record, zap.NewNop()))
+	if len(diff) != 1 || !strings.HasPrefix(diff[0], "+ create image-sc0 x1") {
+		t.Errorf("DiffActions() = %v, want 1 more creation", diff)
+	}
+}
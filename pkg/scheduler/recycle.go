@@ -0,0 +1,36 @@
+package scheduler
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/janog-netcon/netcon-cli/pkg/logging"
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+)
+
+// PolicyMaxReadyAge max_ready_age を超えたため削除するインスタンスの DeletionTargetInstance.Policy
+const PolicyMaxReadyAge = "max_ready_age"
+
+// ExpireInstances READYなまま MaxReadyAge を超えたインスタンスを KeptInstances から除いて Expired として数える
+// Ready からは除かないため、SchedulingList で代わりのインスタンスを作成し、代わりがREADYになってから削除される
+func ExpireInstances(problems map[string]*Problem, now time.Time, lg *zap.Logger) {
+	for name, problem := range problems {
+		if problem.MaxReadyAge <= 0 {
+			continue
+		}
+
+		kept := []Instance{}
+		for _, instance := range problem.KeptInstances {
+			if instance.InnerStatus != types.InnerStatusReady || now.Sub(instance.CreatedAt) <= problem.MaxReadyAge {
+				kept = append(kept, instance)
+				continue
+			}
+
+			lg.Info("Scheduler: ExpireInstances. Recycle expired instance", logging.Problem(name), logging.Instance(instance.InstanceName))
+			problem.Expired++
+			problem.ExpiredInstances = append(problem.ExpiredInstances, instance)
+		}
+		problem.KeptInstances = kept
+	}
+}
@@ -0,0 +1,73 @@
+package scheduler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"go.uber.org/zap"
+)
+
+func Test_ExpireInstances(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	old, fresh := now.Add(-2*time.Hour), now.Add(-10*time.Minute)
+
+	tests := []struct {
+		name          string
+		ready         []time.Time
+		notReady      int
+		wantCreations int
+		wantDeletions []string
+	}{
+		// 全て期限切れ: 代わりを作成し、まだ削除しない
+		{name: "all expired", ready: []time.Time{old, old, old}, wantCreations: 3},
+		// 代わりがまだNOT_READY: 作成も削除もしない
+		{name: "replacements not ready", ready: []time.Time{old, old, old}, notReady: 3},
+		// 代わりがREADYになった: 期限切れを削除する
+		{name: "replacements ready", ready: []time.Time{old, old, old, fresh, fresh, fresh}, wantDeletions: []string{"i0", "i1", "i2"}},
+		// 代わりが一部だけREADY: READYになった分だけ削除する
+		{name: "partially ready", ready: []time.Time{old, old, old, fresh}, notReady: 2, wantDeletions: []string{"i0"}},
+		{name: "not expired", ready: []time.Time{fresh, fresh, fresh}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, zonePriorities := InitScheduler(newTestConfig(3), zap.NewNop())
+			problem := problems["image-sc0"]
+			problem.MaxReadyAge = time.Hour
+			for i, createdAt := range tt.ready {
+				problem.Ready++
+				problem.KeptInstances = append(problem.KeptInstances, Instance{
+					InstanceName: fmt.Sprintf("i%d", i),
+					InnerStatus:  types.InnerStatusReady,
+					// 番号が小さいほど古い
+					CreatedAt: createdAt.Add(time.Duration(i) * time.Second),
+				})
+			}
+			problem.NotReady = tt.notReady
+
+			ExpireInstances(problems, now, zap.NewNop())
+			creations, deletions := SchedulingList(problems, zonePriorities, zap.NewNop())
+
+			if len(creations) != tt.wantCreations {
+				t.Errorf("creations = %d, want %d", len(creations), tt.wantCreations)
+			}
+			got := []string{}
+			for _, d := range deletions {
+				got = append(got, d.InstanceName)
+				if d.Policy != PolicyMaxReadyAge {
+					t.Errorf("%s: policy = %q, want %q", d.InstanceName, d.Policy, PolicyMaxReadyAge)
+				}
+			}
+			if len(got) != len(tt.wantDeletions) {
+				t.Fatalf("deletions = %v, want %v", got, tt.wantDeletions)
+			}
+			for i := range got {
+				if got[i] != tt.wantDeletions[i] {
+					t.Errorf("deletions = %v, want %v", got, tt.wantDeletions)
+				}
+			}
+		})
+	}
+}
@@ -3,14 +3,17 @@ package scheduler
 import (
 	"fmt"
 	"sort"
-	"strconv"
 	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/janog-netcon/netcon-cli/pkg/logging"
+	"github.com/janog-netcon/netcon-cli/pkg/notifier"
 	"github.com/janog-netcon/netcon-cli/pkg/scoreserver"
+	"github.com/janog-netcon/netcon-cli/pkg/tracing"
 	"github.com/janog-netcon/netcon-cli/pkg/types"
 	"github.com/janog-netcon/netcon-cli/pkg/vmms"
+	"golang.org/x/xerrors"
 )
 
 type Problem struct {
@@ -21,17 +24,37 @@ type Problem struct {
 	UnderChallenge   int
 	UnderScoring     int
 	Abandoned        int
-	PoolCount        int
-	KeptInstances    []Instance
-	CurrentInstance  int
+	// スコアサーバから返ってきた、schedulerが知らない状態のインスタンス数
+	Unknown       int
+	PoolCount     int
+	KeptInstances []Instance
+	// NOT_READYなインスタンス (NOT_READYのまま放置されているインスタンスを検知するために保持する)
+	NotReadyInstances []Instance
+	// READYだがProberで接続できなかったため、作り直すインスタンス
+	Unhealthy          int
+	UnhealthyInstances []Instance
+	// READYなまま MaxReadyAge を超えたため、作り直すインスタンス
+	// Ready には含まれたまま、代わりのインスタンスがREADYになってから削除する
+	MaxReadyAge      time.Duration
+	Expired          int
+	ExpiredInstances []Instance
+	// pool_count を超えたインスタンスを削除する順番
+	DeletionPolicy string
+	// インスタンス1台あたりの1時間の費用
+	HourlyCost float64
+	// 作成を要求したが、まだ問題環境情報に現れていないインスタンス数
+	Pending         int
+	CurrentInstance int
 }
 
 type Instance struct {
 	InstanceName string
 	ProjectName  string
 	ZoneName     string
-	InnerStatus  *string
+	InnerStatus  types.InnerStatus
 	CreatedAt    time.Time
+	// サービス(SSH, HTTPS)ごとの接続先
+	Services []types.Endpoint
 }
 
 type ZonePriority struct {
@@ -42,6 +65,13 @@ type ZonePriority struct {
 	CurrentInstance int
 }
 
+// InstanceClient インスタンスの作成・削除を行うクライアント
+// 通常は vmms.Client を使い、scheduler simulate ではメモリ上で動作するものを使う
+type InstanceClient interface {
+	CreateInstance(idempotencyKey, problemID, machineImageName, project, zone string) (*types.Instance, error)
+	DeleteInstance(name, project, zone string) error
+}
+
 type CreationTargetInstance struct {
 	ProblemName      string
 	ProblemID        string
@@ -53,47 +83,149 @@ type DeletionTargetInstance struct {
 	InstanceName string
 	ProjectName  string
 	ZoneName     string
+	// このインスタンスを削除する理由
+	// pool_count を超えたため削除する場合は選んだ DeletionPolicy、max_ready_age を超えた場合は PolicyMaxReadyAge
+	Policy string `json:",omitempty"`
 }
 
-func SchedulerReady(cfg *types.SchedulerConfig, ssClient *scoreserver.Client, vmmsClient *vmms.Client, lg *zap.Logger) error {
+// SchedulerReady スコアサーバの状況を集計し、インスタンスの作成・削除を1回行う
+// Stateに Tracer が設定されている場合は、全体と各処理をspanとして記録する
+func SchedulerReady(cfg *types.SchedulerConfig, ssClient *scoreserver.Client, vmmsClient *vmms.Client, nt *notifier.Notifier, st *State, lg *zap.Logger) error {
+	tr := st.Tracer()
+	tickID := logging.NewTickID()
+	span := tr.Start("SchedulerReady", tracing.String("netcon.tick_id", tickID))
+	// 終了したspanは1回の実行ごとにバックグラウンドで送る (送り終わるのは待たない)
+	defer tr.Flush()
+	defer span.End()
+
+	// 1回の実行のログを tick_id でまとめて追えるようにする
+	lg = lg.With(logging.TickID(tickID))
+	if traceID := span.TraceID(); traceID != "" {
+		lg = lg.With(logging.TraceID(traceID))
+	}
+
+	err := schedulerReady(cfg, ssClient, vmmsClient, nt, st, tr, lg)
+	span.SetError(err)
+	return err
+}
+
+func schedulerReady(cfg *types.SchedulerConfig, ssClient *scoreserver.Client, vmmsClient *vmms.Client, nt *notifier.Notifier, st *State, tr *tracing.Tracer, lg *zap.Logger) error {
 	lg.Info("Scheduler: SchedulerReady")
 
 	// configファイルから設定を読み込む
+	span := tr.Start("InitScheduler")
 	problems, zonePriorities := InitScheduler(cfg, lg)
+	span.End()
 
 	// ScoreServer からデータを取得し、現在のインスタンス状況を集計する
-	problems, zonePriorities, abandonedInstances, err := AggregateInstance(problems, zonePriorities, ssClient, lg)
+	// 前回から変更がなければ前回の集計結果を使う
+	span = tr.Start("AggregateInstance")
+	problems, zonePriorities, abandonedInstances, err := st.AggregateInstance(problems, zonePriorities, ssClient, lg)
+	span.SetError(err)
+	span.End()
 
 	if err != nil {
-		lg.Error("Scheduler Aggregate: " + err.Error())
+		lg.Error("Scheduler: Aggregate failed", zap.Error(err))
 		return err
 	}
 
-	// ロギング
-	PISLogging(problems, lg)
-	ZPSLogging(zonePriorities, lg)
+	// 作成を要求したがまだ問題環境情報に現れていないインスタンスを、作成中のインスタンスとして数える
+	span = tr.Start("ResolvePendingCreations")
+	pendingCreations := st.ResolvePendingCreations(cfg, time.Now(), lg)
+	ApplyPending(pendingCreations, problems, zonePriorities)
+	span.SetAttributes(tracing.Int("netcon.pending", len(pendingCreations)))
+	span.End()
+
+	// READYなインスタンスに実際に接続できるかを確認し、接続できないインスタンスは作り直す
+	span = tr.Start("ProbeInstances")
+	unhealthyInstances := ProbeInstances(problems, st.Prober(), lg)
+	span.SetAttributes(tracing.Int("netcon.unhealthy", len(unhealthyInstances)))
+	span.End()
+
+	// 通知ルールを評価する
+	span = tr.Start("NotifyAggregation")
+	NotifyAggregation(problems, zonePriorities, nt, time.Now())
+	span.End()
+
+	// READYなまま max_ready_age を超えたインスタンスを作り直す対象にする
+	span = tr.Start("ExpireInstances")
+	ExpireInstances(problems, time.Now(), lg)
+	span.End()
 
 	// 作成対象のインスタンスと削除対象のインスタンスを列挙する
-	creationTargetInstances, deletionTargetInstances := SchedulingList(problems, lg)
+	span = tr.Start("SchedulingList")
+	creationTargetInstances, deletionTargetInstances := SchedulingList(problems, zonePriorities, lg)
+	span.SetAttributes(
+		tracing.Int("netcon.creations", len(creationTargetInstances)),
+		tracing.Int("netcon.deletions", len(deletionTargetInstances)),
+	)
+	span.End()
+
+	// budget を超える作成は見送る
+	span = tr.Start("ApplyBudget")
+	creationTargetInstances, refusedInstances := ApplyBudget(creationTargetInstances, allDeletions(abandonedInstances, unhealthyInstances, deletionTargetInstances), problems, st.Environments(), cfg, lg)
+	NotifyBudget(nt, refusedInstances)
+	span.SetAttributes(tracing.Int("netcon.refused", len(refusedInstances)))
+	span.End()
+
+	actions := Actions{
+		Abandoned: abandonedInstances,
+		Unhealthy: unhealthyInstances,
+		Deletions: deletionTargetInstances,
+		Creations: creationTargetInstances,
+		Refused:   refusedInstances,
+	}
+	LogSummary(problems, zonePriorities, actions, lg)
+
+	// scheduler replay で再現できるように、取得した問題環境情報と計画した作成・削除を記録する
+	span = tr.Start("Record")
+	st.Record(time.Now(), actions, pendingCreations, lg)
+	span.End()
+
+	// 削除したVMは updated_since では分からず、前回の集計結果を使い続けると同じVMを再び削除しようとするため、
+	// 削除する場合は次回は一覧を取得し直して集計する
+	if len(allDeletions(abandonedInstances, unhealthyInstances, deletionTargetInstances)) > 0 {
+		st.InvalidateAggregation()
+		ssClient.Invalidate()
+	}
+
+	// 実行中にリーダーでなくなった場合は、作成・削除を中止する
+	instanceClient := st.InstanceClient(vmmsClient)
 
 	// abandoned なインスタンスを削除する
-	err = DeleteInstances(abandonedInstances, vmmsClient, cfg.Setting.Scheduler.InstanceDeletionInterval, lg)
+	err = deleteInstances("abandoned", abandonedInstances, instanceClient, cfg.Setting.Scheduler.InstanceDeletionInterval, tr, lg)
+	if err != nil {
+		lg.Error("Scheduler: DeleteScheduler failed", zap.String("target", "abandoned"), zap.Error(err))
+		return err
+	}
+
+	// 接続できないインスタンスを削除する
+	err = deleteInstances("unhealthy", unhealthyInstances, instanceClient, cfg.Setting.Scheduler.InstanceDeletionInterval, tr, lg)
 	if err != nil {
-		lg.Error("Scheduler DeleteScheduler: AbandonedInstance. " + err.Error())
+		lg.Error("Scheduler: DeleteScheduler failed", zap.String("target", "unhealthy"), zap.Error(err))
 		return err
 	}
 
 	// 削除対象のインスタンスを削除する
-	err = DeleteInstances(deletionTargetInstances, vmmsClient, cfg.Setting.Scheduler.InstanceDeletionInterval, lg)
+	err = deleteInstances("pool", deletionTargetInstances, instanceClient, cfg.Setting.Scheduler.InstanceDeletionInterval, tr, lg)
 	if err != nil {
-		lg.Error("Scheduler DeleteScheduler: " + err.Error())
+		lg.Error("Scheduler: DeleteScheduler failed", zap.String("target", "pool"), zap.Error(err))
 		return err
 	}
 
 	// 作成対象のインスタンスを作成する
-	err = CreateInstances(creationTargetInstances, zonePriorities, vmmsClient, cfg.Setting.Scheduler.InstanceCreationInterval, lg)
+	span = tr.Start("CreateInstances", tracing.Int("netcon.instances", len(creationTargetInstances)))
+	err = CreateInstances(creationTargetInstances, zonePriorities, instanceClient, st.PendingCreations(), cfg.Setting.Scheduler.InstanceCreationInterval, tr, lg)
+	span.SetError(err)
+	span.End()
+	if xerrors.Is(err, ErrNotLeader) {
+		// 作成の失敗ではないため通知しない
+		lg.Warn("Leader: lost leadership. stop scheduling")
+		return err
+	}
+	NotifyCreation(nt, err)
 	if err != nil {
-		lg.Error("Scheduler CreateScheduler: " + err.Error())
+		lg.Error("Scheduler: CreateScheduler failed", zap.Error(err))
 		return err
 	}
 	return nil
@@ -111,16 +243,26 @@ func InitScheduler(cfg *types.SchedulerConfig, lg *zap.Logger) (map[string]*Prob
 
 	for _, p := range cfg.Setting.Problems {
 		problems[p.MachineImageName] = &Problem{
-			MachineImageName: p.MachineImageName,
-			ProblemID:        p.ProblemID,
-			NotReady:         0,
-			Ready:            0,
-			UnderChallenge:   0,
-			UnderScoring:     0,
-			Abandoned:        0,
-			PoolCount:        p.PoolCount,
-			KeptInstances:    []Instance{},
-			CurrentInstance:  0,
+			MachineImageName:   p.MachineImageName,
+			ProblemID:          p.ProblemID,
+			NotReady:           0,
+			Ready:              0,
+			UnderChallenge:     0,
+			UnderScoring:       0,
+			Abandoned:          0,
+			Unknown:            0,
+			PoolCount:          p.PoolCount,
+			KeptInstances:      []Instance{},
+			NotReadyInstances:  []Instance{},
+			Unhealthy:          0,
+			UnhealthyInstances: []Instance{},
+			MaxReadyAge:        time.Duration(p.MaxReadyAge) * time.Second,
+			Expired:            0,
+			ExpiredInstances:   []Instance{},
+			DeletionPolicy:     p.DeletionPolicy,
+			HourlyCost:         cfg.Setting.HourlyCost(p.MachineImageName),
+			Pending:            0,
+			CurrentInstance:    0,
 		}
 	}
 
@@ -153,89 +295,86 @@ func AggregateInstance(problems map[string]*Problem, zonePriorities []*ZonePrior
 
 	lg.Info("Scheduler: Aggregate. Got ProblemEnvironments")
 
+	problems, zonePriorities, abandonedInstances := Aggregate(problems, zonePriorities, types.NewEnvironments(*problemEnvironments), lg)
+
+	return problems, zonePriorities, abandonedInstances, nil
+}
+
+// Aggregate 問題環境情報から、現在のインスタンス情報について集計を行う
+// 1台のVMに対してサービスごとに複数の ProblemEnvironment が返ってくるため、VM(Environment)単位で集計する
+func Aggregate(problems map[string]*Problem, zonePriorities []*ZonePriority, environments []types.Environment, lg *zap.Logger) (map[string]*Problem, []*ZonePriority, []DeletionTargetInstance) {
 	// 削除するインスタンスリスト
 	abandonedInstances := []DeletionTargetInstance{}
 
-	for _, p := range *problemEnvironments {
+	for _, p := range environments {
+
+		if p.MachineImageName == nil {
+			lg.Error("Scheduler: Aggregate. machine_image_name is null", logging.Instance(p.Name))
+			continue
+		}
 
 		if _, ok := problems[*p.MachineImageName]; !ok {
-			lg.Error("Scheduler: Aggregate. This problem name not exists. The value is " + *p.MachineImageName)
+			lg.Error("Scheduler: Aggregate. Problem not in config", logging.Problem(*p.MachineImageName), logging.Instance(p.Name))
 			continue
 		}
 
 		// エラーメッセージは出力するが処理は継続する
 		// configファイルに書かれているMachineImageNameを正とする
 		if problems[*p.MachineImageName].MachineImageName != *p.MachineImageName {
-			lg.Error(fmt.Sprintf(
-				"Scheduler: Aggregate. Inconsistent settings: Scheduler Value: %s, ScoreServer value: %s",
-				problems[*p.MachineImageName].MachineImageName,
-				*p.MachineImageName,
-			))
+			lg.Error("Scheduler: Aggregate. Inconsistent machine_image_name",
+				logging.Problem(problems[*p.MachineImageName].MachineImageName),
+				zap.String("scoreserver_value", *p.MachineImageName),
+				logging.Instance(p.Name),
+			)
 		}
 
 		// エラーメッセージは出力するが処理は継続する
 		// configファイルに書かれているProblemIDを正とする
 		if problems[*p.MachineImageName].ProblemID != p.ProblemID {
-			lg.Error(fmt.Sprintf(
-				"Scheduler: Aggregate. Inconsistent settings: Scheduler Value: %s, ScoreServer value: %s",
-				problems[*p.MachineImageName].ProblemID,
-				p.ProblemID,
-			))
+			lg.Error("Scheduler: Aggregate. Inconsistent problem_id",
+				logging.Problem(*p.MachineImageName),
+				zap.String("problem_id", problems[*p.MachineImageName].ProblemID),
+				zap.String("scoreserver_value", p.ProblemID),
+				logging.Instance(p.Name),
+			)
 		}
 
-		if p.InnerStatus == nil {
+		// スコアサーバがまだ触れていないインスタンスのInnerStatusにはnil(デフォルト)が設定されている
+		// そのため、scheduler的には InnerStatus に nil が設定されているインスタンスはReady扱いになる
+		innerStatus := types.NormalizeInnerStatus(p.InnerStatus)
+		instance := Instance{
+			InstanceName: p.Name,
+			ProjectName:  p.ProjectName,
+			ZoneName:     p.ZoneName,
+			InnerStatus:  innerStatus,
+			CreatedAt:    p.CreatedAt,
+			Services:     p.Services,
+		}
+
+		switch innerStatus {
+		case types.InnerStatusNotReady:
+			problems[*p.MachineImageName].NotReady++
+			problems[*p.MachineImageName].NotReadyInstances = append(problems[*p.MachineImageName].NotReadyInstances, instance)
+		case types.InnerStatusReady:
 			problems[*p.MachineImageName].Ready++
-			problems[*p.MachineImageName].KeptInstances = append(
-				problems[*p.MachineImageName].KeptInstances,
-				Instance{
-					InstanceName: p.Name,
-					ProjectName:  p.ProjectName,
-					ZoneName:     p.ZoneName,
-					InnerStatus:  p.InnerStatus,
-					CreatedAt:    p.CreatedAt,
-				},
-			)
-		} else {
-			switch *p.InnerStatus {
-			case types.ProblemEnvironmentInnerStatusNotReady:
-				problems[*p.MachineImageName].NotReady++
-			case types.ProblemEnvironmentInnerStatusReady:
-				problems[*p.MachineImageName].Ready++
-				problems[*p.MachineImageName].KeptInstances = append(
-					problems[*p.MachineImageName].KeptInstances,
-					Instance{
-						InstanceName: p.Name,
-						ProjectName:  p.ProjectName,
-						ZoneName:     p.ZoneName,
-						InnerStatus:  p.InnerStatus,
-						CreatedAt:    p.CreatedAt,
-					},
-				)
-			case types.ProblemEnvironmentInnerStatusUnderChallenge:
-				problems[*p.MachineImageName].UnderChallenge++
-			case types.ProblemEnvironmentInnerStatusUnderScoring:
-				problems[*p.MachineImageName].UnderScoring++
-			case types.ProblemEnvironmentInnerStatusAbandoned:
-				problems[*p.MachineImageName].Abandoned++
-				// 削除するインスタンス
-				abandonedInstances = append(abandonedInstances, DeletionTargetInstance{
-					ProblemName:  *p.MachineImageName,
-					InstanceName: p.Name,
-					ProjectName:  p.ProjectName,
-					ZoneName:     p.ZoneName,
-				})
-			case "":
-				// スコアサーバがまだ触れていないインスタンスのInnerStatusにはnil(デフォルト)が設定されている
-				// そのため、scheduler的には InnerStatus に nil が設定されているインスタンスはReady扱いになる
-				problems[*p.MachineImageName].Ready++
-				problems[*p.MachineImageName].KeptInstances = append(problems[*p.MachineImageName].KeptInstances, Instance{
-					InstanceName: p.Name,
-					ProjectName:  p.ProjectName,
-					ZoneName:     p.ZoneName,
-					InnerStatus:  p.InnerStatus,
-					CreatedAt:    p.CreatedAt,
-				})
-			}
+			problems[*p.MachineImageName].KeptInstances = append(problems[*p.MachineImageName].KeptInstances, instance)
+		case types.InnerStatusUnderChallenge:
+			problems[*p.MachineImageName].UnderChallenge++
+		case types.InnerStatusUnderScoring:
+			problems[*p.MachineImageName].UnderScoring++
+		case types.InnerStatusAbandoned:
+			problems[*p.MachineImageName].Abandoned++
+			// 削除するインスタンス
+			abandonedInstances = append(abandonedInstances, DeletionTargetInstance{
+				ProblemName:  *p.MachineImageName,
+				InstanceName: p.Name,
+				ProjectName:  p.ProjectName,
+				ZoneName:     p.ZoneName,
+			})
+		default:
+			// 知らない状態のインスタンスは作成・削除の対象にはしないが、数は数えておく
+			problems[*p.MachineImageName].Unknown++
+			lg.Error("Scheduler: Aggregate. Unknown inner status", logging.Problem(*p.MachineImageName), logging.Instance(p.Name), zap.String("inner_status", *p.InnerStatus))
 		}
 
 		problems[*p.MachineImageName].CurrentInstance++
@@ -248,32 +387,60 @@ func AggregateInstance(problems map[string]*Problem, zonePriorities []*ZonePrior
 		}
 	}
 
-	return problems, zonePriorities, abandonedInstances, nil
+	return problems, zonePriorities, abandonedInstances
 }
 
-func PISLogging(pis map[string]*Problem, lg *zap.Logger) {
-	for pn, pi := range pis {
-		lg.Info("--------Problem Environments--------")
-		lg.Info("Problem Name: " + pn)
-		lg.Info("Problem ID: " + pi.ProblemID)
-		lg.Info("PoolCount: " + strconv.Itoa(pi.PoolCount))
-		lg.Info("Ready: " + strconv.Itoa(pi.Ready))
-		lg.Info("NotReady: " + strconv.Itoa(pi.NotReady))
-		lg.Info("UnderChallenge: " + strconv.Itoa(pi.UnderChallenge))
-		lg.Info("UnderScoring: " + strconv.Itoa(pi.UnderScoring))
-		lg.Info("Abandoned: " + strconv.Itoa(pi.Abandoned))
-		lg.Info("CurrentInstance: " + strconv.Itoa(pi.CurrentInstance))
+// LogSummary 問題ごと、Zoneごとに集計結果と計画した作成・削除を1行ずつ出力する
+func LogSummary(problems map[string]*Problem, zonePriorities []*ZonePriority, actions Actions, lg *zap.Logger) {
+	creations := map[string]int{}
+	for _, c := range actions.Creations {
+		creations[c.ProblemName]++
+	}
+	refused := map[string]int{}
+	for _, r := range actions.Refused {
+		refused[r.ProblemName]++
+	}
+	deletions := map[string]int{}
+	for _, d := range allDeletions(actions.Abandoned, actions.Unhealthy, actions.Deletions) {
+		deletions[d.ProblemName]++
 	}
-}
 
-func ZPSLogging(zps []*ZonePriority, lg *zap.Logger) {
-	for _, zp := range zps {
-		lg.Info("--------Zone Priority Info--------")
-		lg.Info("Project Name: " + zp.ProjectName)
-		lg.Info("Zone Name:" + zp.ZoneName)
-		lg.Info("Priority: " + strconv.Itoa(zp.Priority))
-		lg.Info("MaxInstance: " + strconv.Itoa(zp.MaxInstance))
-		lg.Info("CurrentInstance: " + strconv.Itoa(zp.CurrentInstance))
+	names := []string{}
+	for name := range problems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := problems[name]
+		lg.Info("Scheduler: Problem summary",
+			logging.Problem(name),
+			zap.String("problem_id", p.ProblemID),
+			zap.Int("pool_count", p.PoolCount),
+			zap.Int("ready", p.Ready),
+			zap.Int("not_ready", p.NotReady),
+			zap.Int("under_challenge", p.UnderChallenge),
+			zap.Int("under_scoring", p.UnderScoring),
+			zap.Int("abandoned", p.Abandoned),
+			zap.Int("unknown", p.Unknown),
+			zap.Int("unhealthy", p.Unhealthy),
+			zap.Int("expired", p.Expired),
+			zap.Int("pending", p.Pending),
+			zap.Int("current_instance", p.CurrentInstance),
+			zap.Int("create", creations[name]),
+			zap.Int("delete", deletions[name]),
+			zap.Int("refused", refused[name]),
+		)
+	}
+
+	for _, zp := range zonePriorities {
+		lg.Info("Scheduler: Zone summary",
+			logging.Project(zp.ProjectName),
+			logging.Zone(zp.ZoneName),
+			zap.Int("priority", zp.Priority),
+			zap.Int("max_instance", zp.MaxInstance),
+			zap.Int("current_instance", zp.CurrentInstance),
+		)
 	}
 }
 
@@ -288,9 +455,7 @@ func filterInstances(instances []Instance) []Instance {
 	filteredInstances := []Instance{}
 
 	for _, instance := range instances {
-		if instance.InnerStatus == nil ||
-			*instance.InnerStatus == types.ProblemEnvironmentInnerStatusReady ||
-			*instance.InnerStatus == types.ProblemEnvironmentInnerStatusNotReady {
+		if instance.InnerStatus.IsPooled() {
 			filteredInstances = append(filteredInstances, instance)
 		}
 	}
@@ -299,26 +464,47 @@ func filterInstances(instances []Instance) []Instance {
 }
 
 // SchedulingList 作成・削除するインスタンスを列挙する
-// 削除するインスタンスは、作成日時が新しいインスタンスから削除する。
+// 削除するインスタンスは、問題ごとの deletion_policy に従って選ぶ。
+// デフォルトでは作成日時が新しいインスタンスから削除する。
 // (インスタンスを作成してからインスタンス内部でプロビジョニングを行っているため、削除する順番は新しいインスタンスからにしている)
 //
 // 処理について
 // 「Ready, NotReady」なインスタンスが KeepInstance を超えていたらインスタンスを削除する
 // ただし、このReadyは InnerStatus が nil なインスタンス(まだスコアサーバから使用されていないインスタンス) も含んでいる
-func SchedulingList(problems map[string]*Problem, lg *zap.Logger) ([]CreationTargetInstance, []DeletionTargetInstance) {
+//
+// max_ready_age を超えたインスタンス(Expired)は保持したいインスタンスとして数えずに代わりを作成し、
+// 代わりのインスタンスがREADYになって Ready が PoolCount を超えた分だけ、古いものから削除する
+// (作り直している間もREADYなインスタンスが PoolCount を下回らないようにするため)
+func SchedulingList(problems map[string]*Problem, zonePriorities []*ZonePriority, lg *zap.Logger) ([]CreationTargetInstance, []DeletionTargetInstance) {
 	lg.Info("Scheduler: SchedulingList")
 
 	creationTargetInstances := []CreationTargetInstance{}
 	deletionTargetInstances := []DeletionTargetInstance{}
 
 	for key, problem := range problems {
+		policyName := problem.DeletionPolicy
+		if policyName == "" {
+			policyName = DeletionPolicyNewest
+		}
+		policy, err := LookupDeletionPolicy(policyName)
+		if err != nil {
+			lg.Error("Scheduler: SchedulingList. Unknown deletion_policy, use "+DeletionPolicyNewest, logging.Problem(key), zap.Error(err))
+			policyName = DeletionPolicyNewest
+			policy, _ = LookupDeletionPolicy(policyName)
+		}
+
 		// 新しく作成されたインスタンスから削除対象にするために作成日時でソートする
-		sort.Sort(KeptInstances(problem.KeptInstances))
+		candidates := append([]Instance{}, problem.KeptInstances...)
+		if policy.NotReady() {
+			candidates = append(candidates, problem.NotReadyInstances...)
+		}
+		sort.Sort(KeptInstances(candidates))
 		// 問題に挑戦中のVMが削除されないようにReadyとNotReadyでfilterする
-		filteredKeepInstances := filterInstances(problem.KeptInstances)
+		filteredKeepInstances := policy.Order(filterInstances(candidates), zonePriorities)
 
-		// Ready と NotReady なインスタンスを保持したいインスタンスとしてカウントする
-		validInstanceCount := problem.Ready + problem.NotReady
+		// Ready と NotReady と作成中のインスタンスを保持したいインスタンスとしてカウントする
+		validInstanceCount := problem.Ready + problem.NotReady + problem.Pending - problem.Expired
+		ready := problem.Ready
 
 		// Ready + NotReady なインスタンスが PoolCount を超えていたらインスタンスの削除を行う
 		for i := 0; validInstanceCount > problem.PoolCount && len(filteredKeepInstances) > i; i++ {
@@ -327,8 +513,25 @@ func SchedulingList(problems map[string]*Problem, lg *zap.Logger) ([]CreationTar
 				InstanceName: filteredKeepInstances[i].InstanceName,
 				ProjectName:  filteredKeepInstances[i].ProjectName,
 				ZoneName:     filteredKeepInstances[i].ZoneName,
+				Policy:       policyName,
 			})
 			validInstanceCount--
+			if filteredKeepInstances[i].InnerStatus == types.InnerStatusReady {
+				ready--
+			}
+		}
+
+		// 期限切れのインスタンスは、Ready が PoolCount を超えている分だけ古いものから削除する
+		sort.Sort(sort.Reverse(KeptInstances(problem.ExpiredInstances)))
+		for i := 0; ready > problem.PoolCount && len(problem.ExpiredInstances) > i; i++ {
+			deletionTargetInstances = append(deletionTargetInstances, DeletionTargetInstance{
+				ProblemName:  key,
+				InstanceName: problem.ExpiredInstances[i].InstanceName,
+				ProjectName:  problem.ExpiredInstances[i].ProjectName,
+				ZoneName:     problem.ExpiredInstances[i].ZoneName,
+				Policy:       PolicyMaxReadyAge,
+			})
+			ready--
 		}
 
 		// Ready + NotReady なインスタンスが PoolCount より少ない場合は作成対象にする
@@ -345,30 +548,82 @@ func SchedulingList(problems map[string]*Problem, lg *zap.Logger) ([]CreationTar
 	return creationTargetInstances, deletionTargetInstances
 }
 
+func allDeletions(deletions ...[]DeletionTargetInstance) []DeletionTargetInstance {
+	all := []DeletionTargetInstance{}
+	for _, d := range deletions {
+		all = append(all, d...)
+	}
+	return all
+}
+
+// deleteInstances DeleteInstances をspanとして記録する
+func deleteInstances(target string, instances []DeletionTargetInstance, vmmsClient InstanceClient, interval int, tr *tracing.Tracer, lg *zap.Logger) error {
+	span := tr.Start("DeleteInstances", tracing.String("netcon.target", target), tracing.Int("netcon.instances", len(instances)))
+	defer span.End()
+
+	err := DeleteInstances(instances, vmmsClient, interval, tr, lg)
+	span.SetError(err)
+	return err
+}
+
 // DeleteInstances 削除対象のinstanceを全て削除する
-func DeleteInstances(instances []DeletionTargetInstance, vmmsClient *vmms.Client, interval int, lg *zap.Logger) error {
+func DeleteInstances(instances []DeletionTargetInstance, vmmsClient InstanceClient, interval int, tr *tracing.Tracer, lg *zap.Logger) error {
 	lg.Info("Scheduler: DeleteScheduler")
 
 	for i, instance := range instances {
 
 		// 1秒待たないとEOFエラーになる `Post "http://vm-management-service:81/instance": EOF`
-		time.Sleep(time.Duration(interval) * time.Second)
+		sleep(interval, tr)
 
 		if err := vmmsClient.DeleteInstance(instance.InstanceName, instance.ProjectName, instance.ZoneName); err != nil {
+			// リーダーでなくなった場合は、残りのインスタンスも削除しない
+			if xerrors.Is(err, ErrNotLeader) {
+				lg.Warn("Leader: lost leadership. stop deleting instances", logging.Instance(instance.InstanceName))
+				return err
+			}
 			msg := ""
 			for _, v := range instances[i:] {
 				msg = msg + v.InstanceName + ", "
 			}
 			// FIXME: VM不整合が起きた時に404エラーになって処理が止まってしまうのでログを出力するだけにしている
 			// return fmt.Errorf("scheduler: delete scheduler. %w remains on the delete_instance_list. %s", err, msg)
-			lg.Error(fmt.Sprintf("scheduler: delete scheduler. %s remains on the delete_instance_list. %s", err.Error(), msg))
+			lg.Error("DeletedInstance: Failed to DeleteInstance",
+				logging.Problem(instance.ProblemName),
+				logging.Instance(instance.InstanceName),
+				logging.Project(instance.ProjectName),
+				logging.Zone(instance.ZoneName),
+				zap.String("remains", msg),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		fields := []zap.Field{
+			logging.Problem(instance.ProblemName),
+			logging.Instance(instance.InstanceName),
+			logging.Project(instance.ProjectName),
+			logging.Zone(instance.ZoneName),
+		}
+		if instance.Policy != "" {
+			fields = append(fields, zap.String("deletion_policy", instance.Policy))
 		}
-		lg.Info("DeletedInstance: " + instance.ProblemName + " " + instance.InstanceName)
+		lg.Info("DeletedInstance", fields...)
 	}
 
 	return nil
 }
 
+// sleep API呼び出しの間に interval 秒待つ
+// 待った時間が分かるようにspanとして記録する
+func sleep(interval int, tr *tracing.Tracer) {
+	if interval <= 0 {
+		return
+	}
+	span := tr.Start("sleep", tracing.Int("netcon.interval_seconds", interval))
+	time.Sleep(time.Duration(interval) * time.Second)
+	span.End()
+}
+
 type ZonePriorities []*ZonePriority
 
 func (a ZonePriorities) Len() int           { return len(a) }
@@ -377,7 +632,9 @@ func (a ZonePriorities) Less(i, j int) bool { return a[i].Priority < a[j].Priori
 
 // CreateInstance 作成対象のinstanceを作成する
 // 作成時はZonePriorityを参照し、Zoneの優先順に作成していく
-func CreateInstances(instances []CreationTargetInstance, zonePriorities []*ZonePriority, vmmsClient *vmms.Client, interval int, lg *zap.Logger) error {
+// 作成を要求したインスタンスは冪等キーとともに pending に登録し、問題環境情報に現れるまで作成中として数える
+// (タイムアウトなどで結果が分からない場合も、実際には作成されている可能性があるため登録したままにする)
+func CreateInstances(instances []CreationTargetInstance, zonePriorities []*ZonePriority, vmmsClient InstanceClient, pending *PendingCreations, interval int, tr *tracing.Tracer, lg *zap.Logger) error {
 	lg.Info("Scheduler: CreateScheduler")
 
 	// Zoneを優先順に並び替える
@@ -395,17 +652,37 @@ func CreateInstances(instances []CreationTargetInstance, zonePriorities []*ZoneP
 		for creatableInstanceCount > 0 && len(instances) > i {
 
 			// 1秒待たないとEOFエラーになる `Post "http://vm-management-service:81/instance": EOF`
-			time.Sleep(time.Duration(interval) * time.Second)
+			sleep(interval, tr)
 
+			p := pending.add(instances[i], zonePriority.ProjectName, zonePriority.ZoneName, time.Now())
 			newInstance, err := vmmsClient.CreateInstance(
+				p.Key,
 				instances[i].ProblemID,
 				instances[i].MachineImageName,
 				zonePriority.ProjectName,
 				zonePriority.ZoneName,
 			)
 
+			// リーダーでなくなった場合は作成を要求していないため、作成中として数えない
+			if xerrors.Is(err, ErrNotLeader) {
+				pending.remove(p.Key)
+				lg.Warn("Leader: lost leadership. stop creating instances", logging.Problem(instances[i].ProblemName))
+				return err
+			}
+
 			if err != nil {
-				lg.Error("CreatedInstance: Failed to CreateInstance. " + err.Error())
+				fields := []zap.Field{
+					logging.Problem(instances[i].ProblemName),
+					logging.Project(zonePriority.ProjectName),
+					logging.Zone(zonePriority.ZoneName),
+					zap.String("idempotency_key", p.Key),
+				}
+				lg.Error("CreatedInstance: Failed to CreateInstance", append(fields, zap.Error(err))...)
+				if vmms.MayBeApplied(err) {
+					lg.Warn("CreatedInstance: Instance may have been created, wait for it to appear", fields...)
+				} else {
+					pending.remove(p.Key)
+				}
 
 				msg := ""
 				for _, v := range instances[i:] {
@@ -415,7 +692,13 @@ func CreateInstances(instances []CreationTargetInstance, zonePriorities []*ZoneP
 				return fmt.Errorf("scheduler: create scheduler. remains on the create_instance_list. %s", msg)
 			}
 
-			lg.Info("CreatedInstance: " + newInstance.InstanceName)
+			pending.created(p.Key, newInstance.InstanceName)
+			lg.Info("CreatedInstance",
+				logging.Problem(instances[i].ProblemName),
+				logging.Instance(newInstance.InstanceName),
+				logging.Project(zonePriority.ProjectName),
+				logging.Zone(zonePriority.ZoneName),
+			)
 
 			i++
 			creatableInstanceCount--
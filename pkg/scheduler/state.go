@@ -0,0 +1,252 @@
+package scheduler
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/janog-netcon/netcon-cli/pkg/logging"
+	"github.com/janog-netcon/netcon-cli/pkg/prober"
+	"github.com/janog-netcon/netcon-cli/pkg/scoreserver"
+	"github.com/janog-netcon/netcon-cli/pkg/tracing"
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"golang.org/x/xerrors"
+)
+
+// State schedulerの実行をまたいで引き継ぐ状態
+type State struct {
+	aggregation *aggregation
+	// インスタンスごとの前回の InnerStatus
+	innerStatuses map[string]types.InnerStatus
+	// インスタンスごとの接続確認の結果 (nilの場合は確認しない)
+	prober *prober.Prober
+	// 実行ごとの記録の保存先 (nilの場合は記録しない)
+	recorder *Recorder
+	// 最後に取得した問題環境情報 (記録に使う)
+	problemEnvironments []types.ProblemEnvironment
+	// 作成を要求したが、まだ問題環境情報に現れていないインスタンス
+	pendingCreations *PendingCreations
+	// 実行ごとのspanの記録先 (nilの場合は記録しない)
+	tracer *tracing.Tracer
+	// 自分がリーダーかを返す関数 (nilの場合は常にリーダーとして扱う)
+	isLeader func() bool
+}
+
+// ErrNotLeader リーダーでなくなったため、インスタンスの作成・削除を中止したことを示すエラー
+var ErrNotLeader = xerrors.New("not leader")
+
+// leaderGuard 作成・削除の直前にリーダーであることを確認する InstanceClient
+type leaderGuard struct {
+	InstanceClient
+	isLeader func() bool
+}
+
+func (g *leaderGuard) CreateInstance(idempotencyKey, problemID, machineImageName, project, zone string) (*types.Instance, error) {
+	if !g.isLeader() {
+		return nil, ErrNotLeader
+	}
+	return g.InstanceClient.CreateInstance(idempotencyKey, problemID, machineImageName, project, zone)
+}
+
+func (g *leaderGuard) DeleteInstance(name, project, zone string) error {
+	if !g.isLeader() {
+		return ErrNotLeader
+	}
+	return g.InstanceClient.DeleteInstance(name, project, zone)
+}
+
+// aggregation 前回の集計結果
+type aggregation struct {
+	problems           map[string]*Problem
+	zonePriorities     []*ZonePriority
+	abandonedInstances []DeletionTargetInstance
+}
+
+// NewState 空のStateを返す
+func NewState() *State {
+	return &State{
+		innerStatuses:    map[string]types.InnerStatus{},
+		pendingCreations: NewPendingCreations(),
+	}
+}
+
+// SetProber READYなインスタンスの接続確認に使うProberを設定する
+func (st *State) SetProber(pr *prober.Prober) {
+	st.prober = pr
+}
+
+// Prober 設定されているProberを返す。Stateがnilの場合はnilを返す
+func (st *State) Prober() *prober.Prober {
+	if st == nil {
+		return nil
+	}
+	return st.prober
+}
+
+// SetTracer 実行ごとのspanの記録に使うTracerを設定する
+func (st *State) SetTracer(tr *tracing.Tracer) {
+	st.tracer = tr
+}
+
+// Tracer 設定されているTracerを返す。Stateがnilの場合はnilを返す
+func (st *State) Tracer() *tracing.Tracer {
+	if st == nil {
+		return nil
+	}
+	return st.tracer
+}
+
+// SetLeaderCheck インスタンスの作成・削除の直前に、自分がリーダーかを確認する関数を設定する
+func (st *State) SetLeaderCheck(isLeader func() bool) {
+	st.isLeader = isLeader
+}
+
+// InstanceClient インスタンスの作成・削除の直前にリーダーであることを確認する InstanceClient を返す
+// 実行中にリースを失った場合、他のレプリカと同時に作成・削除しないように ErrNotLeader を返して中止する
+// Stateがnilの場合、SetLeaderCheck していない場合は c をそのまま返す
+func (st *State) InstanceClient(c InstanceClient) InstanceClient {
+	if st == nil || st.isLeader == nil {
+		return c
+	}
+	return &leaderGuard{InstanceClient: c, isLeader: st.isLeader}
+}
+
+// PendingCreations 作成中のインスタンスを返す。Stateがnilの場合はnilを返す
+func (st *State) PendingCreations() *PendingCreations {
+	if st == nil {
+		return nil
+	}
+	return st.pendingCreations
+}
+
+// ResolvePendingCreations 最後に取得した問題環境情報に現れたインスタンスを作成中のインスタンスから取り除き、残りを返す
+// Stateがnilの場合は作成中のインスタンスを引き継がないため、空を返す
+func (st *State) ResolvePendingCreations(cfg *types.SchedulerConfig, now time.Time, lg *zap.Logger) []PendingCreation {
+	if st == nil {
+		return []PendingCreation{}
+	}
+	timeout := time.Duration(cfg.Setting.Scheduler.PendingCreationTimeout) * time.Second
+	return st.pendingCreations.Resolve(types.NewEnvironments(st.problemEnvironments), now, timeout, lg)
+}
+
+// Environments 最後に取得した問題環境情報をVM単位にまとめて返す。Stateがnilの場合はnilを返す
+func (st *State) Environments() []types.Environment {
+	if st == nil {
+		return nil
+	}
+	return types.NewEnvironments(st.problemEnvironments)
+}
+
+// SetRecorder 実行ごとの記録に使うRecorderを設定する
+func (st *State) SetRecorder(rec *Recorder) {
+	st.recorder = rec
+}
+
+// Record 最後に取得した問題環境情報と、計画した作成・削除を記録する
+// Stateがnilの場合、Recorderが設定されていない場合は何もしない
+func (st *State) Record(now time.Time, actions Actions, pending []PendingCreation, lg *zap.Logger) {
+	if st == nil || st.recorder == nil {
+		return
+	}
+
+	err := st.recorder.Save(&Record{
+		Time:                now,
+		ProblemEnvironments: st.problemEnvironments,
+		Pending:             pending,
+		Actions:             actions,
+	})
+	if err != nil {
+		// 記録に失敗してもschedulerは止めない
+		lg.Error("Scheduler: Record failed", zap.Error(err))
+	}
+}
+
+// AggregateInstance スコアサーバから問題環境情報を取得して集計を行う
+// 前回の取得から問題環境情報が変わっていなければ、集計をやり直さずに前回の集計結果のコピーを返す
+// Stateがnilの場合は毎回集計を行う
+func (st *State) AggregateInstance(problems map[string]*Problem, zonePriorities []*ZonePriority, ssClient *scoreserver.Client, lg *zap.Logger) (map[string]*Problem, []*ZonePriority, []DeletionTargetInstance, error) {
+	if st == nil {
+		return AggregateInstance(problems, zonePriorities, ssClient, lg)
+	}
+
+	lg.Info("Scheduler: AggregateInstance")
+
+	problemEnvironments, modified, err := ssClient.ListProblemEnvironmentIfModified()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	st.problemEnvironments = *problemEnvironments
+
+	if !modified && st.aggregation != nil {
+		lg.Info("Scheduler: Aggregate. ProblemEnvironments not modified, reuse previous aggregation")
+		return st.aggregation.clone()
+	}
+
+	lg.Info("Scheduler: Aggregate. Got ProblemEnvironments")
+
+	environments := types.NewEnvironments(*problemEnvironments)
+	st.observeTransitions(environments, lg)
+
+	problems, zonePriorities, abandonedInstances := Aggregate(problems, zonePriorities, environments, lg)
+
+	st.aggregation = &aggregation{
+		problems:           problems,
+		zonePriorities:     zonePriorities,
+		abandonedInstances: abandonedInstances,
+	}
+
+	return st.aggregation.clone()
+}
+
+// InvalidateAggregation 前回の集計結果を破棄し、次回は必ず集計をやり直す
+func (st *State) InvalidateAggregation() {
+	if st == nil {
+		return
+	}
+	st.aggregation = nil
+}
+
+// clone 集計結果のコピーを返す
+// SchedulingList などが結果を並び替えるため、キャッシュしている値は直接返さない
+func (a *aggregation) clone() (map[string]*Problem, []*ZonePriority, []DeletionTargetInstance, error) {
+	problems := map[string]*Problem{}
+	for k, p := range a.problems {
+		c := *p
+		c.KeptInstances = append([]Instance{}, p.KeptInstances...)
+		c.NotReadyInstances = append([]Instance{}, p.NotReadyInstances...)
+		c.UnhealthyInstances = append([]Instance{}, p.UnhealthyInstances...)
+		problems[k] = &c
+	}
+
+	zonePriorities := []*ZonePriority{}
+	for _, zp := range a.zonePriorities {
+		c := *zp
+		zonePriorities = append(zonePriorities, &c)
+	}
+
+	abandonedInstances := append([]DeletionTargetInstance{}, a.abandonedInstances...)
+
+	return problems, zonePriorities, abandonedInstances, nil
+}
+
+// observeTransitions 前回からの InnerStatus の変化を確認し、状態遷移表にない遷移をログに出力する
+func (st *State) observeTransitions(environments []types.Environment, lg *zap.Logger) {
+	innerStatuses := map[string]types.InnerStatus{}
+
+	for _, e := range environments {
+		current := types.NormalizeInnerStatus(e.InnerStatus)
+		innerStatuses[e.Name] = current
+
+		previous, ok := st.innerStatuses[e.Name]
+		if !ok || previous.CanTransitionTo(current) {
+			continue
+		}
+		lg.Warn("Scheduler: Aggregate. Unexpected inner status transition",
+			logging.Instance(e.Name),
+			zap.String("from", string(previous)),
+			zap.String("to", string(current)),
+		)
+	}
+
+	st.innerStatuses = innerStatuses
+}
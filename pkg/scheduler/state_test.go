@@ -0,0 +1,54 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/xerrors"
+)
+
+func Test_StateInstanceClient(t *testing.T) {
+	cfg := newTestConfig(3)
+	lg := zap.NewNop()
+
+	// 2回確認した後にリースを失う
+	checks := 0
+	st := NewState()
+	st.SetLeaderCheck(func() bool {
+		checks++
+		return checks <= 2
+	})
+
+	problems, zonePriorities := InitScheduler(cfg, lg)
+	creations, _ := SchedulingList(problems, zonePriorities, lg)
+	if len(creations) != 3 {
+		t.Fatalf("creations = %d, want 3", len(creations))
+	}
+
+	cli := &testInstanceClient{errs: []error{nil, nil, nil}}
+	err := CreateInstances(creations, zonePriorities, st.InstanceClient(cli), st.PendingCreations(), 0, nil, lg)
+	if !xerrors.Is(err, ErrNotLeader) {
+		t.Fatalf("CreateInstances() = %v, want ErrNotLeader", err)
+	}
+	if len(cli.keys) != 2 {
+		t.Errorf("created %d instances, want 2", len(cli.keys))
+	}
+	// 作成を要求しなかったインスタンスは作成中として数えない
+	if pending := st.PendingCreations().Resolve(nil, time.Now(), 0, lg); len(pending) != 2 {
+		t.Errorf("pending = %d, want 2", len(pending))
+	}
+
+	deletions := []DeletionTargetInstance{{InstanceName: "image-sc0-aaa"}, {InstanceName: "image-sc0-bbb"}}
+	if err := DeleteInstances(deletions, st.InstanceClient(cli), 0, nil, lg); !xerrors.Is(err, ErrNotLeader) {
+		t.Fatalf("DeleteInstances() = %v, want ErrNotLeader", err)
+	}
+	if len(cli.deleted) != 0 {
+		t.Errorf("deleted %v, want none", cli.deleted)
+	}
+
+	// SetLeaderCheck していない場合はそのまま使う
+	if c := NewState().InstanceClient(cli); c != InstanceClient(cli) {
+		t.Errorf("InstanceClient() without leader check = %#v, want the given client", c)
+	}
+}
@@ -8,19 +8,48 @@ e.GET("/problem-environments/:name", getProblemEnvironment)
 */
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sync"
+	"time"
 
+	"github.com/janog-netcon/netcon-cli/pkg/tracing"
 	"github.com/janog-netcon/netcon-cli/pkg/types"
 	"golang.org/x/xerrors"
 )
 
 type Client struct {
 	Endpoint string
+	// UseUpdatedSince trueの場合、一覧を取得する前に updated_since クエリで変更の有無を確認する
+	// vmdb-apiが updated_since に対応していない場合は自動で無効になる
+	UseUpdatedSince bool
+	// FullListInterval UseUpdatedSince の場合も、前回一覧を取得してからこの時間が経ったら一覧を取得し直す (0の場合は5分)
+	// updated_since では削除されたVMが分からないため
+	FullListInterval time.Duration
+	// API呼び出しをspanとして記録する (nilの場合は記録しない)
+	Tracer *tracing.Tracer
+
+	mu    sync.Mutex
+	cache *listCache
+}
+
+// listCache 前回取得した /problem-environments のレスポンス
+type listCache struct {
+	etag                string
+	lastModified        string
+	digest              [sha256.Size]byte
+	latestUpdatedAt     time.Time
+	problemEnvironments []types.ProblemEnvironment
+	// 最後に一覧を取得した時刻
+	listedAt time.Time
 }
 
+const defaultFullListInterval = 5 * time.Minute
+
 // NewClient スコアサーバのクライアントを返す
 func NewClient(endpoint string) *Client {
 	return &Client{
@@ -30,28 +59,148 @@ func NewClient(endpoint string) *Client {
 
 // ListProblemEnvironment VM一覧を取得する
 func (c *Client) ListProblemEnvironment() (*[]types.ProblemEnvironment, error) {
+	problemEnvironments, _, err := c.ListProblemEnvironmentIfModified()
+	return problemEnvironments, err
+}
+
+// ListProblemEnvironmentIfModified VM一覧を取得し、前回の取得から変更があったかを返す
+// ETag(If-None-Match) と Last-Modified(If-Modified-Since) による条件付きリクエストを行い、
+// 変更がなければ前回取得した一覧を返す
+// サーバがどちらのヘッダも返さない場合は、bodyが前回と同じかどうかで判定する
+func (c *Client) ListProblemEnvironmentIfModified() (*[]types.ProblemEnvironment, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fullListInterval := c.FullListInterval
+	if fullListInterval <= 0 {
+		fullListInterval = defaultFullListInterval
+	}
+	if c.UseUpdatedSince && c.cache != nil && time.Since(c.cache.listedAt) < fullListInterval {
+		changed, err := c.changedSince(c.cache.latestUpdatedAt)
+		if err != nil {
+			return nil, false, err
+		}
+		if !changed {
+			return c.cachedProblemEnvironments(), false, nil
+		}
+	}
+
 	u := fmt.Sprintf("%s/problem-environments", c.Endpoint)
 
 	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if c.cache != nil {
+		if c.cache.etag != "" {
+			req.Header.Set("If-None-Match", c.cache.etag)
+		}
+		if c.cache.lastModified != "" {
+			req.Header.Set("If-Modified-Since", c.cache.lastModified)
+		}
+	}
 
 	cli := &http.Client{}
-	resp, err := cli.Do(req)
+	resp, err := c.Tracer.Do(cli, req, "scoreserver.ListProblemEnvironment")
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	defer resp.Body.Close()
 
 	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotModified && c.cache != nil {
+		c.cache.listedAt = time.Now()
+		return c.cachedProblemEnvironments(), false, nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, xerrors.New(fmt.Sprintf("status code not 200: status code is %d: body: %s", resp.StatusCode, respBody))
+		return nil, false, xerrors.New(fmt.Sprintf("status code not 200: status code is %d: body: %s", resp.StatusCode, types.RedactBody(respBody)))
+	}
+
+	digest := sha256.Sum256(respBody)
+	if c.cache != nil && c.cache.digest == digest {
+		c.cache.etag = resp.Header.Get("ETag")
+		c.cache.lastModified = resp.Header.Get("Last-Modified")
+		c.cache.listedAt = time.Now()
+		return c.cachedProblemEnvironments(), false, nil
 	}
 
 	var problemEnvironments []types.ProblemEnvironment
 	if err := json.Unmarshal(respBody, &problemEnvironments); err != nil {
-		return nil, xerrors.Errorf("body %s:json unmarshal error: %w", respBody, err)
+		return nil, false, xerrors.Errorf("json unmarshal error: %w", err)
 	}
 
-	return &problemEnvironments, nil
+	c.cache = &listCache{
+		etag:                resp.Header.Get("ETag"),
+		lastModified:        resp.Header.Get("Last-Modified"),
+		digest:              digest,
+		latestUpdatedAt:     latestUpdatedAt(problemEnvironments),
+		problemEnvironments: problemEnvironments,
+		listedAt:            time.Now(),
+	}
+
+	return c.cachedProblemEnvironments(), true, nil
+}
+
+// Invalidate キャッシュしている一覧を破棄し、次回は条件なしで一覧を取得する
+// VMを削除した後に呼ぶ (updated_since では削除されたVMが分からないため)
+func (c *Client) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache = nil
+}
+
+// changedSince updated_since クエリを使い、since 以降に更新されたVMがあるかを確認する
+// since 以前に更新されたVMが返ってきた場合はクエリが無視されているとみなし、UseUpdatedSince を無効にする
+func (c *Client) changedSince(since time.Time) (bool, error) {
+	u := fmt.Sprintf("%s/problem-environments?updated_since=%s", c.Endpoint, url.QueryEscape(since.Format(time.RFC3339Nano)))
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return false, err
+	}
+
+	cli := &http.Client{}
+	resp, err := c.Tracer.Do(cli, req, "scoreserver.CheckUpdatedSince")
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return false, xerrors.New(fmt.Sprintf("status code not 200: status code is %d: body: %s", resp.StatusCode, types.RedactBody(respBody)))
+	}
+
+	var problemEnvironments []types.ProblemEnvironment
+	if err := json.Unmarshal(respBody, &problemEnvironments); err != nil {
+		return false, xerrors.Errorf("json unmarshal error: %w", err)
+	}
+
+	for _, pe := range problemEnvironments {
+		if !pe.UpdatedAt.After(since) {
+			c.UseUpdatedSince = false
+			return true, nil
+		}
+	}
+
+	return len(problemEnvironments) > 0, nil
+}
+
+// cachedProblemEnvironments キャッシュしている一覧のコピーを返す
+func (c *Client) cachedProblemEnvironments() *[]types.ProblemEnvironment {
+	problemEnvironments := make([]types.ProblemEnvironment, len(c.cache.problemEnvironments))
+	copy(problemEnvironments, c.cache.problemEnvironments)
+	return &problemEnvironments
+}
+
+func latestUpdatedAt(problemEnvironments []types.ProblemEnvironment) time.Time {
+	latest := time.Time{}
+	for _, pe := range problemEnvironments {
+		if pe.UpdatedAt.After(latest) {
+			latest = pe.UpdatedAt
+		}
+	}
+	return latest
 }
 
 // GetProblemEnvironment nameで指定したVM情報を取得する
@@ -95,9 +244,12 @@ func (c *Client) GetProblemEnvironment(name string) (*[]types.ProblemEnvironment
 	u := fmt.Sprintf("%s/problem-environments/%s", c.Endpoint, name)
 
 	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
 
 	cli := &http.Client{}
-	resp, err := cli.Do(req)
+	resp, err := c.Tracer.Do(cli, req, "scoreserver.GetProblemEnvironment")
 	if err != nil {
 		return nil, err
 	}
@@ -105,13 +257,57 @@ func (c *Client) GetProblemEnvironment(name string) (*[]types.ProblemEnvironment
 
 	respBody, _ := ioutil.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
-		return nil, xerrors.New(fmt.Sprintf("status code not 200: status code is %d: body: %s", resp.StatusCode, respBody))
+		return nil, xerrors.New(fmt.Sprintf("status code not 200: status code is %d: body: %s", resp.StatusCode, types.RedactBody(respBody)))
 	}
 
 	var problemEnvironments []types.ProblemEnvironment
 	if err := json.Unmarshal(respBody, &problemEnvironments); err != nil {
-		return nil, xerrors.Errorf("body %s:json unmarshal error: %w", respBody, err)
+		return nil, xerrors.Errorf("json unmarshal error: %w", err)
 	}
 
 	return &problemEnvironments, nil
 }
+
+// GetEnvironment nameで指定したVM情報を、サービスごとの行を1つにまとめて取得する
+func (c *Client) GetEnvironment(name string) (*types.Environment, error) {
+	problemEnvironments, err := c.GetProblemEnvironment(name)
+	if err != nil {
+		return nil, err
+	}
+
+	environments := types.NewEnvironments(*problemEnvironments)
+	if len(environments) == 0 {
+		return nil, xerrors.New(fmt.Sprintf("problem environment not found: %s", name))
+	}
+
+	return &environments[0], nil
+}
+
+// ListProblem スコアサーバに登録されている問題の一覧を取得する
+func (c *Client) ListProblem() (*[]types.Problem, error) {
+	u := fmt.Sprintf("%s/problems", c.Endpoint)
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	cli := &http.Client{}
+	resp, err := c.Tracer.Do(cli, req, "scoreserver.ListProblem")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.New(fmt.Sprintf("status code not 200: status code is %d: body: %s", resp.StatusCode, types.RedactBody(respBody)))
+	}
+
+	var problems []types.Problem
+	if err := json.Unmarshal(respBody, &problems); err != nil {
+		return nil, xerrors.Errorf("json unmarshal error: %w", err)
+	}
+
+	return &problems, nil
+}
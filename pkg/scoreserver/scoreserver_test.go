@@ -1,9 +1,171 @@
 package scoreserver
 
-import "testing"
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
 
 func Test_ListProblemEnvironment(t *testing.T) {
+	body := `[{"name": "image-110-okaxv", "service": "SSH", "port": 50080, "machine_image_name": "image-110", "updated_at": "2021-01-07T22:06:06.069066Z"}]`
+	etag := `"v1"`
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	cli := NewClient(ts.URL)
+
+	pes, modified, err := cli.ListProblemEnvironmentIfModified()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !modified || len(*pes) != 1 {
+		t.Fatalf("first request: modified %v, len %d", modified, len(*pes))
+	}
+
+	pes, modified, err = cli.ListProblemEnvironmentIfModified()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modified || len(*pes) != 1 || (*pes)[0].Name != "image-110-okaxv" {
+		t.Fatalf("second request: modified %v, %#v", modified, *pes)
+	}
+
+	// ETagが変わってもbodyが同じなら変更なしとみなす
+	etag = `"v2"`
+	if _, modified, _ = cli.ListProblemEnvironmentIfModified(); modified {
+		t.Fatal("third request: expected not modified")
+	}
+
+	etag = `"v3"`
+	body = `[]`
+	pes, modified, _ = cli.ListProblemEnvironmentIfModified()
+	if !modified || len(*pes) != 0 {
+		t.Fatalf("fourth request: modified %v, len %d", modified, len(*pes))
+	}
+}
+
+func Test_ListProblemEnvironmentUpdatedSince(t *testing.T) {
+	// updated_since のクエリには削除されたVMが現れない
+	full := `[{"name": "image-110-okaxv", "service": "SSH", "port": 50080, "machine_image_name": "image-110", "updated_at": "2021-01-07T22:06:06.069066Z"}]`
+	fullRequests := 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("updated_since") != "" {
+			w.Write([]byte(`[]`))
+			return
+		}
+		fullRequests++
+		w.Write([]byte(full))
+	}))
+	defer ts.Close()
+
+	cli := NewClient(ts.URL)
+	cli.UseUpdatedSince = true
+	cli.FullListInterval = time.Hour
+
+	if _, modified, err := cli.ListProblemEnvironmentIfModified(); err != nil || !modified {
+		t.Fatalf("first request: modified %v, err %v", modified, err)
+	}
+
+	// VMが削除されたが、updated_since では変更なしに見える
+	full = `[]`
+	if pes, modified, _ := cli.ListProblemEnvironmentIfModified(); modified || len(*pes) != 1 {
+		t.Fatalf("second request: modified %v, len %d", modified, len(*pes))
+	}
+
+	// Invalidate した後は一覧を取得し直す
+	cli.Invalidate()
+	if pes, modified, _ := cli.ListProblemEnvironmentIfModified(); !modified || len(*pes) != 0 {
+		t.Fatalf("after Invalidate: modified %v, len %d", modified, len(*pes))
+	}
+
+	// FullListInterval が経過した場合も一覧を取得し直す
+	cli.FullListInterval = time.Nanosecond
+	before := fullRequests
+	cli.ListProblemEnvironmentIfModified()
+	if fullRequests != before+1 {
+		t.Errorf("after FullListInterval: got %d full requests, want %d", fullRequests, before+1)
+	}
 }
 
 func Test_GetProblemEnvironment(t *testing.T) {
+	body := `[
+  {"name": "image-110-okaxv", "service": "SSH", "host": "35.187.220.33", "port": 50080, "machine_image_name": "image-110", "created_at": "2021-01-07T21:43:07.13899Z"},
+  {"name": "image-110-okaxv", "service": "HTTPS", "host": "xxxxxxxx.janog47.eve-ng.com", "port": 443, "machine_image_name": "image-110", "created_at": "2021-01-07T21:43:07.18566Z"}
+]`
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/problem-environments/image-110-okaxv" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	cli := NewClient(ts.URL)
+
+	pes, err := cli.GetProblemEnvironment("image-110-okaxv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*pes) != 2 {
+		t.Fatalf("got %d rows, want 2", len(*pes))
+	}
+
+	env, err := cli.GetEnvironment("image-110-okaxv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env.Services) != 2 {
+		t.Fatalf("got %d services, want 2", len(env.Services))
+	}
+	if https, ok := env.Service("HTTPS"); !ok || https.Port != 443 {
+		t.Errorf("HTTPS endpoint: %#v", https)
+	}
+	if env.CreatedAt != (*pes)[0].CreatedAt {
+		t.Errorf("created_at: got %s, want %s", env.CreatedAt, (*pes)[0].CreatedAt)
+	}
+}
+
+func Test_GetProblemEnvironmentInvalidEndpoint(t *testing.T) {
+	cli := NewClient("http://[::1")
+
+	if _, err := cli.GetProblemEnvironment("image-110-okaxv"); err == nil {
+		t.Fatal("GetProblemEnvironment() should fail with an invalid endpoint")
+	}
+}
+
+func Test_StatusErrorRedactsPassword(t *testing.T) {
+	// エラー時のレスポンスボディに含まれるパスワードをエラーメッセージに含めない
+	body := `{"error": "internal error", "environment": {"name": "image-110-okaxv", "password": "p@ssw0rd"}}`
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	cli := NewClient(ts.URL)
+
+	_, err := cli.GetProblemEnvironment("image-110-okaxv")
+	if err == nil {
+		t.Fatal("GetProblemEnvironment() should fail")
+	}
+	if strings.Contains(err.Error(), "p@ssw0rd") {
+		t.Errorf("error contains password: %v", err)
+	}
+	if !strings.Contains(err.Error(), "internal error") {
+		t.Errorf("error does not contain the response body: %v", err)
+	}
 }
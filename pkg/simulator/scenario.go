@@ -0,0 +1,99 @@
+package simulator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"golang.org/x/xerrors"
+	"gopkg.in/yaml.v2"
+)
+
+// Distribution 所要時間の分布 (正規分布、負の値は0にする)
+type Distribution struct {
+	MeanSeconds   float64 `yaml:"mean_seconds"`
+	StddevSeconds float64 `yaml:"stddev_seconds"`
+}
+
+// Sample 分布から所要時間を1つ取り出す
+func (d Distribution) Sample(r *rand.Rand) time.Duration {
+	v := d.MeanSeconds + r.NormFloat64()*d.StddevSeconds
+	return time.Duration(math.Max(v, 0) * float64(time.Second))
+}
+
+// ProblemScenario 問題ごとの参加者の振る舞い
+type ProblemScenario struct {
+	MachineImageName string `yaml:"machine_image_name"`
+	// 問題の選ばれやすさ (重み)
+	Popularity float64 `yaml:"popularity"`
+	// 問題を解くのにかかる時間
+	SolveTime Distribution `yaml:"solve_time"`
+	// 採点にかかる時間
+	ScoringTime Distribution `yaml:"scoring_time"`
+}
+
+// Scenario scheduler simulate のシナリオ
+type Scenario struct {
+	// シミュレーションする時間(分)
+	DurationMinutes int `yaml:"duration_minutes"`
+	// schedulerを実行する間隔(秒)
+	SchedulerIntervalSeconds int `yaml:"scheduler_interval_seconds"`
+	// 時系列を記録する間隔(秒)
+	SampleIntervalSeconds int `yaml:"sample_interval_seconds"`
+	// 乱数のseed
+	Seed int64 `yaml:"seed"`
+	// 参加チーム数
+	Teams int `yaml:"teams"`
+	// コンテスト開始時にチームが参加するまでの時間
+	JoinTime Distribution `yaml:"join_time"`
+	// 問題を解き終わってから次の問題を開くまでの時間
+	ThinkTime Distribution `yaml:"think_time"`
+	// VMの作成を依頼してからREADYになるまでの時間
+	BootTime Distribution `yaml:"boot_time"`
+	// trueの場合、開始時点で pool_count 分のREADYなインスタンスがある状態から始める (contest init 済みの状態)
+	Prewarm bool `yaml:"prewarm"`
+	// 設定されている場合は、schedulerの設定ファイルの projects(Zoneごとの上限) の代わりに使う
+	Projects []types.ProjectSetting `yaml:"projects"`
+	// 設定されている場合は、schedulerの設定ファイルの pool_count の代わりに使う (machine_image_name -> pool_count)
+	PoolCounts map[string]int    `yaml:"pool_counts"`
+	Problems   []ProblemScenario `yaml:"problems"`
+}
+
+// LoadScenario シナリオファイルを読み込む
+func LoadScenario(path string) (*Scenario, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &Scenario{}
+	if err := yaml.UnmarshalStrict(bytes, sc); err != nil {
+		return nil, xerrors.Errorf("yaml unmarshal error: %w", err)
+	}
+
+	return sc, sc.validate()
+}
+
+func (sc *Scenario) validate() error {
+	if sc.DurationMinutes <= 0 {
+		return xerrors.New("duration_minutes must be greater than 0")
+	}
+	if sc.Teams <= 0 {
+		return xerrors.New("teams must be greater than 0")
+	}
+	if sc.SchedulerIntervalSeconds <= 0 {
+		sc.SchedulerIntervalSeconds = 10
+	}
+	if sc.SampleIntervalSeconds <= 0 {
+		sc.SampleIntervalSeconds = 60
+	}
+	for _, p := range sc.Problems {
+		if p.Popularity < 0 {
+			return xerrors.New(fmt.Sprintf("problem %s: popularity must not be negative", p.MachineImageName))
+		}
+	}
+	return nil
+}
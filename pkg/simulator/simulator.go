@@ -0,0 +1,529 @@
+package simulator
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/scheduler"
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"go.uber.org/zap"
+	"golang.org/x/xerrors"
+)
+
+// シミュレーション上の開始時刻 (表示には経過時間のみを使う)
+var epoch = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Sample ある時点でのインスタンスと参加者の状況
+type Sample struct {
+	ElapsedMinutes float64                  `json:"elapsed_minutes"`
+	Problems       map[string]ProblemSample `json:"problems"`
+	// "project/zone" -> インスタンス数
+	Zones map[string]int `json:"zones"`
+}
+
+// ProblemSample ある時点での問題ごとの状況
+type ProblemSample struct {
+	Ready    int `json:"ready"`
+	NotReady int `json:"not_ready"`
+	// UNDER_CHALLENGE, UNDER_SCORING なインスタンス数
+	InUse int `json:"in_use"`
+	// READYなインスタンスがなく、割り当てを待っているチーム数
+	Waiting int `json:"waiting"`
+}
+
+// ProblemSummary 問題ごとの集計結果
+type ProblemSummary struct {
+	MachineImageName string `json:"machine_image_name"`
+	PoolCount        int    `json:"pool_count"`
+	Assignments      int    `json:"assignments"`
+	// 問題を開いてからVMが割り当てられるまでの時間(秒)
+	WaitAvgSeconds float64 `json:"wait_avg_seconds"`
+	WaitP95Seconds float64 `json:"wait_p95_seconds"`
+	WaitMaxSeconds float64 `json:"wait_max_seconds"`
+	// READYなインスタンスがなく、チームが待たされていた時間(分)
+	DepletedMinutes float64 `json:"depleted_minutes"`
+	// 初めてチームが待たされた時刻 (開始からの経過分、待たされなかった場合は-1)
+	FirstDepletionMinute float64 `json:"first_depletion_minute"`
+	// 割り当てを待ったままシミュレーションが終わったチーム数
+	StillWaiting int `json:"still_waiting"`
+}
+
+// ZoneSummary Zoneごとの集計結果
+type ZoneSummary struct {
+	ProjectName  string `json:"project"`
+	ZoneName     string `json:"zone"`
+	MaxInstance  int    `json:"max_instance"`
+	PeakInstance int    `json:"peak_instance"`
+	// インスタンス数が max_instance に達していた時間(分)
+	FullMinutes float64 `json:"full_minutes"`
+}
+
+// Result シミュレーションの結果
+type Result struct {
+	Problems []ProblemSummary `json:"problems"`
+	Zones    []ZoneSummary    `json:"zones"`
+	// 全Zoneの合計インスタンス数の最大値
+	PeakInstance   int      `json:"peak_instance"`
+	CreatedCount   int      `json:"created_count"`
+	DeletedCount   int      `json:"deleted_count"`
+	SolvedProblems int      `json:"solved_problems"`
+	TimeSeries     []Sample `json:"time_series"`
+}
+
+type instance struct {
+	name             string
+	machineImageName string
+	problemID        string
+	project          string
+	zone             string
+	innerStatus      types.InnerStatus
+	createdAt        time.Time
+	readyAt          time.Time
+}
+
+type teamState int
+
+const (
+	teamJoining teamState = iota
+	teamThinking
+	teamWaiting
+	teamChallenging
+	teamScoring
+	teamDone
+)
+
+type team struct {
+	state    teamState
+	until    time.Time
+	problem  string
+	since    time.Time
+	instance *instance
+	solved   map[string]bool
+}
+
+// Simulator メモリ上のスコアサーバとvm-management-serverに対して、schedulerの SchedulingList と CreateInstances を実行する
+type Simulator struct {
+	cfg      *types.SchedulerConfig
+	scenario *Scenario
+	rand     *rand.Rand
+	now      time.Time
+
+	instances map[string]*instance
+	teams     []*team
+	serial    int
+	created   int
+	deleted   int
+
+	waits map[string][]float64
+}
+
+// NewSimulator schedulerの設定とシナリオからSimulatorを生成する
+// シナリオに projects, pool_counts がある場合は設定ファイルの値を上書きする
+func NewSimulator(cfg *types.SchedulerConfig, scenario *Scenario) (*Simulator, error) {
+	c := *cfg
+	if len(scenario.Projects) > 0 {
+		c.Setting.Projects = scenario.Projects
+	}
+	c.Setting.Problems = append([]types.ProblemSetting{}, cfg.Setting.Problems...)
+	for i := range c.Setting.Problems {
+		if n, ok := scenario.PoolCounts[c.Setting.Problems[i].MachineImageName]; ok {
+			c.Setting.Problems[i].PoolCount = n
+		}
+	}
+
+	known := map[string]bool{}
+	for _, p := range c.Setting.Problems {
+		known[p.MachineImageName] = true
+	}
+	for _, p := range scenario.Problems {
+		if !known[p.MachineImageName] {
+			return nil, xerrors.New(fmt.Sprintf("problem %s in the scenario is not found in the scheduler config", p.MachineImageName))
+		}
+	}
+
+	s := &Simulator{
+		cfg:       &c,
+		scenario:  scenario,
+		rand:      rand.New(rand.NewSource(scenario.Seed)),
+		now:       epoch,
+		instances: map[string]*instance{},
+		waits:     map[string][]float64{},
+	}
+	for i := 0; i < scenario.Teams; i++ {
+		s.teams = append(s.teams, &team{
+			state:  teamJoining,
+			until:  epoch.Add(scenario.JoinTime.Sample(s.rand)),
+			solved: map[string]bool{},
+		})
+	}
+
+	return s, nil
+}
+
+// Run シミュレーションを実行する
+// 1秒ずつ時間を進め、scheduler_interval_seconds ごとにschedulerを実行する
+func (s *Simulator) Run() *Result {
+	lg := zap.NewNop()
+	end := epoch.Add(time.Duration(s.scenario.DurationMinutes) * time.Minute)
+	schedulerInterval := time.Duration(s.scenario.SchedulerIntervalSeconds) * time.Second
+	sampleInterval := time.Duration(s.scenario.SampleIntervalSeconds) * time.Second
+
+	result := &Result{}
+	depleted := map[string]time.Duration{}
+	firstDepletion := map[string]time.Duration{}
+	peaks := map[string]int{}
+	full := map[string]time.Duration{}
+
+	if s.scenario.Prewarm {
+		s.schedule(lg)
+		for _, i := range s.instances {
+			i.innerStatus = types.InnerStatusReady
+		}
+	}
+
+	for ; !s.now.After(end); s.now = s.now.Add(time.Second) {
+		s.boot()
+		s.stepTeams()
+
+		elapsed := s.now.Sub(epoch)
+		if elapsed%schedulerInterval == 0 {
+			s.schedule(lg)
+		}
+
+		sample := s.sample()
+		total := 0
+		for key, n := range sample.Zones {
+			total += n
+			if n > peaks[key] {
+				peaks[key] = n
+			}
+			if max := s.maxInstance(key); max > 0 && n >= max {
+				full[key] += time.Second
+			}
+		}
+		if total > result.PeakInstance {
+			result.PeakInstance = total
+		}
+		for name, p := range sample.Problems {
+			if p.Ready == 0 && p.Waiting > 0 {
+				if _, ok := firstDepletion[name]; !ok {
+					firstDepletion[name] = elapsed
+				}
+				depleted[name] += time.Second
+			}
+		}
+		if elapsed%sampleInterval == 0 {
+			result.TimeSeries = append(result.TimeSeries, sample)
+		}
+	}
+
+	for _, p := range s.cfg.Setting.Problems {
+		name := p.MachineImageName
+		summary := ProblemSummary{
+			MachineImageName:     name,
+			PoolCount:            p.PoolCount,
+			Assignments:          len(s.waits[name]),
+			DepletedMinutes:      depleted[name].Minutes(),
+			FirstDepletionMinute: -1,
+		}
+		if d, ok := firstDepletion[name]; ok {
+			summary.FirstDepletionMinute = d.Minutes()
+		}
+		summary.WaitAvgSeconds, summary.WaitP95Seconds, summary.WaitMaxSeconds = stats(s.waits[name])
+		for _, t := range s.teams {
+			if t.state == teamWaiting && t.problem == name {
+				summary.StillWaiting++
+			}
+		}
+		result.Problems = append(result.Problems, summary)
+	}
+	sort.Slice(result.Problems, func(i, j int) bool {
+		return result.Problems[i].MachineImageName < result.Problems[j].MachineImageName
+	})
+
+	for _, p := range s.cfg.Setting.Projects {
+		for _, z := range p.Zones {
+			key := p.Name + "/" + z.Name
+			result.Zones = append(result.Zones, ZoneSummary{
+				ProjectName:  p.Name,
+				ZoneName:     z.Name,
+				MaxInstance:  z.MaxInstance,
+				PeakInstance: peaks[key],
+				FullMinutes:  full[key].Minutes(),
+			})
+		}
+	}
+
+	for _, t := range s.teams {
+		result.SolvedProblems += len(t.solved)
+	}
+	result.CreatedCount = s.created
+	result.DeletedCount = s.deleted
+
+	return result
+}
+
+// boot 起動時間が経過したNOT_READYなインスタンスをREADYにする
+func (s *Simulator) boot() {
+	for _, i := range s.instances {
+		if i.innerStatus == types.InnerStatusNotReady && !s.now.Before(i.readyAt) {
+			i.innerStatus = types.InnerStatusReady
+		}
+	}
+}
+
+// stepTeams 各チームの状態を進める
+func (s *Simulator) stepTeams() {
+	ready := s.readyInstances()
+	for _, t := range s.teams {
+		switch t.state {
+		case teamJoining, teamThinking:
+			if s.now.Before(t.until) {
+				continue
+			}
+			t.problem = s.chooseProblem(t)
+			if t.problem == "" {
+				t.state = teamDone
+				continue
+			}
+			t.state = teamWaiting
+			t.since = s.now
+			s.assign(t, ready)
+		case teamWaiting:
+			s.assign(t, ready)
+		case teamChallenging:
+			if s.now.Before(t.until) {
+				continue
+			}
+			t.instance.innerStatus = types.InnerStatusUnderScoring
+			t.state = teamScoring
+			t.until = s.now.Add(s.problemScenario(t.problem).ScoringTime.Sample(s.rand))
+		case teamScoring:
+			if s.now.Before(t.until) {
+				continue
+			}
+			// 解き終わったVMはスコアサーバによってABANDONEDにされ、schedulerが削除する
+			t.instance.innerStatus = types.InnerStatusAbandoned
+			t.instance = nil
+			t.solved[t.problem] = true
+			t.state = teamThinking
+			t.until = s.now.Add(s.scenario.ThinkTime.Sample(s.rand))
+		}
+	}
+}
+
+// chooseProblem まだ解いていない問題から popularity の重みで1つ選ぶ
+func (s *Simulator) chooseProblem(t *team) string {
+	total := 0.0
+	for _, p := range s.scenario.Problems {
+		if !t.solved[p.MachineImageName] {
+			total += p.Popularity
+		}
+	}
+	if total <= 0 {
+		return ""
+	}
+
+	r := s.rand.Float64() * total
+	for _, p := range s.scenario.Problems {
+		if t.solved[p.MachineImageName] || p.Popularity <= 0 {
+			continue
+		}
+		r -= p.Popularity
+		if r < 0 {
+			return p.MachineImageName
+		}
+	}
+	return s.scenario.Problems[len(s.scenario.Problems)-1].MachineImageName
+}
+
+// readyInstances 問題ごとのREADYなインスタンスを古い順に返す
+func (s *Simulator) readyInstances() map[string][]*instance {
+	ready := map[string][]*instance{}
+	for _, i := range s.instances {
+		if i.innerStatus == types.InnerStatusReady {
+			ready[i.machineImageName] = append(ready[i.machineImageName], i)
+		}
+	}
+	for _, instances := range ready {
+		sort.Slice(instances, func(a, b int) bool {
+			if !instances[a].createdAt.Equal(instances[b].createdAt) {
+				return instances[a].createdAt.Before(instances[b].createdAt)
+			}
+			return instances[a].name < instances[b].name
+		})
+	}
+	return ready
+}
+
+// assign READYなインスタンスがあれば、最も古いものをチームに割り当てる
+func (s *Simulator) assign(t *team, ready map[string][]*instance) {
+	if len(ready[t.problem]) == 0 {
+		return
+	}
+	found := ready[t.problem][0]
+	ready[t.problem] = ready[t.problem][1:]
+
+	found.innerStatus = types.InnerStatusUnderChallenge
+	s.waits[t.problem] = append(s.waits[t.problem], s.now.Sub(t.since).Seconds())
+	t.instance = found
+	t.state = teamChallenging
+	t.until = s.now.Add(s.problemScenario(t.problem).SolveTime.Sample(s.rand))
+}
+
+// schedule schedulerの処理 (scheduler start の1回分) を実行する
+func (s *Simulator) schedule(lg *zap.Logger) {
+	problems, zonePriorities := scheduler.InitScheduler(s.cfg, lg)
+	environments := s.environments()
+	problems, zonePriorities, abandonedInstances := scheduler.Aggregate(problems, zonePriorities, environments, lg)
+	scheduler.ExpireInstances(problems, s.now, lg)
+	creationTargetInstances, deletionTargetInstances := scheduler.SchedulingList(problems, zonePriorities, lg)
+	creationTargetInstances, _ = scheduler.ApplyBudget(creationTargetInstances, append(abandonedInstances, deletionTargetInstances...), problems, environments, s.cfg, lg)
+
+	scheduler.DeleteInstances(abandonedInstances, s, 0, nil, lg)
+	scheduler.DeleteInstances(deletionTargetInstances, s, 0, nil, lg)
+	scheduler.CreateInstances(creationTargetInstances, zonePriorities, s, nil, 0, nil, lg)
+}
+
+// environments スコアサーバが返す問題環境情報を生成する
+func (s *Simulator) environments() []types.Environment {
+	names := []string{}
+	for name := range s.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	environments := []types.Environment{}
+	for _, name := range names {
+		i := s.instances[name]
+		innerStatus := string(i.innerStatus)
+		machineImageName := i.machineImageName
+		environments = append(environments, types.Environment{
+			Name:             i.name,
+			InnerStatus:      &innerStatus,
+			ProblemID:        i.problemID,
+			CreatedAt:        i.createdAt,
+			UpdatedAt:        i.createdAt,
+			ProjectName:      i.project,
+			ZoneName:         i.zone,
+			MachineImageName: &machineImageName,
+		})
+	}
+	return environments
+}
+
+// CreateInstance scheduler.InstanceClient の実装
+// 作成したインスタンスは boot_time が経過するとREADYになる
+// 作成は失敗しないため、冪等キーは使わない
+func (s *Simulator) CreateInstance(idempotencyKey, problemID, machineImageName, project, zone string) (*types.Instance, error) {
+	s.serial++
+	s.created++
+	i := &instance{
+		name:             fmt.Sprintf("%s-%05d", machineImageName, s.serial),
+		machineImageName: machineImageName,
+		problemID:        problemID,
+		project:          project,
+		zone:             zone,
+		innerStatus:      types.InnerStatusNotReady,
+		createdAt:        s.now,
+		readyAt:          s.now.Add(s.scenario.BootTime.Sample(s.rand)),
+	}
+	s.instances[i.name] = i
+
+	return &types.Instance{
+		InstanceName:     i.name,
+		MachineImageName: machineImageName,
+		ProblemID:        problemID,
+		Status:           "RUNNING",
+	}, nil
+}
+
+// DeleteInstance scheduler.InstanceClient の実装
+func (s *Simulator) DeleteInstance(name, project, zone string) error {
+	if _, ok := s.instances[name]; !ok {
+		return xerrors.New(fmt.Sprintf("instance not found: %s", name))
+	}
+	delete(s.instances, name)
+	s.deleted++
+	return nil
+}
+
+// sample 現在の状況を集計する
+func (s *Simulator) sample() Sample {
+	sample := Sample{
+		ElapsedMinutes: s.now.Sub(epoch).Minutes(),
+		Problems:       map[string]ProblemSample{},
+		Zones:          map[string]int{},
+	}
+	for _, p := range s.cfg.Setting.Problems {
+		sample.Problems[p.MachineImageName] = ProblemSample{}
+	}
+	for _, p := range s.cfg.Setting.Projects {
+		for _, z := range p.Zones {
+			sample.Zones[p.Name+"/"+z.Name] = 0
+		}
+	}
+
+	for _, i := range s.instances {
+		p := sample.Problems[i.machineImageName]
+		switch i.innerStatus {
+		case types.InnerStatusReady:
+			p.Ready++
+		case types.InnerStatusNotReady:
+			p.NotReady++
+		case types.InnerStatusUnderChallenge, types.InnerStatusUnderScoring:
+			p.InUse++
+		}
+		sample.Problems[i.machineImageName] = p
+		sample.Zones[i.project+"/"+i.zone]++
+	}
+	for _, t := range s.teams {
+		if t.state == teamWaiting {
+			p := sample.Problems[t.problem]
+			p.Waiting++
+			sample.Problems[t.problem] = p
+		}
+	}
+
+	return sample
+}
+
+func (s *Simulator) maxInstance(key string) int {
+	for _, p := range s.cfg.Setting.Projects {
+		for _, z := range p.Zones {
+			if p.Name+"/"+z.Name == key {
+				return z.MaxInstance
+			}
+		}
+	}
+	return 0
+}
+
+func (s *Simulator) problemScenario(name string) ProblemScenario {
+	for _, p := range s.scenario.Problems {
+		if p.MachineImageName == name {
+			return p
+		}
+	}
+	return ProblemScenario{}
+}
+
+// stats 平均値、95パーセンタイル、最大値を返す
+func stats(values []float64) (float64, float64, float64) {
+	if len(values) == 0 {
+		return 0, 0, 0
+	}
+
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+	}
+	p95 := sorted[(len(sorted)*95+99)/100-1]
+
+	return sum / float64(len(sorted)), p95, sorted[len(sorted)-1]
+}
@@ -0,0 +1,79 @@
+package simulator
+
+import (
+	"testing"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+)
+
+func newTestConfig(poolCount, maxInstance int) *types.SchedulerConfig {
+	cfg := &types.SchedulerConfig{}
+	cfg.Setting.Projects = []types.ProjectSetting{
+		{Name: "networkcontest", Zones: []types.ZoneSetting{{Name: "asia-northeast1-b", MaxInstance: maxInstance, Priority: 1}}},
+	}
+	cfg.Setting.Problems = []types.ProblemSetting{
+		{MachineImageName: "image-sc0", ProblemID: "227803fb-2fe1-4b89-a805-79e7679bf030", PoolCount: poolCount},
+	}
+	return cfg
+}
+
+func newTestScenario() *Scenario {
+	sc := &Scenario{
+		DurationMinutes: 120,
+		Seed:            1,
+		Teams:           10,
+		Prewarm:         true,
+		BootTime:        Distribution{MeanSeconds: 300},
+		Problems: []ProblemScenario{
+			{MachineImageName: "image-sc0", Popularity: 1, SolveTime: Distribution{MeanSeconds: 600}, ScoringTime: Distribution{MeanSeconds: 60}},
+		},
+	}
+	sc.validate()
+	return sc
+}
+
+func Test_Simulator(t *testing.T) {
+	tests := []struct {
+		name         string
+		poolCount    int
+		maxInstance  int
+		wantDepleted bool
+	}{
+		// 全チームが同時に開いても足りる
+		{name: "enough pool", poolCount: 10, maxInstance: 30, wantDepleted: false},
+		// Zoneの上限が少なく、待たされるチームが出る
+		{name: "zone quota", poolCount: 10, maxInstance: 3, wantDepleted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim, err := NewSimulator(newTestConfig(tt.poolCount, tt.maxInstance), newTestScenario())
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := sim.Run()
+
+			p := result.Problems[0]
+			if p.Assignments != 10 {
+				t.Errorf("Assignments = %d, want 10", p.Assignments)
+			}
+			if depleted := p.DepletedMinutes > 0; depleted != tt.wantDepleted {
+				t.Errorf("DepletedMinutes = %f, want depleted: %v", p.DepletedMinutes, tt.wantDepleted)
+			}
+			if result.Zones[0].PeakInstance > tt.maxInstance {
+				t.Errorf("PeakInstance = %d, should not exceed max_instance %d", result.Zones[0].PeakInstance, tt.maxInstance)
+			}
+			if len(result.TimeSeries) != 121 {
+				t.Errorf("len(TimeSeries) = %d, want 121", len(result.TimeSeries))
+			}
+		})
+	}
+}
+
+func Test_NewSimulator_UnknownProblem(t *testing.T) {
+	sc := newTestScenario()
+	sc.Problems[0].MachineImageName = "image-unknown"
+	if _, err := NewSimulator(newTestConfig(1, 1), sc); err == nil {
+		t.Errorf("NewSimulator() should return an error for a problem not in the config")
+	}
+}
@@ -0,0 +1,232 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+	"golang.org/x/xerrors"
+)
+
+// Exporter 終了したspanを送る
+type Exporter interface {
+	Export(serviceName string, spans []*Span) error
+	Shutdown() error
+}
+
+// OTLP/JSON (https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding) の ExportTraceServiceRequest
+// trace_id, span_id はhex文字列、64bitの整数は文字列で表す
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+// otlpStatus code は 0: UNSET, 1: OK, 2: ERROR
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+const scopeName = "github.com/janog-netcon/netcon-cli"
+
+// MarshalOTLP spans をOTLP/JSONの ExportTraceServiceRequest にする
+func MarshalOTLP(serviceName string, spans []*Span) ([]byte, error) {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: unixNano(s.StartTime),
+			EndTimeUnixNano:   unixNano(s.EndTime),
+			Attributes:        otlpAttributes(s.Attributes),
+		}
+		if s.parentSpanID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parentSpanID[:])
+		}
+		if s.Error != "" {
+			span.Status = otlpStatus{Code: 2, Message: s.Error}
+		}
+		s.mu.Unlock()
+		otlpSpans = append(otlpSpans, span)
+	}
+
+	return json.Marshal(otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: otlpAttributes([]Attribute{String("service.name", serviceName)}),
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: scopeName},
+				Spans: otlpSpans,
+			}},
+		}},
+	})
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func otlpAttributes(attrs []Attribute) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		kv := otlpKeyValue{Key: a.Key}
+		switch v := a.Value.(type) {
+		case int64:
+			s := strconv.FormatInt(v, 10)
+			kv.Value.IntValue = &s
+		case bool:
+			b := v
+			kv.Value.BoolValue = &b
+		case string:
+			s := v
+			kv.Value.StringValue = &s
+		default:
+			continue
+		}
+		kvs = append(kvs, kv)
+	}
+	return kvs
+}
+
+// OTLPExporter OTLP/HTTP (JSON) で <Endpoint>/v1/traces にspanを送る
+type OTLPExporter struct {
+	Endpoint string
+	// リクエストに付けるヘッダ (認証など)
+	Headers map[string]string
+	Timeout time.Duration
+}
+
+// NewOTLPExporter OTLP/HTTPのcollectorにspanを送るExporterを返す
+func NewOTLPExporter(endpoint string, headers map[string]string) *OTLPExporter {
+	return &OTLPExporter{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		Headers:  headers,
+		Timeout:  10 * time.Second,
+	}
+}
+
+// Export spans を送る
+func (e *OTLPExporter) Export(serviceName string, spans []*Span) error {
+	body, err := MarshalOTLP(serviceName, spans)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", e.Endpoint+"/v1/traces", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+
+	cli := &http.Client{Timeout: e.Timeout}
+	resp, err := cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return xerrors.Errorf("status code not 200: status code is %d: body: %s", resp.StatusCode, types.RedactBody(respBody))
+	}
+	return nil
+}
+
+// Shutdown 何もしない
+func (e *OTLPExporter) Shutdown() error {
+	return nil
+}
+
+// FileExporter spanをOTLP/JSONの ExportTraceServiceRequest として1行ずつファイルに追記する
+// (OpenTelemetry Collector の file exporter と同じ形式のため、otlpjsonfilereceiver などで読み込める)
+type FileExporter struct {
+	Path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileExporter path に追記するExporterを返す
+func NewFileExporter(path string) (*FileExporter, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{Path: path, file: file}, nil
+}
+
+// Export spans を1行で書き込む
+func (e *FileExporter) Export(serviceName string, spans []*Span) error {
+	body, err := MarshalOTLP(serviceName, spans)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.file.Write(append(body, '\n'))
+	return err
+}
+
+// Shutdown ファイルを閉じる
+func (e *FileExporter) Shutdown() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
@@ -0,0 +1,446 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/xerrors"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+)
+
+const (
+	ExporterOTLP = "otlp"
+	ExporterFile = "file"
+
+	// TraceparentHeader W3C Trace Context でトレースを伝搬するヘッダ
+	TraceparentHeader = "traceparent"
+
+	defaultServiceName  = "netcon-scheduler"
+	defaultOTLPEndpoint = "http://localhost:4318"
+
+	// 送っていないspanを保持する上限 (超えた分は破棄する)
+	maxQueueSize = 2048
+	// 1回で送るspanの上限
+	maxExportBatchSize = 512
+	// Shutdown で残っているspanを送るのを待つ時間
+	shutdownTimeout = 10 * time.Second
+)
+
+// SpanKind OTLPの Span.kind
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindClient   SpanKind = 3
+)
+
+// Attribute spanに付ける属性
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String 文字列の属性
+func String(key, value string) Attribute { return Attribute{Key: key, Value: value} }
+
+// Int 整数の属性
+func Int(key string, value int) Attribute { return Attribute{Key: key, Value: int64(value)} }
+
+// Bool 真偽値の属性
+func Bool(key string, value bool) Attribute { return Attribute{Key: key, Value: value} }
+
+// Tracer schedulerの1回の実行とその中のAPI呼び出しをspanとして記録し、Exporterに送る
+// 送るのはバックグラウンドのgoroutineで行い、schedulerの実行を待たせない
+// nilの場合はspanを記録しない (全てのメソッドはnilでも呼び出せる)
+type Tracer struct {
+	ServiceName string
+
+	exporter Exporter
+	lg       *zap.Logger
+
+	mu sync.Mutex
+	// Start で開始し、まだ終了していない一番内側のspan
+	// schedulerの各処理は順番に実行されるため、API呼び出しのspanはこのspanの子にする
+	active *Span
+	// 終了して、まだ送っていないspan
+	ended []*Span
+	// maxQueueSize を超えたため破棄したspanの数 (次に送るときにログに出力する)
+	dropped int
+
+	// Flush で送る合図
+	flush chan struct{}
+	// Shutdown で閉じる
+	stop chan struct{}
+	// バックグラウンドのgoroutineが終了したら閉じる
+	done chan struct{}
+}
+
+// NewTracer 設定に従ってTracerを返す
+// tracing.exporter が空の場合はnilを返す
+func NewTracer(cfg *types.SchedulerConfig, lg *zap.Logger) (*Tracer, error) {
+	tc := cfg.Setting.Tracing
+	if tc.Exporter == "" {
+		return nil, nil
+	}
+
+	var exporter Exporter
+	switch tc.Exporter {
+	case ExporterOTLP:
+		endpoint := tc.Endpoint
+		if endpoint == "" {
+			endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		}
+		if endpoint == "" {
+			endpoint = defaultOTLPEndpoint
+		}
+		// gRPC には対応していない (OpenTelemetry SDK を使わず、OTLP/HTTP (JSON) で送るため)
+		if protocol := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); protocol == "grpc" {
+			return nil, xerrors.Errorf("tracing: OTEL_EXPORTER_OTLP_PROTOCOL=%s is not supported: use an OTLP/HTTP endpoint (port 4318)", protocol)
+		}
+		// OTEL_EXPORTER_OTLP_HEADERS に設定ファイルの headers を上書きする
+		headers, err := ParseOTLPHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+		if err != nil {
+			return nil, xerrors.Errorf("tracing: OTEL_EXPORTER_OTLP_HEADERS: %w", err)
+		}
+		for k, v := range tc.Headers {
+			headers[k] = v
+		}
+		exporter = NewOTLPExporter(endpoint, headers)
+	case ExporterFile:
+		if tc.FilePath == "" {
+			return nil, xerrors.New("tracing: file_path is required when exporter is file")
+		}
+		fe, err := NewFileExporter(tc.FilePath)
+		if err != nil {
+			return nil, xerrors.Errorf("tracing: %w", err)
+		}
+		exporter = fe
+	default:
+		return nil, xerrors.Errorf("tracing: unknown exporter %q: must be %s or %s", tc.Exporter, ExporterOTLP, ExporterFile)
+	}
+
+	serviceName := tc.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	return NewTracerWithExporter(serviceName, exporter, lg), nil
+}
+
+// NewTracerWithExporter exporter にspanを送るTracerを返す
+// 送るためのgoroutineを起動するため、使い終わったら Shutdown を呼ぶ
+func NewTracerWithExporter(serviceName string, exporter Exporter, lg *zap.Logger) *Tracer {
+	t := &Tracer{
+		ServiceName: serviceName,
+		exporter:    exporter,
+		lg:          lg,
+		flush:       make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start 実行中のspanの子として(なければ新しいtraceとして)spanを開始し、実行中のspanにする
+// 返したspanの End を呼ぶと、実行中のspanは親に戻る
+func (t *Tracer) Start(name string, attrs ...Attribute) *Span {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.newSpan(t.active, name, SpanKindInternal, attrs)
+	t.active = s
+	return s
+}
+
+// StartClient 実行中のspanの子としてAPI呼び出しのspanを開始する
+// 実行中のspanは変更しないため、複数のgoroutineから呼び出せる
+func (t *Tracer) StartClient(name string, attrs ...Attribute) *Span {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.newSpan(t.active, name, SpanKindClient, attrs)
+}
+
+func (t *Tracer) newSpan(parent *Span, name string, kind SpanKind, attrs []Attribute) *Span {
+	s := &Span{
+		tracer:     t,
+		parent:     parent,
+		Name:       name,
+		Kind:       kind,
+		spanID:     newSpanID(),
+		StartTime:  time.Now(),
+		Attributes: attrs,
+	}
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parentSpanID = parent.spanID
+	} else {
+		s.traceID = newTraceID()
+	}
+	return s
+}
+
+// Do req に traceparent ヘッダを付けて送り、API呼び出しをspanとして記録する
+// 4xx, 5xx のレスポンスはspanをエラーにする
+func (t *Tracer) Do(cli *http.Client, req *http.Request, name string, attrs ...Attribute) (*http.Response, error) {
+	span := t.StartClient(name, append([]Attribute{
+		String("http.request.method", req.Method),
+		String("url.full", req.URL.String()),
+		String("server.address", req.URL.Host),
+	}, attrs...)...)
+	defer span.End()
+
+	span.Inject(req.Header)
+	resp, err := cli.Do(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttributes(Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetError(xerrors.Errorf("status code %d", resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// Flush 終了したspanをバックグラウンドで送る。送り終わるのは待たない
+func (t *Tracer) Flush() {
+	if t == nil {
+		return
+	}
+	select {
+	case t.flush <- struct{}{}:
+	default:
+		// 既に合図している
+	}
+}
+
+// Shutdown 残っているspanを送り(shutdownTimeout まで待つ)、Exporterを閉じる
+// 2回目以降の呼び出しは何もしない
+func (t *Tracer) Shutdown() error {
+	if t == nil {
+		return nil
+	}
+	select {
+	case <-t.stop:
+		return nil
+	default:
+	}
+	close(t.stop)
+
+	select {
+	case <-t.done:
+	case <-time.After(shutdownTimeout):
+		t.lg.Warn("Tracing: Timed out to export remaining spans")
+	}
+	return t.exporter.Shutdown()
+}
+
+// run Flush されるたびに、終了したspanを maxExportBatchSize ずつ送る
+// Shutdown されたら残りを送って終了する
+// 送れなかった場合もspanは破棄し、ログを出力するだけにする (tracingの失敗でschedulerを止めない)
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	for {
+		select {
+		case <-t.flush:
+			t.export()
+		case <-t.stop:
+			t.export()
+			return
+		}
+	}
+}
+
+// export 終了したspanを全て送る
+func (t *Tracer) export() {
+	for {
+		t.mu.Lock()
+		n := len(t.ended)
+		if n > maxExportBatchSize {
+			n = maxExportBatchSize
+		}
+		spans := t.ended[:n]
+		t.ended = t.ended[n:]
+		dropped := t.dropped
+		t.dropped = 0
+		t.mu.Unlock()
+
+		if dropped > 0 {
+			t.lg.Warn("Tracing: Dropped spans because the queue is full", zap.Int("spans", dropped))
+		}
+		if len(spans) == 0 {
+			return
+		}
+		if err := t.exporter.Export(t.ServiceName, spans); err != nil {
+			t.lg.Warn("Tracing: Failed to export spans", zap.Int("spans", len(spans)), zap.Error(err))
+		}
+	}
+}
+
+func (t *Tracer) end(s *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.active == s {
+		t.active = s.parent
+	}
+	// 送るのが間に合わない場合は、メモリを使い続けないように破棄する
+	if len(t.ended) >= maxQueueSize {
+		t.dropped++
+		return
+	}
+	t.ended = append(t.ended, s)
+}
+
+// Span 1つの処理の開始から終了まで
+// nilの場合は何も記録しない
+type Span struct {
+	tracer *Tracer
+	parent *Span
+
+	Name         string
+	Kind         SpanKind
+	traceID      [16]byte
+	spanID       [8]byte
+	parentSpanID [8]byte
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   []Attribute
+	// 空でない場合はエラーで終了した
+	Error string
+
+	mu    sync.Mutex
+	ended bool
+}
+
+// SetAttributes 属性を追加する
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes = append(s.Attributes, attrs...)
+}
+
+// SetError spanをエラーにする。errがnilの場合は何もしない
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = err.Error()
+}
+
+// End spanを終了する。2回目以降の呼び出しは無視する
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+
+	s.tracer.end(s)
+}
+
+// TraceID trace-id をhex文字列で返す (ログとの対応付けに使う)
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// Traceparent W3C Trace Context の traceparent ヘッダの値を返す
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(s.traceID[:]), hex.EncodeToString(s.spanID[:]))
+}
+
+// Inject h に traceparent ヘッダを設定する
+func (s *Span) Inject(h http.Header) {
+	if s == nil {
+		return
+	}
+	h.Set(TraceparentHeader, s.Traceparent())
+}
+
+// ParseOTLPHeaders OTEL_EXPORTER_OTLP_HEADERS の値 (key1=value1,key2=value2) を解析する
+// 値はパーセントエンコードされているものとしてデコードする
+func ParseOTLPHeaders(v string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(v, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i <= 0 {
+			return nil, xerrors.Errorf("invalid header %q: must be key=value", pair)
+		}
+		key := strings.TrimSpace(pair[:i])
+		value, err := url.PathUnescape(strings.TrimSpace(pair[i+1:]))
+		if err != nil {
+			return nil, xerrors.Errorf("invalid header %q: %w", pair, err)
+		}
+		headers[key] = value
+	}
+	return headers, nil
+}
+
+// ParseTraceparent traceparent ヘッダの値から trace-id と parent-id を取り出す
+func ParseTraceparent(v string) (traceID [16]byte, spanID [8]byte, err error) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, spanID, xerrors.Errorf("invalid traceparent %q", v)
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, spanID, xerrors.Errorf("invalid traceparent %q: %w", v, err)
+	}
+	if _, err := hex.Decode(spanID[:], []byte(parts[2])); err != nil {
+		return traceID, spanID, xerrors.Errorf("invalid traceparent %q: %w", v, err)
+	}
+	if traceID == [16]byte{} || spanID == [8]byte{} {
+		return traceID, spanID, xerrors.Errorf("invalid traceparent %q: all zero id", v)
+	}
+	return traceID, spanID, nil
+}
+
+func newTraceID() (id [16]byte) {
+	for id == [16]byte{} {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() (id [8]byte) {
+	for id == [8]byte{} {
+		rand.Read(id[:])
+	}
+	return id
+}
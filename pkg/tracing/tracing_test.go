@@ -0,0 +1,252 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/janog-netcon/netcon-cli/pkg/types"
+)
+
+func TestTracer(t *testing.T) {
+	var traceparent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		traceparent = req.Header.Get(TraceparentHeader)
+		w.WriteHeader(http.StatusGatewayTimeout)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "netcon-trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "trace.json")
+	exporter, err := NewFileExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTracerWithExporter("test", exporter, zap.NewNop())
+
+	root := tr.Start("SchedulerReady")
+	phase := tr.Start("CreateInstances")
+	req, _ := http.NewRequest("POST", ts.URL+"/instance", nil)
+	resp, err := tr.Do(&http.Client{}, req, "vmms.CreateInstance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	phase.End()
+	// 子のspanが終了した後は親のspanの子になる
+	after := tr.Start("Record")
+	after.End()
+	root.End()
+	if err := tr.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	traceID, spanID, err := ParseTraceparent(traceparent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(traceID[:]); got != root.TraceID() {
+		t.Errorf("traceparent trace-id: got %s, want %s", got, root.TraceID())
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d lines, want 1", len(lines))
+	}
+	var exported otlpRequest
+	if err := json.Unmarshal([]byte(lines[0]), &exported); err != nil {
+		t.Fatal(err)
+	}
+	spans := map[string]otlpSpan{}
+	for _, s := range exported.ResourceSpans[0].ScopeSpans[0].Spans {
+		spans[s.Name] = s
+	}
+	if len(spans) != 4 {
+		t.Fatalf("got %d spans, want 4", len(spans))
+	}
+
+	parents := map[string]string{
+		"SchedulerReady":      "",
+		"CreateInstances":     "SchedulerReady",
+		"vmms.CreateInstance": "CreateInstances",
+		"Record":              "SchedulerReady",
+	}
+	for name, parent := range parents {
+		want := ""
+		if parent != "" {
+			want = spans[parent].SpanID
+		}
+		if got := spans[name].ParentSpanID; got != want {
+			t.Errorf("%s parentSpanId: got %q, want %q (%s)", name, got, want, parent)
+		}
+		if spans[name].TraceID != root.TraceID() {
+			t.Errorf("%s traceId: got %s, want %s", name, spans[name].TraceID, root.TraceID())
+		}
+	}
+
+	client := spans["vmms.CreateInstance"]
+	if got := hex.EncodeToString(spanID[:]); got != client.SpanID {
+		t.Errorf("traceparent parent-id: got %s, want %s", got, client.SpanID)
+	}
+	if client.Kind != SpanKindClient || client.Status.Code != 2 {
+		t.Errorf("vmms.CreateInstance: got kind %d status %d, want client span with error status", client.Kind, client.Status.Code)
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tr *Tracer
+	span := tr.Start("SchedulerReady")
+	span.SetAttributes(Int("n", 1))
+	span.End()
+	tr.Flush()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if v := req.Header.Get(TraceparentHeader); v != "" {
+			t.Errorf("traceparent should not be sent: %s", v)
+		}
+	}))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	resp, err := tr.Do(&http.Client{}, req, "scoreserver.ListProblem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+// blockingExporter release が閉じられるまで Export を返さない Exporter
+type blockingExporter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	spans   int
+}
+
+func (e *blockingExporter) Export(serviceName string, spans []*Span) error {
+	<-e.release
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans += len(spans)
+	return nil
+}
+
+func (e *blockingExporter) Shutdown() error { return nil }
+
+func TestTracerFlushAsync(t *testing.T) {
+	exporter := &blockingExporter{release: make(chan struct{})}
+	tr := NewTracerWithExporter("test", exporter, zap.NewNop())
+
+	// collectorが応答しなくても Flush は待たない
+	for i := 0; i < 3; i++ {
+		tr.Start("SchedulerReady").End()
+		flushed := make(chan struct{})
+		go func() {
+			tr.Flush()
+			close(flushed)
+		}()
+		select {
+		case <-flushed:
+		case <-time.After(time.Second):
+			t.Fatal("Flush() blocked on the exporter")
+		}
+	}
+
+	// Shutdown は残っているspanを送り終わるまで待つ
+	close(exporter.release)
+	if err := tr.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if exporter.spans != 3 {
+		t.Errorf("exported %d spans, want 3", exporter.spans)
+	}
+	if err := tr.Shutdown(); err != nil {
+		t.Errorf("second Shutdown() = %v", err)
+	}
+}
+
+func TestParseOTLPHeaders(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{value: "", want: map[string]string{}},
+		{value: "api-key=secret", want: map[string]string{"api-key": "secret"}},
+		{value: "Authorization=Bearer%20a+b, x-tenant = netcon ,", want: map[string]string{"Authorization": "Bearer a+b", "x-tenant": "netcon"}},
+		{value: "api-key", wantErr: true},
+		{value: "=secret", wantErr: true},
+		{value: "api-key=%zz", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseOTLPHeaders(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseOTLPHeaders(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseOTLPHeaders(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestNewTracerOTLPEnv(t *testing.T) {
+	for _, key := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_PROTOCOL"} {
+		if v, ok := os.LookupEnv(key); ok {
+			defer os.Setenv(key, v)
+		} else {
+			defer os.Unsetenv(key)
+		}
+	}
+
+	var got http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req.Header
+	}))
+	defer ts.Close()
+
+	cfg := &types.SchedulerConfig{}
+	cfg.Setting.Tracing.Exporter = ExporterOTLP
+	cfg.Setting.Tracing.Endpoint = ts.URL
+	cfg.Setting.Tracing.Headers = map[string]string{"x-tenant": "config"}
+
+	os.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "grpc")
+	if _, err := NewTracer(cfg, zap.NewNop()); err == nil {
+		t.Error("NewTracer() with OTEL_EXPORTER_OTLP_PROTOCOL=grpc should return error")
+	}
+	os.Unsetenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+
+	// 設定ファイルの headers を OTEL_EXPORTER_OTLP_HEADERS より優先する
+	os.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "api-key=secret,x-tenant=env")
+	tr, err := NewTracer(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Start("SchedulerReady").End()
+	if err := tr.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("api-key") != "secret" || got.Get("x-tenant") != "config" {
+		t.Errorf("headers: got %v", got)
+	}
+}
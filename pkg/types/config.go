@@ -0,0 +1,198 @@
+package types
+
+// SchedulerConfig schedulerの設定ファイルで使用する
+type SchedulerConfig struct {
+	Setting Setting `yaml:"setting"`
+}
+
+// ContestDefinition コンテストの定義ファイル
+// schedulerの設定(SchedulerConfig)と contest init のマッピング情報を1つにまとめたもの
+// SchedulerConfig の setting 以下をトップレベルに置き、問題ごとに contest init で作成する場所(placements)を持つ
+type ContestDefinition struct {
+	Version int `yaml:"version"`
+	Setting `yaml:",inline"`
+}
+
+// ContestDefinitionVersion 現在の ContestDefinition のバージョン
+const ContestDefinitionVersion = 1
+
+// Setting schedulerと contest init で使用する設定
+type Setting struct {
+	Scoreserver struct {
+		Endpoint string `yaml:"endpoint"`
+		// vmdb-apiが updated_since クエリに対応している場合にtrueにする
+		UseUpdatedSince bool `yaml:"use_updated_since"`
+		// use_updated_since の場合も、この秒数ごとに一覧を取得し直す (0の場合は300秒)
+		FullListInterval int `yaml:"full_list_interval,omitempty"`
+	} `yaml:"scoreserver"`
+	Vmms struct {
+		Endpoint   string `yaml:"endpoint"`
+		Credential string `yaml:"credential"`
+		// credential が空の場合はこのファイルの内容をcredentialとして使う
+		CredentialFile string `yaml:"credential_file,omitempty"`
+		// API呼び出し1回のタイムアウト(秒) (0の場合は120秒)
+		Timeout int `yaml:"timeout,omitempty"`
+	} `yaml:"vmms"`
+	Cron      string `yaml:"cron"`
+	Scheduler struct {
+		InstanceCreationInterval int `yaml:"instance_creation_interval"`
+		InstanceDeletionInterval int `yaml:"instance_deletion_interval"`
+		// 作成を要求したインスタンスが問題環境情報に現れるまで、作成中として数える秒数 (0の場合は300秒)
+		PendingCreationTimeout int `yaml:"pending_creation_timeout,omitempty"`
+	} `yaml:"scheduler"`
+	Notifier struct {
+		Webhooks []struct {
+			URL string `yaml:"url"`
+		} `yaml:"webhooks"`
+		// 同じアラートを再送するまでの秒数
+		RepeatInterval int `yaml:"repeat_interval"`
+		Rules          struct {
+			// 何回連続でインスタンスの作成に失敗したら通知するか
+			CreateFailureThreshold int `yaml:"create_failure_threshold"`
+			// NOT_READYのまま何秒経過したら通知するか
+			NotReadyTimeout int `yaml:"not_ready_timeout"`
+		} `yaml:"rules"`
+	} `yaml:"notifier"`
+	Receiver struct {
+		// スコアサーバからのcallbackを受け付けるアドレス (空の場合は起動しない)
+		ListenAddress string `yaml:"listen_address"`
+		// 設定されている場合は `Authorization: Bearer <token>` を要求する
+		Token string `yaml:"token"`
+		// callbackを受けてからschedulerを実行するまでに待つミリ秒数
+		DebounceMs int `yaml:"debounce_ms"`
+	} `yaml:"receiver"`
+	Prober struct {
+		// trueの場合、READYなインスタンスの各サービスに接続できるかを確認する
+		Enabled bool `yaml:"enabled"`
+		// 1回の接続を待つミリ秒数
+		TimeoutMs int `yaml:"timeout_ms"`
+		// 何回連続で失敗したらインスタンスを作り直すか
+		FailureThreshold int `yaml:"failure_threshold"`
+		// trueの場合、HTTPSのサービスはTLSのhandshakeまで確認する
+		TLS bool `yaml:"tls"`
+		// trueの場合、SSHのサービスはSSHのbannerを受信できるかまで確認する
+		SSHBanner bool `yaml:"ssh_banner"`
+		// 同時に接続するインスタンス数
+		Concurrency int `yaml:"concurrency"`
+	} `yaml:"prober"`
+	Budget struct {
+		// 全Zone合計のインスタンス数の上限 (0の場合は無制限)
+		MaxInstance int `yaml:"max_instance"`
+		// 1時間あたりの費用の上限 (0の場合は無制限)
+		HourlyBudget float64 `yaml:"hourly_budget"`
+		// machine_type ごとの1時間あたりの費用
+		MachineTypes map[string]float64 `yaml:"machine_types,omitempty"`
+	} `yaml:"budget"`
+	LeaderElection struct {
+		// trueの場合、リースを取得したレプリカだけがschedulerを実行し、他のレプリカは待機する
+		Enabled bool `yaml:"enabled"`
+		// リースを保存する場所 (空の場合は file)
+		Backend string `yaml:"backend,omitempty"`
+		// backend が file の場合のリースを保存するファイル
+		LockFile string `yaml:"lock_file,omitempty"`
+		// リースの有効期間(秒) (0の場合は15秒)
+		LeaseDuration int `yaml:"lease_duration,omitempty"`
+		// レプリカの識別子 (空の場合は <hostname>-<pid>)
+		ID string `yaml:"id,omitempty"`
+	} `yaml:"leader_election"`
+	Tracing struct {
+		// spanの送り先 (otlp, file)。空の場合はtracingを無効にする
+		Exporter string `yaml:"exporter,omitempty"`
+		// exporter が otlp の場合のOTLP/HTTPのエンドポイント (空の場合は OTEL_EXPORTER_OTLP_ENDPOINT、それもなければ http://localhost:4318)
+		Endpoint string `yaml:"endpoint,omitempty"`
+		// exporter が otlp の場合にリクエストに付けるヘッダ (OTEL_EXPORTER_OTLP_HEADERS より優先する)
+		Headers map[string]string `yaml:"headers,omitempty"`
+		// exporter が file の場合の出力先
+		FilePath string `yaml:"file_path,omitempty"`
+		// service.name (空の場合は netcon-scheduler)
+		ServiceName string `yaml:"service_name,omitempty"`
+	} `yaml:"tracing"`
+	Projects []ProjectSetting `yaml:"projects"`
+	Problems []ProblemSetting `yaml:"problems"`
+}
+
+// ProjectSetting インスタンスを作成するGCP Projectと、そのZoneごとの上限・優先度
+type ProjectSetting struct {
+	Name  string        `yaml:"name"`
+	Zones []ZoneSetting `yaml:"zones"`
+}
+
+// ZoneSetting Zoneごとのインスタンス数の上限と優先度
+type ZoneSetting struct {
+	Name        string `yaml:"name"`
+	MaxInstance int    `yaml:"max_instance"`
+	Priority    int    `yaml:"priority"`
+}
+
+// ProblemSetting 問題ごとの設定
+type ProblemSetting struct {
+	MachineImageName string `yaml:"machine_image_name"`
+	PoolCount        int    `yaml:"pool_count"`
+	ProblemID        string `yaml:"problem_id"`
+	// READYなまま max_ready_age 秒を超えたインスタンスは作り直す (0の場合は作り直さない)
+	MaxReadyAge int `yaml:"max_ready_age,omitempty"`
+	// インスタンスのマシンタイプ (budget.machine_types から1時間あたりの費用を求める)
+	MachineType string `yaml:"machine_type,omitempty"`
+	// 1時間あたりの費用 (設定されている場合は machine_type より優先する)
+	HourlyCost float64 `yaml:"hourly_cost,omitempty"`
+	// pool_count を超えたインスタンスを削除する順番 (newest, oldest, not_ready_first, low_priority_zone, busiest_zone)
+	// 省略した場合は newest
+	DeletionPolicy string `yaml:"deletion_policy,omitempty"`
+	// contest init でインスタンスを作成する場所 (schedulerは使用しない)
+	Placements []Placement `yaml:"placements,omitempty"`
+}
+
+// Placement contest init でインスタンスを作成する場所と台数
+type Placement struct {
+	Project string `yaml:"project"`
+	Zone    string `yaml:"zone"`
+	// 0の場合は contest init の --count の値を使う
+	Count int `yaml:"count,omitempty"`
+}
+
+// Mapping contest init で使用する、問題とMachine Image、作成する場所の対応
+type Mapping struct {
+	ProblemID        string `yaml:"problem_id"`
+	MachineImageName string `yaml:"machine_image_name"`
+	Project          string `yaml:"project"`
+	Zone             string `yaml:"zone"`
+	// 0の場合は contest init の --count の値を使う
+	Count int `yaml:"count,omitempty"`
+}
+
+// Mappings ContestDefinition から contest init で使用するマッピング情報を生成する
+func (d *ContestDefinition) Mappings() []Mapping {
+	ml := []Mapping{}
+	for _, p := range d.Problems {
+		for _, pl := range p.Placements {
+			ml = append(ml, Mapping{
+				ProblemID:        p.ProblemID,
+				MachineImageName: p.MachineImageName,
+				Project:          pl.Project,
+				Zone:             pl.Zone,
+				Count:            pl.Count,
+			})
+		}
+	}
+	return ml
+}
+
+// SchedulerConfig ContestDefinition からschedulerの設定を生成する
+func (d *ContestDefinition) SchedulerConfig() *SchedulerConfig {
+	return &SchedulerConfig{Setting: d.Setting}
+}
+
+// HourlyCost machine_image_name のインスタンス1台あたりの1時間の費用を返す
+// 問題の hourly_cost、machine_type に対応する budget.machine_types の順に使い、どちらもなければ0を返す
+func (s *Setting) HourlyCost(machineImageName string) float64 {
+	for _, p := range s.Problems {
+		if p.MachineImageName != machineImageName {
+			continue
+		}
+		if p.HourlyCost > 0 {
+			return p.HourlyCost
+		}
+		return s.Budget.MachineTypes[p.MachineType]
+	}
+	return 0
+}
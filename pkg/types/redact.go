@@ -0,0 +1,90 @@
+package types
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// RedactedSecret パスワードなどの秘密情報を表示しないときに代わりに表示する文字列
+const RedactedSecret = "********"
+
+// maxBodyLength エラーメッセージに含めるレスポンスボディの最大バイト数
+const maxBodyLength = 256
+
+// RedactSecret 秘密情報を RedactedSecret に置き換える
+// 空文字列の場合は、値が設定されていないことがわかるようにそのまま返す
+func RedactSecret(s string) string {
+	if s == "" {
+		return s
+	}
+	return RedactedSecret
+}
+
+// RedactString s に含まれる secret を RedactedSecret に置き換える
+// エラーメッセージに含まれるレスポンスボディなどから秘密情報を取り除くために使う
+func RedactString(s, secret string) string {
+	if secret == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, secret, RedactedSecret)
+}
+
+// RedactBody エラーメッセージに含めるためにレスポンスボディから秘密情報を取り除く
+// JSONの場合はキーに password を含む値を RedactedSecret に置き換え、
+// どちらの場合も maxBodyLength バイトまでに切り詰める
+func RedactBody(body []byte) string {
+	s := string(body)
+
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err == nil {
+		if b, err := json.Marshal(redactJSON(v)); err == nil {
+			s = string(b)
+		}
+	}
+
+	if len(s) > maxBodyLength {
+		s = s[:maxBodyLength] + "...(truncated)"
+	}
+	return s
+}
+
+// redactJSON JSONの値に含まれる password を再帰的に伏せる
+func redactJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if strings.Contains(strings.ToLower(k), "password") {
+				if s, ok := e.(string); ok {
+					v[k] = RedactSecret(s)
+					continue
+				}
+				v[k] = RedactedSecret
+				continue
+			}
+			v[k] = redactJSON(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = redactJSON(e)
+		}
+	}
+	return v
+}
+
+// Redacted Password を伏せた ProblemEnvironment を返す
+func (pe ProblemEnvironment) Redacted() ProblemEnvironment {
+	pe.Password = RedactSecret(pe.Password)
+	return pe
+}
+
+// Redacted Password を伏せた Environment を返す
+func (e Environment) Redacted() Environment {
+	e.Password = RedactSecret(e.Password)
+	return e
+}
+
+// Redacted Password を伏せた Instance を返す
+func (i Instance) Redacted() Instance {
+	i.Password = RedactSecret(i.Password)
+	return i
+}
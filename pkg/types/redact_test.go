@@ -0,0 +1,72 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Redacted(t *testing.T) {
+	pe := ProblemEnvironment{Name: "image-sc0-aaaaa", Password: "p@ssw0rd"}
+	if got := pe.Redacted(); got.Password != RedactedSecret || got.Name != pe.Name {
+		t.Errorf("ProblemEnvironment.Redacted() = %#v", got)
+	}
+	if pe.Password != "p@ssw0rd" {
+		t.Errorf("ProblemEnvironment.Redacted() modified the receiver")
+	}
+
+	i := Instance{InstanceName: "image-sc0-aaaaa"}
+	if got := i.Redacted(); got.Password != "" {
+		t.Errorf("Instance.Redacted() = %q, want empty password to stay empty", got.Password)
+	}
+}
+
+func Test_RedactString(t *testing.T) {
+	tests := []struct {
+		s, secret, want string
+	}{
+		{`{"error":"invalid token: abcdef"}`, "abcdef", `{"error":"invalid token: ********"}`},
+		{"no secret here", "abcdef", "no secret here"},
+		{"empty secret", "", "empty secret"},
+	}
+	for _, tt := range tests {
+		if got := RedactString(tt.s, tt.secret); got != tt.want {
+			t.Errorf("RedactString(%q, %q) = %q, want %q", tt.s, tt.secret, got, tt.want)
+		}
+	}
+}
+
+func Test_RedactBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "json",
+			body: `[{"name":"image-sc0-aaaaa","password":"p@ssw0rd","problem":{"admin_password":"secret"}}]`,
+			want: `[{"name":"image-sc0-aaaaa","password":"********","problem":{"admin_password":"********"}}]`,
+		},
+		{
+			name: "empty password",
+			body: `{"password":""}`,
+			want: `{"password":""}`,
+		},
+		{
+			name: "not json",
+			body: "Internal Server Error",
+			want: "Internal Server Error",
+		},
+		{
+			name: "too long",
+			body: strings.Repeat("a", maxBodyLength+1),
+			want: strings.Repeat("a", maxBodyLength) + "...(truncated)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactBody([]byte(tt.body)); got != tt.want {
+				t.Errorf("RedactBody() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package types
+
+import (
+	"fmt"
+
+	"golang.org/x/xerrors"
+)
+
+// InnerStatus スコアサーバが管理しているVMの状態
+type InnerStatus string
+
+const (
+	// InnerStatusNotReady 準備中
+	InnerStatusNotReady InnerStatus = ProblemEnvironmentInnerStatusNotReady
+	// InnerStatusReady プール中
+	InnerStatusReady InnerStatus = ProblemEnvironmentInnerStatusReady
+	// InnerStatusUnderChallenge ユーザが解答中
+	InnerStatusUnderChallenge InnerStatus = ProblemEnvironmentInnerStatusUnderChallenge
+	// InnerStatusUnderScoring 採点中
+	InnerStatusUnderScoring InnerStatus = ProblemEnvironmentInnerStatusUnderScoring
+	// InnerStatusAbandoned 破棄した(問題を解き終わって不要になった)
+	InnerStatusAbandoned InnerStatus = ProblemEnvironmentInnerStatusAbandoned
+	// InnerStatusUnknown schedulerが知らない状態
+	// スコアサーバ側で状態が追加された場合などに使う。無視せずに集計して可視化する
+	InnerStatusUnknown InnerStatus = "UNKNOWN"
+)
+
+// InnerStatuses 定義されている状態の一覧 (InnerStatusUnknown は含まない)
+var InnerStatuses = []InnerStatus{
+	InnerStatusNotReady,
+	InnerStatusReady,
+	InnerStatusUnderChallenge,
+	InnerStatusUnderScoring,
+	InnerStatusAbandoned,
+}
+
+// innerStatusTransitions 状態遷移表
+// key の状態から遷移できる状態の一覧
+//
+// NOT_READY -> READY -> UNDER_CHALLENGE <-> UNDER_SCORING
+// どの状態からでも ABANDONED に遷移できる
+var innerStatusTransitions = map[InnerStatus][]InnerStatus{
+	InnerStatusNotReady:       {InnerStatusReady, InnerStatusAbandoned},
+	InnerStatusReady:          {InnerStatusUnderChallenge, InnerStatusAbandoned},
+	InnerStatusUnderChallenge: {InnerStatusUnderScoring, InnerStatusAbandoned},
+	InnerStatusUnderScoring:   {InnerStatusUnderChallenge, InnerStatusAbandoned},
+	InnerStatusAbandoned:      {},
+}
+
+// ParseInnerStatus 文字列を InnerStatus に変換する
+// 空文字はスコアサーバがまだ触れていないVMなので READY として扱う
+// 知らない状態の場合は InnerStatusUnknown とエラーを返す
+func ParseInnerStatus(s string) (InnerStatus, error) {
+	if s == "" {
+		return InnerStatusReady, nil
+	}
+
+	for _, status := range InnerStatuses {
+		if string(status) == s {
+			return status, nil
+		}
+	}
+
+	return InnerStatusUnknown, xerrors.New(fmt.Sprintf("unknown inner status: %s", s))
+}
+
+// NormalizeInnerStatus スコアサーバから返ってきた inner_status を InnerStatus に変換する
+// スコアサーバがまだ触れていないVMの inner_status は nil(または空文字)になっているため READY として扱う
+// 知らない状態の場合は InnerStatusUnknown を返す
+func NormalizeInnerStatus(s *string) InnerStatus {
+	if s == nil {
+		return InnerStatusReady
+	}
+
+	status, _ := ParseInnerStatus(*s)
+	return status
+}
+
+// CanTransitionTo s から next に遷移できるかを返す
+// 同じ状態への遷移(変化なし)は常に許可する
+func (s InnerStatus) CanTransitionTo(next InnerStatus) bool {
+	if s == next {
+		return true
+	}
+
+	for _, status := range innerStatusTransitions[s] {
+		if status == next {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsPooled プールしているVM(参加者に割り当てられていないVM)の状態かを返す
+func (s InnerStatus) IsPooled() bool {
+	return s == InnerStatusReady || s == InnerStatusNotReady
+}
@@ -0,0 +1,48 @@
+package types
+
+import "testing"
+
+func Test_NormalizeInnerStatus(t *testing.T) {
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		in   *string
+		want InnerStatus
+	}{
+		{nil, InnerStatusReady},
+		{str(""), InnerStatusReady},
+		{str("READY"), InnerStatusReady},
+		{str("NOT_READY"), InnerStatusNotReady},
+		{str("UNDER_CHALLENGE"), InnerStatusUnderChallenge},
+		{str("UNDER_SCORING"), InnerStatusUnderScoring},
+		{str("ABANDONED"), InnerStatusAbandoned},
+		{str("SUSPENDED"), InnerStatusUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeInnerStatus(tt.in); got != tt.want {
+			t.Errorf("NormalizeInnerStatus(%v): got %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func Test_CanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from, to InnerStatus
+		want     bool
+	}{
+		{InnerStatusNotReady, InnerStatusReady, true},
+		{InnerStatusReady, InnerStatusUnderChallenge, true},
+		{InnerStatusUnderScoring, InnerStatusUnderChallenge, true},
+		{InnerStatusReady, InnerStatusReady, true},
+		{InnerStatusUnderChallenge, InnerStatusAbandoned, true},
+		{InnerStatusAbandoned, InnerStatusReady, false},
+		{InnerStatusUnderChallenge, InnerStatusReady, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.from.CanTransitionTo(tt.to); got != tt.want {
+			t.Errorf("%s -> %s: got %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
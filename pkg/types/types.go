@@ -92,6 +92,96 @@ type ProblemEnvironment struct {
 	MachineImageName *string   `json:"machine_image_name"`
 }
 
+// Problem スコアサーバに登録されている問題
+// ID は問題ページのURLに含まれるUUIDで、ProblemEnvironment.ProblemID と同じ値になる
+// Code は問題の識別子で、Machine Imageの名前は image-<code> にすることが多い
+type Problem struct {
+	ID    string `json:"id"`
+	Code  string `json:"code"`
+	Title string `json:"title"`
+}
+
+// Endpoint VMが提供しているサービスの接続先
+type Endpoint struct {
+	Service string `json:"service"`
+	Host    string `json:"host"`
+	Port    int    `json:"port"`
+}
+
+// Environment 1台のVMの情報
+// スコアサーバはVMのサービス(SSH, HTTPS)ごとに ProblemEnvironment を返すため、Nameでまとめたもの
+type Environment struct {
+	Name             string     `json:"name"`
+	InnerStatus      *string    `json:"inner_status"`
+	Status           *string    `json:"status"`
+	User             string     `json:"user"`
+	Password         string     `json:"password"`
+	ProblemID        string     `json:"problem_id"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	ProjectName      string     `json:"project"`
+	ZoneName         string     `json:"zone"`
+	MachineImageName *string    `json:"machine_image_name"`
+	Services         []Endpoint `json:"services"`
+}
+
+// NewEnvironments ProblemEnvironment をNameごとにまとめて Environment にする
+// 順番は最初に出現した順になる
+// CreatedAt は最も古い値、UpdatedAt は最も新しい値を使う
+func NewEnvironments(problemEnvironments []ProblemEnvironment) []Environment {
+	environments := []Environment{}
+	index := map[string]int{}
+
+	for _, pe := range problemEnvironments {
+		endpoint := Endpoint{
+			Service: pe.Service,
+			Host:    pe.Host,
+			Port:    pe.Port,
+		}
+
+		i, ok := index[pe.Name]
+		if !ok {
+			index[pe.Name] = len(environments)
+			environments = append(environments, Environment{
+				Name:             pe.Name,
+				InnerStatus:      pe.InnerStatus,
+				Status:           pe.Status,
+				User:             pe.User,
+				Password:         pe.Password,
+				ProblemID:        pe.ProblemID,
+				CreatedAt:        pe.CreatedAt,
+				UpdatedAt:        pe.UpdatedAt,
+				ProjectName:      pe.ProjectName,
+				ZoneName:         pe.ZoneName,
+				MachineImageName: pe.MachineImageName,
+				Services:         []Endpoint{endpoint},
+			})
+			continue
+		}
+
+		e := &environments[i]
+		e.Services = append(e.Services, endpoint)
+		if pe.CreatedAt.Before(e.CreatedAt) {
+			e.CreatedAt = pe.CreatedAt
+		}
+		if pe.UpdatedAt.After(e.UpdatedAt) {
+			e.UpdatedAt = pe.UpdatedAt
+		}
+	}
+
+	return environments
+}
+
+// Service 指定したサービスの接続先を返す
+func (e *Environment) Service(service string) (Endpoint, bool) {
+	for _, s := range e.Services {
+		if s.Service == service {
+			return s, true
+		}
+	}
+	return Endpoint{}, false
+}
+
 // Instance vm-management-serverから返ってくるinstanceのobject
 type Instance struct {
 	InstanceName     string `json:"instance_name" validate:"required" example:"problem-sc0-li5qj"`
@@ -102,34 +192,3 @@ type Instance struct {
 	UserID           string `json:"user_id" validate:"required" example:"j47-user"`
 	Password         string `json:"password" validate:"required" example:"xxxxxxxx"`
 }
-
-// SchedulerConfig schedulerの設定ファイルで使用する
-type SchedulerConfig struct {
-	Setting struct {
-		Scoreserver struct {
-			Endpoint string `yaml:"endpoint"`
-		} `yaml:"scoreserver"`
-		Vmms struct {
-			Endpoint   string `yaml:"endpoint"`
-			Credential string `yaml:"credential"`
-		} `yaml:"vmms"`
-		Cron      string `yaml:"cron"`
-		Scheduler struct {
-			InstanceCreationInterval int `yaml:"instance_creation_interval"`
-			InstanceDeletionInterval int `yaml:"instance_deletion_interval"`
-		} `yaml:"scheduler"`
-		Projects []struct {
-			Name  string `yaml:"name"`
-			Zones []struct {
-				Name        string `yaml:"name"`
-				MaxInstance int    `yaml:"max_instance"`
-				Priority    int    `yaml:"priority"`
-			} `yaml:"zones"`
-		} `yaml:"projects"`
-		Problems []struct {
-			MachineImageName string `yaml:"machine_image_name"`
-			PoolCount        int    `yaml:"pool_count"`
-			ProblemID        string `yaml:"problem_id"`
-		} `yaml:"problems"`
-	} `yaml:"setting"`
-}
@@ -5,16 +5,102 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"time"
 
+	"github.com/gofrs/uuid"
+	"github.com/janog-netcon/netcon-cli/pkg/tracing"
 	"github.com/janog-netcon/netcon-cli/pkg/types"
 	"github.com/sacloud/libsacloud/v2/helper/validate"
 	"golang.org/x/xerrors"
 )
 
+// defaultTimeout API呼び出し1回のタイムアウト (VMの作成は数十秒かかることがあるため長めにする)
+const defaultTimeout = 2 * time.Minute
+
+// IdempotencyKeyHeader インスタンス作成の冪等キーを送るヘッダ
+// 同じキーで作成を要求された場合、vm-management-serverは新しく作成せずに前回作成したインスタンスを返す
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// NewIdempotencyKey インスタンス作成の冪等キーを生成する
+func NewIdempotencyKey() string {
+	return uuid.Must(uuid.NewV4()).String()
+}
+
+// StatusError vm-management-serverが200以外を返した
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status code not 200: status code is %d: body: %s", e.StatusCode, e.Body)
+}
+
+// notSentError リクエストを送る前に失敗した
+type notSentError struct {
+	err error
+}
+
+func (e *notSentError) Error() string { return e.err.Error() }
+func (e *notSentError) Unwrap() error { return e.err }
+
+// MayBeApplied err を返したリクエストが、vm-management-server側では処理されている可能性があるかを返す
+// タイムアウトなどで応答が得られなかった場合、ゲートウェイのエラー(502, 503, 504)の場合、
+// 200が返ったがbodyを読めなかった場合はtrue、リクエストを送る前のエラーやその他のステータスコードの場合はfalse
+func MayBeApplied(err error) bool {
+	if err == nil {
+		return true
+	}
+	var notSent *notSentError
+	if xerrors.As(err, &notSent) {
+		return false
+	}
+	// Client.Timeout を過ぎた場合は、vm-management-serverが作成を終えてから応答する前に切断した可能性がある
+	if IsTimeout(err) {
+		return true
+	}
+	var statusErr *StatusError
+	if xerrors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// IsTimeout err がタイムアウトで応答が得られなかったことを表すかを返す
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return xerrors.As(err, &netErr) && netErr.Timeout()
+}
+
 type Client struct {
 	Endpoint   string
 	Credential string
+	// API呼び出し1回のタイムアウト (0の場合はタイムアウトしない)
+	Timeout time.Duration
+	// API呼び出しをspanとして記録する (nilの場合は記録しない)
+	Tracer *tracing.Tracer
+}
+
+// String Credential を含めずに表示する
+func (c *Client) String() string {
+	return fmt.Sprintf("vmms.Client{Endpoint: %s, Credential: %s}", c.Endpoint, types.RedactSecret(c.Credential))
+}
+
+// GoString %#v で表示されたときも Credential を含めない
+func (c *Client) GoString() string {
+	return c.String()
+}
+
+// redact エラーメッセージに含める文字列から Credential を取り除く
+// vm-management-serverがエラー時にリクエストヘッダを返すことがあるため
+func (c *Client) redact(s string) string {
+	return types.RedactString(s, c.Credential)
 }
 
 // NewClient vm-management-serverのクライアントを返す
@@ -22,9 +108,15 @@ func NewClient(endpoint, credential string) *Client {
 	return &Client{
 		Endpoint:   endpoint,
 		Credential: credential,
+		Timeout:    defaultTimeout,
 	}
 }
 
+// httpClient Timeout を設定した http.Client を返す
+func (c *Client) httpClient() *http.Client {
+	return &http.Client{Timeout: c.Timeout}
+}
+
 type createInstanceRequestBody struct {
 	ProblemID        string `json:"problem_id" validate:"required,uuid"`
 	MachineImageName string `json:"machine_image_name" validate:"required" example:"problem-sc0"`
@@ -48,7 +140,8 @@ type createInstanceErrorResponseBody struct {
 }
 
 // CreateInstance VMを作成する
-func (c *Client) CreateInstance(problemID, machineImageName, project, zone string) (*types.Instance, error) {
+// idempotencyKey を指定した場合は、リトライしても同じキーのVMが重複して作成されないように Idempotency-Key ヘッダで送る
+func (c *Client) CreateInstance(idempotencyKey, problemID, machineImageName, project, zone string) (*types.Instance, error) {
 	u := fmt.Sprintf("%s/instance", c.Endpoint)
 
 	reqBody := createInstanceRequestBody{
@@ -59,23 +152,31 @@ func (c *Client) CreateInstance(problemID, machineImageName, project, zone strin
 	}
 
 	if err := validate.Struct(reqBody); err != nil {
-		return nil, err
+		return nil, &notSentError{err}
 	}
 
 	reqBodyByte, err := json.Marshal(reqBody)
 	if err != nil {
-		return nil, err
+		return nil, &notSentError{err}
 	}
 
 	req, err := http.NewRequest("POST", u, bytes.NewBuffer(reqBodyByte))
 	if err != nil {
-		return nil, err
+		return nil, &notSentError{err}
 	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Credential))
 	req.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		req.Header.Set(IdempotencyKeyHeader, idempotencyKey)
+	}
 
-	cli := &http.Client{}
-	resp, err := cli.Do(req)
+	cli := c.httpClient()
+	resp, err := c.Tracer.Do(cli, req, "vmms.CreateInstance",
+		tracing.String("netcon.machine_image_name", machineImageName),
+		tracing.String("netcon.project", project),
+		tracing.String("netcon.zone", zone),
+		tracing.String("netcon.idempotency_key", idempotencyKey),
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -85,12 +186,12 @@ func (c *Client) CreateInstance(problemID, machineImageName, project, zone strin
 	body, _ := ioutil.ReadAll(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, xerrors.New(fmt.Sprintf("status code not 200: status code is %d: body: %s", resp.StatusCode, body))
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: c.redact(string(body))}
 	}
 
 	var respBody createInstanceResponseBody
 	if err := json.Unmarshal(body, &respBody); err != nil {
-		return nil, xerrors.Errorf("body %s:json unmarshal error: %w", respBody, err)
+		return nil, xerrors.Errorf("json unmarshal error: %w", err)
 	}
 
 	instance := respBody.Response.Instance
@@ -134,8 +235,12 @@ func (c *Client) DeleteInstance(name, project, zone string) error {
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.Credential))
 	req.Header.Set("Content-Type", "application/json")
 
-	cli := &http.Client{}
-	resp, err := cli.Do(req)
+	cli := c.httpClient()
+	resp, err := c.Tracer.Do(cli, req, "vmms.DeleteInstance",
+		tracing.String("netcon.instance", name),
+		tracing.String("netcon.project", project),
+		tracing.String("netcon.zone", zone),
+	)
 	if err != nil {
 		return err
 	}
@@ -144,7 +249,7 @@ func (c *Client) DeleteInstance(name, project, zone string) error {
 	body, _ := ioutil.ReadAll(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
-		return xerrors.New(fmt.Sprintf("status code not 200: status code is %d: body: %s", resp.StatusCode, body))
+		return &StatusError{StatusCode: resp.StatusCode, Body: c.redact(string(body))}
 	}
 
 	return nil
@@ -0,0 +1,89 @@
+package vmms
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_CreateInstanceTimeout(t *testing.T) {
+	// 1回目のリクエストはVMを作成してから、タイムアウトを過ぎた後に応答する
+	var mu sync.Mutex
+	created := map[string]string{}
+	requests := 0
+	release := make(chan struct{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		n := requests
+		key := r.Header.Get(IdempotencyKeyHeader)
+		name, ok := created[key]
+		if !ok {
+			name = fmt.Sprintf("image-sc0-%d", len(created))
+			created[key] = name
+		}
+		mu.Unlock()
+
+		if n == 1 {
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"response": map[string]interface{}{"instance_name": name},
+		})
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	cli := NewClient(ts.URL, "secret")
+	cli.Timeout = 100 * time.Millisecond
+	key := NewIdempotencyKey()
+
+	_, err := cli.CreateInstance(key, "227803fb-2fe1-4b89-a805-79e7679bf030", "image-sc0", "networkcontest", "asia-northeast1-b")
+	if err == nil {
+		t.Fatal("CreateInstance() should time out")
+	}
+	if !IsTimeout(err) {
+		t.Errorf("IsTimeout(%v) = false, want true", err)
+	}
+	// 作成されている可能性があるため、作成中として数え続ける
+	if !MayBeApplied(err) {
+		t.Errorf("MayBeApplied(%v) = false, want true", err)
+	}
+
+	// 同じ冪等キーでリトライすると、作成済みのVMが返り重複して作成されない
+	instance, err := cli.CreateInstance(key, "227803fb-2fe1-4b89-a805-79e7679bf030", "image-sc0", "networkcontest", "asia-northeast1-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(created) != 1 || instance.InstanceName != created[key] {
+		t.Errorf("got %s, created %v", instance.InstanceName, created)
+	}
+}
+
+func Test_MayBeApplied(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "not sent", err: &notSentError{fmt.Errorf("invalid")}, want: false},
+		{name: "bad request", err: &StatusError{StatusCode: http.StatusBadRequest}, want: false},
+		{name: "gateway timeout", err: &StatusError{StatusCode: http.StatusGatewayTimeout}, want: true},
+		{name: "unknown", err: fmt.Errorf("EOF"), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MayBeApplied(tt.err); got != tt.want {
+				t.Errorf("MayBeApplied() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}